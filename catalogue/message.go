@@ -2,8 +2,12 @@ package catalogue
 
 // Code generated by dev catalogue command DO NOT EDIT
 
-import ()
+import (
+	domainsb_deploy "github.com/watermint/switchbox/domain/sb_deploy"
+)
 
 func AutoDetectedMessageObjects() []interface{} {
-	return []interface{}{}
+	return []interface{}{
+		&domainsb_deploy.MsgBinSrcS3{},
+	}
 }
@@ -11,6 +11,10 @@ import (
 func AutoDetectedRecipes() []infra_recipe_rc_recipe.Recipe {
 	return []infra_recipe_rc_recipe.Recipe{
 		&recipedeploy.Link{},
+		&recipedeploy.List{},
+		&recipedeploy.Prune{},
+		&recipedeploy.Rollback{},
+		&recipedeploy.Uninstall{},
 		&recipedeploy.Update{},
 		&recipedispatch.Run{},
 	}
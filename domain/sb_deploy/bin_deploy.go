@@ -17,11 +17,15 @@ type BinDeploy interface {
 	// version is found.
 	GetLocalLatest() (binaryPath string, version es_version.Version, err error)
 
-	// ListLocalVersions List local versions
+	// ListLocalVersions List local versions that satisfy the version constraint
 	ListLocalVersions() (versions []es_version.Version, versionPaths map[string]string, err error)
 
-	// ListRemoteVersions List remote versions
-	ListRemoteVersions() (versions []es_version.Version, versionPaths map[string]string, err error)
+	// ListRemoteVersions List remote versions that satisfy the version constraint
+	ListRemoteVersions() (versions []es_version.Version, versionPaths map[string]string, metas map[string]BinRemoteVersionMeta, err error)
+
+	// ListRemoteVersionStatus List remote versions in descending order, with the location of the asset and the state.
+	// The latest is the version that the update chooses, by the update policy and rejections of the signature.
+	ListRemoteVersionStatus() (versions []BinDeployRemoteVersion, err error)
 
 	// Download version to temporary path
 	Download(version es_version.Version, versionPath string) (downloadPath string, err error)
@@ -29,13 +33,54 @@ type BinDeploy interface {
 	// Extract version into cellar
 	Extract(version es_version.Version, downloadPath string) (cellarPath string, err error)
 
-	// DeploySymlink Deploy latest binary as symlink
+	// DeploySymlink Deploy latest binary as symlink, or in the deploy mode of the recipe.
+	// Existing files are replaced atomically, and the file not deployed by switchbox is not replaced.
 	DeploySymlink() (err error)
 
+	// DeploySymlinkForce Deploy latest binary like DeploySymlink, and replace the file not deployed by switchbox.
+	DeploySymlinkForce() (err error)
+
 	// BinaryName returns the binary name that consider current OS platform
 	BinaryName() string
 
+	// DeployPath returns the path to deploy symlink to the binary.
+	DeployPath() string
+
 	// LocalLatestBinaryPath returns the path to the latest binary. Returns empty string
 	// if no local version found.
 	LocalLatestBinaryPath() string
+
+	// LocalLatestBinaryPathOf returns the path to the binary of the name or the alias in the latest version.
+	// Returns the binary name if the binary is empty, and empty string if no local version found.
+	LocalLatestBinaryPathOf(binary string) (binaryPath string, err error)
+
+	// IsDeployed returns true if all binaries are deployed to the deploy path in the deploy mode,
+	// and deployed from the version to use.
+	IsDeployed() bool
+
+	// Prune removes versions in the cellar by the retention policy.
+	// Returns results of all versions without removing anything, if dryRun is true.
+	Prune(dryRun bool) (results []BinDeployPruneResult, err error)
+
+	// Rollback records the version to use instead of the latest version, then deploys symlink to the version.
+	// The previous version of the current version is used if the version is empty.
+	// The record is kept until ClearRollback.
+	Rollback(version string) (rolledBack es_version.Version, err error)
+
+	// ClearRollback clears the rollback record, then deploys symlink to the latest version.
+	ClearRollback() (err error)
+
+	// Uninstall removes files deployed to the deploy path, versions and states in the cellar, and the remote version cache.
+	// Files not created by switchbox are reported as skipped and kept.
+	// Returns results of all files without removing anything, if dryRun is true.
+	Uninstall(dryRun bool) (results []BinDeployUninstallResult, err error)
+}
+
+// BinDeployRemoteVersion is the remote version that satisfies the version constraint and the channel.
+type BinDeployRemoteVersion struct {
+	Version   string `json:"version"`
+	Channel   string `json:"channel"`
+	Path      string `json:"path"`
+	Installed bool   `json:"installed"`
+	Latest    bool   `json:"latest"`
 }
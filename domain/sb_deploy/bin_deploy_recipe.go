@@ -0,0 +1,219 @@
+package sb_deploy
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/watermint/toolbox/essentials/log/esl"
+	"github.com/watermint/toolbox/infra/control/app_control"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrorUnknownSourceType = errors.New("unknown source type")
+	ErrorNoSourceAvailable = errors.New("no source available")
+)
+
+// BinDeployRecipe is the common part of the deploy JSON.
+// SourceType chooses the recipe of the source. The source type is `dropbox` if empty.
+// Sources is the list of mirrors in priority order. Each entry is the source part of the recipe
+// (e.g. `source_type` and `source_url`), and other fields are inherited from the top level.
+type BinDeployRecipe struct {
+	SourceType string            `json:"source_type,omitempty"`
+	Sources    []json.RawMessage `json:"sources,omitempty"`
+}
+
+// BinSourceAuth acquires the authorization required by the source, like an API client or a credential.
+// The peerName is the account alias given by the `-peer` option.
+type BinSourceAuth func(ctl app_control.Control, recipe interface{}, peerName string) (auth interface{}, err error)
+
+// BinSourceRegistration describes the source backend.
+type BinSourceRegistration struct {
+	// SourceType is the value of the `source_type` in the deploy JSON.
+	SourceType string
+
+	// Recipe returns the pointer to the new recipe model of the source.
+	Recipe func() interface{}
+
+	// Auth is nil if the source does not require authorization.
+	Auth BinSourceAuth
+
+	// New creates BinSource instance from the recipe and the result of Auth (nil if Auth is nil).
+	New func(ctl app_control.Control, recipe interface{}, auth interface{}) (BinSource, error)
+}
+
+var (
+	binSourceRegistry      = make(map[string]BinSourceRegistration)
+	binSourceRegistryMutex sync.Mutex
+)
+
+// RegisterBinSource registers the source backend.
+// The registration replaces the existing one of the same source type.
+func RegisterBinSource(reg BinSourceRegistration) {
+	binSourceRegistryMutex.Lock()
+	defer binSourceRegistryMutex.Unlock()
+	binSourceRegistry[reg.SourceType] = reg
+}
+
+// BinSourceTypes returns registered source types in sorted order.
+func BinSourceTypes() []string {
+	binSourceRegistryMutex.Lock()
+	defer binSourceRegistryMutex.Unlock()
+	types := make([]string, 0, len(binSourceRegistry))
+	for t := range binSourceRegistry {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+func lookupBinSource(sourceType string) (reg BinSourceRegistration, found bool) {
+	binSourceRegistryMutex.Lock()
+	defer binSourceRegistryMutex.Unlock()
+	if sourceType == "" {
+		sourceType = SourceTypeDropbox
+	}
+	reg, found = binSourceRegistry[sourceType]
+	return
+}
+
+// NewBinDeploy creates BinDeploy instance from the deploy JSON file.
+// The peerName is the account alias used only for the source that requires authorization.
+func NewBinDeploy(ctl app_control.Control, recipePath string, peerName string) (BinDeploy, error) {
+	l := ctl.Log().With(esl.String("recipePath", recipePath))
+	content, err := os.ReadFile(recipePath)
+	if err != nil {
+		l.Debug("Unable to read the recipe", esl.Error(err))
+		return nil, err
+	}
+	return NewBinDeployFromJson(ctl, content, peerName)
+}
+
+// NewBinDeployFromJson creates BinDeploy instance from the content of the deploy JSON.
+// Templates like `{{.OS}}-{{.Arch}}` in fields are resolved by the running platform.
+// The source of each fallback suffix is added after sources of the suffix, as mirrors in priority order.
+func NewBinDeployFromJson(ctl app_control.Control, content []byte, peerName string) (BinDeploy, error) {
+	l := ctl.Log()
+	content, err := utilPlatformResolveRecipe(content, utilPlatformCurrent())
+	if err != nil {
+		l.Debug("Unable to resolve templates of the recipe", esl.Error(err))
+		return nil, err
+	}
+	header := &BinDeployRecipe{}
+	if err := json.Unmarshal(content, header); err != nil {
+		l.Debug("Unable to parse the recipe", esl.Error(err))
+		return nil, err
+	}
+	dst := BinDstLocalRecipe{}
+	if err := json.Unmarshal(content, &dst); err != nil {
+		l.Debug("Unable to parse the recipe", esl.Error(err))
+		return nil, err
+	}
+	suffixes := []string{dst.Suffix}
+	for _, suffix := range dst.SuffixFallbacks {
+		if !slices.Contains(suffixes, suffix) {
+			suffixes = append(suffixes, suffix)
+		}
+	}
+
+	if len(header.Sources) < 1 && len(suffixes) < 2 {
+		source, err := newBinSourceFromJson(ctl, content, peerName)
+		if err != nil {
+			return nil, err
+		}
+		return newBinDstLocal(dst, ctl, source), nil
+	}
+
+	top := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(content, &top); err != nil {
+		l.Debug("Unable to parse the recipe", esl.Error(err))
+		return nil, err
+	}
+	delete(top, "sources")
+	entries := header.Sources
+	if len(entries) < 1 {
+		// the single source with fallback suffixes
+		entries = []json.RawMessage{[]byte("{}")}
+	}
+
+	sources := make([]BinSource, 0)
+	var lastErr error
+	for _, suffix := range suffixes {
+		for i, entry := range entries {
+			ll := l.With(esl.Int("sourceIndex", i), esl.String("suffix", suffix))
+			mirror := make(map[string]json.RawMessage)
+			for k, v := range top {
+				mirror[k] = v
+			}
+			if err := json.Unmarshal(entry, &mirror); err != nil {
+				ll.Debug("Unable to parse the source", esl.Error(err))
+				return nil, err
+			}
+			if mirror["suffix"], err = json.Marshal(suffix); err != nil {
+				return nil, err
+			}
+			mirrorContent, err := json.Marshal(mirror)
+			if err != nil {
+				ll.Debug("Unable to compose the source", esl.Error(err))
+				return nil, err
+			}
+			source, err := newBinSourceFromJson(ctl, mirrorContent, peerName)
+			if errors.Is(err, ErrorUnknownSourceType) || (err != nil && len(header.Sources) < 1) {
+				return nil, err
+			}
+			if err != nil {
+				ll.Warn("Skip the source", esl.Error(err))
+				lastErr = err
+				continue
+			}
+			if len(suffixes) > 1 {
+				source = newBinSrcSuffix(source, suffix)
+			}
+			sources = append(sources, source)
+		}
+	}
+	if len(sources) < 1 {
+		if lastErr == nil {
+			lastErr = ErrorNoSourceAvailable
+		}
+		return nil, lastErr
+	}
+	return newBinDstLocal(dst, ctl, newBinSrcMirror(ctl, sources)), nil
+}
+
+func newBinSourceFromJson(ctl app_control.Control, content []byte, peerName string) (BinSource, error) {
+	l := ctl.Log()
+	header := &BinDeployRecipe{}
+	if err := json.Unmarshal(content, header); err != nil {
+		l.Debug("Unable to parse the recipe", esl.Error(err))
+		return nil, err
+	}
+
+	reg, found := lookupBinSource(header.SourceType)
+	if !found {
+		l.Debug("Unknown source type",
+			esl.String("sourceType", header.SourceType),
+			esl.Strings("available", BinSourceTypes()))
+		return nil, ErrorUnknownSourceType
+	}
+	l = l.With(esl.String("sourceType", reg.SourceType))
+
+	recipe := reg.Recipe()
+	if err := json.Unmarshal(content, recipe); err != nil {
+		l.Debug("Unable to parse the recipe", esl.Error(err))
+		return nil, err
+	}
+
+	var auth interface{}
+	if reg.Auth != nil {
+		var err error
+		auth, err = reg.Auth(ctl, recipe, peerName)
+		if err != nil {
+			l.Debug("Unable to acquire the authorization", esl.Error(err))
+			return nil, err
+		}
+	}
+	return reg.New(ctl, recipe, auth)
+}
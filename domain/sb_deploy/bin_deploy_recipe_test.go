@@ -0,0 +1,147 @@
+package sb_deploy
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/watermint/toolbox/infra/control/app_control"
+	"github.com/watermint/toolbox/quality/recipe/qtr_endtoend"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewBinDeployFromJson(t *testing.T) {
+	qtr_endtoend.TestWithControl(t, func(ctl app_control.Control) {
+		sourcePath := t.TempDir()
+		testLocalDirSource(t, sourcePath, "linux-amd64", "1.0.0", "1.2.0")
+
+		content, err := json.Marshal(map[string]string{
+			"source_type": SourceTypeLocalDir,
+			"source_path": sourcePath,
+			"binary_name": "myapp",
+			"prefix":      "myapp",
+			"suffix":      "linux-amd64",
+			"cellar_path": filepath.Join(t.TempDir(), "cellar"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		worker, err := NewBinDeployFromJson(ctl, content, "default")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := worker.BinaryName(); n != utilBinaryName("myapp") {
+			t.Error(n)
+		}
+		if versions, _, _, err := worker.ListRemoteVersions(); err != nil || len(versions) != 2 {
+			t.Error(versions, err)
+		}
+
+		_, err = NewBinDeployFromJson(ctl, []byte(`{"source_type":"no_such_source"}`), "default")
+		if !errors.Is(err, ErrorUnknownSourceType) {
+			t.Error(err)
+		}
+	})
+}
+
+func TestNewBinDeployFromJson_Platform(t *testing.T) {
+	qtr_endtoend.TestWithControl(t, func(ctl app_control.Control) {
+		platform := utilPlatformCurrent()
+		sourcePath := t.TempDir()
+		testLocalDirSource(t, sourcePath, platform.OS+"-"+platform.Arch, "1.0.0")
+		testLocalDirSource(t, sourcePath, platform.OS+"-universal", "1.0.0", "1.1.0")
+		deployPath := t.TempDir()
+
+		content, err := json.Marshal(map[string]interface{}{
+			"source_type":      SourceTypeLocalDir,
+			"source_path":      sourcePath,
+			"binary_name":      "myapp{{.Ext}}",
+			"prefix":           "myapp",
+			"suffix":           "{{.OS}}-{{.Arch}}",
+			"suffix_fallbacks": []string{"{{.OS}}-universal"},
+			"cellar_path":      filepath.Join(t.TempDir(), "cellar"),
+			"deploy_path":      filepath.Join(deployPath, "{{.OS}}"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		worker, err := NewBinDeployFromJson(ctl, content, "default")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := worker.BinaryName(); n != utilBinaryName("myapp") {
+			t.Error(n)
+		}
+		if p := worker.DeployPath(); p != filepath.Join(deployPath, platform.OS) {
+			t.Error(p)
+		}
+		// 1.0.0 of the platform, and 1.1.0 of the fallback
+		versions, versionPaths, _, err := worker.ListRemoteVersions()
+		if err != nil || len(versions) != 2 {
+			t.Error(versions, err)
+		}
+		candidates := make([]BinSrcMirrorCandidate, 0)
+		if err := json.Unmarshal([]byte(versionPaths["1.0.0"]), &candidates); err != nil || len(candidates) != 2 ||
+			filepath.Base(candidates[0].Path) != "myapp-1.0.0-"+platform.OS+"-"+platform.Arch+".zip" {
+			t.Error(candidates, err)
+		}
+		if _, v, err := worker.GetLocalLatest(); err != nil || v.String() != "1.1.0" {
+			t.Error(v, err)
+		}
+
+		for _, invalid := range []string{"{{.Os}}", "{{.OS"} {
+			if _, err := utilPlatformResolve(invalid, platform); err != ErrorInvalidTemplate {
+				t.Error(invalid, err)
+			}
+		}
+	})
+}
+
+func TestRegisterBinSource(t *testing.T) {
+	qtr_endtoend.TestWithControl(t, func(ctl app_control.Control) {
+		type testRecipe struct {
+			SourcePath string `json:"source_path"`
+			BinDstLocalRecipe
+		}
+		authPeerName := ""
+		RegisterBinSource(BinSourceRegistration{
+			SourceType: "test_source",
+			Recipe: func() interface{} {
+				return &testRecipe{}
+			},
+			Auth: func(ctl app_control.Control, recipe interface{}, peerName string) (auth interface{}, err error) {
+				authPeerName = peerName
+				return recipe.(*testRecipe).SourcePath, nil
+			},
+			New: func(ctl app_control.Control, recipe interface{}, auth interface{}) (BinSource, error) {
+				r := recipe.(*testRecipe)
+				return NewBinSrcLocalDirSource(BinSrcLocalDirDstLocalRecipe{
+					SourcePath:        auth.(string),
+					BinDstLocalRecipe: r.BinDstLocalRecipe,
+				}, ctl), nil
+			},
+		})
+		defer func() {
+			binSourceRegistryMutex.Lock()
+			delete(binSourceRegistry, "test_source")
+			binSourceRegistryMutex.Unlock()
+		}()
+
+		found := false
+		for _, st := range BinSourceTypes() {
+			if st == "test_source" {
+				found = true
+			}
+		}
+		if !found {
+			t.Error(BinSourceTypes())
+		}
+
+		worker, err := NewBinDeployFromJson(ctl, []byte(`{"source_type":"test_source","source_path":"/tmp","binary_name":"myapp","prefix":"myapp","suffix":"linux-amd64","cellar_path":"/tmp"}`), "my_peer")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if authPeerName != "my_peer" || worker.BinaryName() != utilBinaryName("myapp") {
+			t.Error(authPeerName, worker.BinaryName())
+		}
+	})
+}
@@ -0,0 +1,888 @@
+package sb_deploy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/watermint/toolbox/essentials/log/esl"
+	"github.com/watermint/toolbox/essentials/strings/es_version"
+	"github.com/watermint/toolbox/infra/control/app_control"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	BinDstLocalVersionCacheLifecycle = 86400
+	BinDstLocalVersionCacheName      = "sb_deploy-bin_dst_local_version_cache"
+
+	// BinDstLocalDigestName is the name of the file in the version cellar, that records the SHA-256 digest of the asset.
+	BinDstLocalDigestName = ".sb_deploy.sha256"
+
+	// BinDstLocalCompletionMarkerName is the name of the file in the version cellar, that marks the installation is completed.
+	// Versions without the marker are ignored, such as the folder of the interrupted installation.
+	BinDstLocalCompletionMarkerName = ".sb_deploy.complete"
+
+	// BinDstLocalStagingPrefix is the prefix of staging directories in the cellar.
+	BinDstLocalStagingPrefix = ".sb_deploy.staging-"
+)
+
+var (
+	ErrorVersionRejected = errors.New("version rejected")
+)
+
+// BinDstLocalRecipe is the local destination part of deploy recipes.
+// Source recipes embed this struct, then fields are flattened in the deploy JSON.
+type BinDstLocalRecipe struct {
+	// BinaryName is the name of the binary file.
+	// The template like `myapp{{.Ext}}` is resolved by the running platform, see BinDeployPlatform.
+	BinaryName string `json:"binary_name"`
+
+	// Prefix is the prefix of the file/folder basename.
+	Prefix string `json:"prefix"`
+
+	// Suffix is the suffix of the file basename.
+	// The template like `{{.OS}}-{{.Arch}}` is resolved by the running platform, see BinDeployPlatform.
+	Suffix string `json:"suffix"`
+
+	// SuffixFallbacks are suffixes in priority order, to use if the version has no asset of the suffix,
+	// like `{{.OS}}-universal`. Templates are resolved as well as the suffix.
+	SuffixFallbacks []string `json:"suffix_fallbacks,omitempty"`
+
+	// CellarPath is the path to store extracted binaries of versions
+	CellarPath string `json:"cellar_path"`
+
+	// DeployPath is the path to deploy symlink to the binary.
+	// This field is options when no symlink deployment is required.
+	// Templates are resolved as well as the suffix.
+	DeployPath string `json:"deploy_path,omitempty"`
+
+	// DeployMode is how to deploy the binary to the deploy path: `symlink` (default), `hardlink`,
+	// `copy`, or `shim` (the script that executes the binary in the cellar, `.cmd` on Windows).
+	// The hardlink requires the cellar and the deploy path in the same file system.
+	DeployMode string `json:"deploy_mode,omitempty"`
+
+	// Binaries are executables in the package other than the binary name, such as helpers.
+	// Each binary is verified after the extraction, and linked into the deploy path with the binary name.
+	Binaries []BinDstLocalBinary `json:"binaries,omitempty"`
+
+	// ArchiveFormat is the format of the release asset: `zip`, `tar.gz`, `tar.xz`, `tar.zst` or `raw`.
+	// The format is detected by the extension or magic bytes if empty.
+	// The `raw` asset is the executable itself, and placed in the cellar as the binary name.
+	ArchiveFormat string `json:"archive_format,omitempty"`
+
+	// RequireChecksum is true to refuse the asset without the checksum published by the source.
+	// The asset is always verified if the checksum is published.
+	RequireChecksum bool `json:"require_checksum,omitempty"`
+
+	// PublicKeys are pinned public keys to verify the detached signature of the asset.
+	// Each key is the minisign public key (signature: `ASSET.minisig`), or the raw ed25519 public key
+	// in base64 or hex string (signature: `ASSET.sig`).
+	// The signature is required if any key is pinned, and the version without a valid signature is rejected.
+	PublicKeys []string `json:"public_keys,omitempty"`
+
+	// ArchiveLimits is limits of the extraction to protect from archive bombs. Defaults are used if omitted.
+	ArchiveLimits *ArchiveLimits `json:"archive_limits,omitempty"`
+
+	// LockTimeout is the timeout in seconds to wait for another switchbox that is updating the cellar
+	// or deploying to the deploy path. LockDefaultTimeout is used if zero.
+	LockTimeout int `json:"lock_timeout,omitempty"`
+
+	// Retention is the retention policy of versions in the cellar.
+	// Latest BinDstLocalDefaultKeepLatest versions are kept if omitted.
+	Retention *BinDstLocalRetention `json:"retention,omitempty"`
+
+	// VersionConstraint restricts versions to install and use, such as the exact version `1.4.2`,
+	// `~1.4` (any 1.4.x) or `>=2.0 <3.0`. Versions out of the constraint are ignored by update, link,
+	// run and rollback. Any version is used if empty.
+	VersionConstraint string `json:"version_constraint,omitempty"`
+
+	// Channel is the release channel to subscribe: `stable` (default), `beta` or `nightly`.
+	// The subscriber receives versions of the channel and more stable channels, e.g. `beta` receives
+	// stable and beta versions.
+	Channel string `json:"channel,omitempty"`
+
+	// UpdatePolicy is the policy of updates by UpdateIfRequired, such as update windows and the minimum age.
+	// Updates are applied whenever checked if omitted.
+	UpdatePolicy *BinDstLocalUpdatePolicy `json:"update_policy,omitempty"`
+}
+
+// BinDstLocalCompletion is the content of the completion marker.
+type BinDstLocalCompletion struct {
+	Version       string `json:"version"`
+	ArchiveSha256 string `json:"archive_sha256"`
+	BinarySha256  string `json:"binary_sha256"`
+	CompletedAt   string `json:"completed_at"`
+	Channel       string `json:"channel,omitempty"`
+}
+
+type BinDstLocalRemoteVersionCache struct {
+	// CacheTime is the time when the cache is created in Unix time
+	CacheTime int64 `json:"cache_time,omitempty"`
+
+	// Versions is the list of versions
+	Versions []es_version.Version `json:"versions,omitempty"`
+
+	// Versions is the list of versions, version string as key and path as value
+	VersionPaths map[string]string `json:"version_paths,omitempty"`
+
+	// Metas is the metadata of versions, version string as key
+	Metas map[string]BinRemoteVersionMeta `json:"metas,omitempty"`
+
+	// Revoked is the revocation list of the source
+	Revoked []BinDeployRevokedVersion `json:"revoked,omitempty"`
+}
+
+// BinRemoteVersionMeta is the metadata of the remote version published by the source.
+type BinRemoteVersionMeta struct {
+	// Channel is the release channel of the version.
+	// The channel is derived from the pre-release of the version if empty.
+	Channel string `json:"channel,omitempty"`
+
+	// Rollout is the percentage (0-100) of machines to receive the version.
+	// All machines receive the version if nil.
+	Rollout *int `json:"rollout,omitempty"`
+
+	// PublishedAt is the release time of the version in RFC3339, if published by the source.
+	PublishedAt string `json:"published_at,omitempty"`
+}
+
+// BinSource is the source specific part of BinDeploy.
+type BinSource interface {
+	// Id returns the identifier of the source, such as URL of the source.
+	// The identifier is used as a seed of the remote version cache name.
+	Id() string
+
+	// ListRemoteVersions List remote versions. The metas is the metadata published by the source,
+	// such as markers, the version string as key.
+	ListRemoteVersions() (versions []es_version.Version, versionPaths map[string]string, metas map[string]BinRemoteVersionMeta, err error)
+
+	// Download version to temporary path
+	Download(version es_version.Version, versionPath string) (downloadPath string, err error)
+
+	// ExpectedSha256 returns the SHA-256 digest of the asset published by the source, such as
+	// the sidecar file `ASSET.sha256`, `SHA256SUMS` or the manifest. Returns found=false if not published.
+	ExpectedSha256(version es_version.Version, versionPath string) (digest string, found bool, err error)
+
+	// AssetSidecar returns the content of the file published next to the asset, that is named
+	// the asset name with the extension, such as `ASSET.minisig`. Returns found=false if not published.
+	AssetSidecar(version es_version.Version, versionPath, extension string) (content []byte, found bool, err error)
+
+	// RevocationList returns the content of the revocation list `revoked.json` at the root of the source.
+	// Returns found=false if not published.
+	RevocationList() (content []byte, found bool, err error)
+}
+
+// binSrcDisplayPath is the source that encodes the version path, such as JSON of the asset entry.
+// The version path is decoded into the path or the url of the asset, to display the location.
+type binSrcDisplayPath interface {
+	displayPath(version es_version.Version, versionPath string) string
+}
+
+func newBinDstLocal(recipe BinDstLocalRecipe, ctl app_control.Control, source BinSource) BinDeploy {
+	return &binDstLocalWorkerImpl{
+		recipe: recipe,
+		ctl:    ctl,
+		source: source,
+	}
+}
+
+type binDstLocalWorkerImpl struct {
+	recipe BinDstLocalRecipe
+	ctl    app_control.Control
+	source BinSource
+}
+
+func (z binDstLocalWorkerImpl) IsUpdateRequired() (required bool, err error) {
+	l := z.ctl.Log()
+	localVersions, _, err := z.ListLocalVersions()
+	if err != nil {
+		l.Debug("Unable to list local versions", esl.Error(err))
+		return false, err
+	}
+	remoteVersions, remoteVersionPaths, remoteMetas, err := z.ListRemoteVersions()
+	if err != nil {
+		l.Debug("Unable to list remote versions", esl.Error(err))
+		return false, err
+	}
+
+	l.Debug("Local versions", esl.Any("versions", localVersions))
+	l.Debug("Remote versions", esl.Any("versions", remoteVersions))
+
+	_, _, required, err = z.eligibleRemoteVersions(localVersions, remoteVersions, remoteVersionPaths, remoteMetas)
+	if err != nil {
+		return false, err
+	}
+
+	l.Debug("Update required", esl.Bool("required", required))
+
+	return required, nil
+}
+
+// eligibleRemoteVersions returns remote versions eligible by the update policy and not rejected by the signature,
+// and required=true if the eligible version is newer than the local latest version. Errors of rejected versions
+// are returned as rejected. Remote versions are expected to be filtered by the rollout.
+func (z binDstLocalWorkerImpl) eligibleRemoteVersions(localVersions, remoteVersions []es_version.Version, remoteVersionPaths map[string]string, remoteMetas map[string]BinRemoteVersionMeta) (eligible []es_version.Version, rejected []error, required bool, err error) {
+	localVersionLatest := es_version.Max(localVersions...)
+	eligible, rejected = z.excludeRejected(remoteVersions, remoteVersionPaths)
+
+	// the policy is not applied to the first installation, because no version is running.
+	if len(localVersions) > 0 {
+		var deferred bool
+		eligible, deferred, err = z.applyUpdatePolicy(localVersionLatest, eligible, remoteMetas)
+		if err != nil || deferred {
+			return eligible, rejected, false, err
+		}
+	}
+	remoteVersionLatest := es_version.Max(eligible...)
+
+	z.ctl.Log().Debug("Latest versions", esl.String("local", localVersionLatest.String()), esl.String("remote", remoteVersionLatest.String()))
+
+	return eligible, rejected, es_version.Compare(remoteVersionLatest, localVersionLatest) > 0, nil
+}
+
+func (z binDstLocalWorkerImpl) ListRemoteVersionStatus() (versions []BinDeployRemoteVersion, err error) {
+	l := z.ctl.Log()
+	localVersions, localVersionPaths, err := z.ListLocalVersions()
+	if err != nil {
+		l.Debug("Unable to list local versions", esl.Error(err))
+		return nil, err
+	}
+	remoteVersions, remoteVersionPaths, remoteMetas, err := z.ListRemoteVersions()
+	if err != nil {
+		l.Debug("Unable to list remote versions", esl.Error(err))
+		return nil, err
+	}
+	eligible, _, required, err := z.eligibleRemoteVersions(localVersions, remoteVersions, remoteVersionPaths, remoteMetas)
+	if err != nil {
+		return nil, err
+	}
+	// the update keeps the local latest version, if no update is required.
+	latest := es_version.Max(localVersions...)
+	if required {
+		latest = es_version.Max(eligible...)
+	}
+
+	sort.Slice(remoteVersions, func(i, j int) bool {
+		return es_version.Compare(remoteVersions[i], remoteVersions[j]) > 0
+	})
+	versions = make([]BinDeployRemoteVersion, 0, len(remoteVersions))
+	for _, v := range remoteVersions {
+		_, installed := localVersionPaths[v.String()]
+		versionPath := remoteVersionPaths[v.String()]
+		if d, ok := z.source.(binSrcDisplayPath); ok {
+			versionPath = d.displayPath(v, versionPath)
+		}
+		versions = append(versions, BinDeployRemoteVersion{
+			Version:   v.String(),
+			Channel:   remoteMetas[v.String()].Channel,
+			Path:      versionPath,
+			Installed: installed,
+			Latest:    !latest.Equals(es_version.Zero()) && v.Equals(latest),
+		})
+	}
+	return versions, nil
+}
+
+func (z binDstLocalWorkerImpl) LocalLatestBinaryPath() string {
+	if _, versionPath, found := z.rollbackVersion(); found {
+		return filepath.Join(versionPath, z.BinaryName())
+	}
+	l := z.ctl.Log()
+	localVersions, localVersionPaths, err := z.ListLocalVersions()
+	if err != nil {
+		l.Debug("Unable to list local versions", esl.Error(err))
+		return ""
+	}
+	localVersionLatest := es_version.Max(localVersions...)
+	if len(localVersions) < 1 || localVersionLatest.Equals(es_version.Zero()) {
+		return ""
+	}
+	return filepath.Join(localVersionPaths[localVersionLatest.String()], z.BinaryName())
+}
+
+func (z binDstLocalWorkerImpl) BinaryName() string {
+	return utilBinaryName(z.recipe.BinaryName)
+}
+
+func (z binDstLocalWorkerImpl) DeployPath() string {
+	return z.recipe.DeployPath
+}
+
+func (z binDstLocalWorkerImpl) lockTimeout() time.Duration {
+	if z.recipe.LockTimeout > 0 {
+		return time.Duration(z.recipe.LockTimeout) * time.Second
+	}
+	return LockDefaultTimeout * time.Second
+}
+
+// lockCellar acquires the lock of the cellar, to serialize update and extraction of processes.
+func (z binDstLocalWorkerImpl) lockCellar() (unlock func(), err error) {
+	return utilLockAcquire(z.ctl.Log(), filepath.Join(z.recipe.CellarPath, LockName), z.lockTimeout())
+}
+
+// lockDeploy acquires the lock of the deploy path, to serialize deployment of processes.
+func (z binDstLocalWorkerImpl) lockDeploy() (unlock func(), err error) {
+	return utilLockAcquire(z.ctl.Log(), filepath.Join(z.recipe.DeployPath, LockName), z.lockTimeout())
+}
+
+func (z binDstLocalWorkerImpl) update(force bool) (err error) {
+	l := z.ctl.Log()
+
+	// the update required is evaluated in the lock, because another process may have updated.
+	unlock, err := z.lockCellar()
+	if err != nil {
+		l.Warn("Unable to lock the cellar", esl.Error(err))
+		return err
+	}
+	defer unlock()
+
+	localVersions, localVersionPaths, err := z.ListLocalVersions()
+	if err != nil {
+		l.Warn("Unable to list local versions", esl.Error(err))
+		return err
+	}
+	remoteVersions, remoteVersionPaths, remoteMetas, err := z.ListRemoteVersions()
+	if err != nil {
+		l.Warn("Unable to list remote versions", esl.Error(err))
+		return err
+	}
+
+	if !force {
+		eligible, rejected, updateRequired, err := z.eligibleRemoteVersions(localVersions, remoteVersions, remoteVersionPaths, remoteMetas)
+		if err != nil {
+			l.Warn("Unable to check update required", esl.Error(err))
+			return err
+		}
+		if !updateRequired {
+			// no version to run, if all versions are rejected on the first installation.
+			if len(localVersions) < 1 && len(rejected) > 0 {
+				return fmt.Errorf("%w: %w", ErrorVersionRejected, errors.Join(rejected...))
+			}
+			l.Info("No update required")
+			return nil
+		}
+		remoteVersions = eligible
+	}
+
+	if err := os.MkdirAll(z.recipe.CellarPath, 0755); err != nil {
+		l.Warn("Unable to create cellar directory", esl.Error(err))
+		return err
+	}
+
+	localVersionLatest := es_version.Max(localVersions...)
+	remoteVersionLatest := es_version.Max(remoteVersions...)
+
+	l.Info("Local latest version", esl.String("version", localVersionLatest.String()), esl.String("path", localVersionPaths[localVersionLatest.String()]))
+	l.Info("Remote latest version", esl.String("version", remoteVersionLatest.String()), esl.String("path", remoteVersionPaths[remoteVersionLatest.String()]))
+
+	// try from the latest, then fall back to the next version if the version is rejected by the signature.
+	candidates := make([]es_version.Version, len(remoteVersions))
+	copy(candidates, remoteVersions)
+	sort.Slice(candidates, func(i, j int) bool {
+		return es_version.Compare(candidates[i], candidates[j]) > 0
+	})
+	rejected := make([]error, 0)
+	installed := false
+	for _, ver := range candidates {
+		if c := es_version.Compare(ver, localVersionLatest); c < 0 || (c == 0 && (!force || len(rejected) > 0)) {
+			l.Info("No more versions newer than the local latest version", esl.String("version", ver.String()))
+			break
+		}
+		ll := l.With(esl.String("version", ver.String()))
+		dlPath, err := z.Download(ver, remoteVersionPaths[ver.String()])
+		if errors.Is(err, ErrorSignatureNotFound) || errors.Is(err, ErrorSignatureInvalid) {
+			ll.Warn("The version is rejected, try the next version", esl.Error(err))
+			rejected = append(rejected, fmt.Errorf("%s: %w", ver.String(), err))
+			z.recordRejected(ver, remoteVersionPaths[ver.String()], err)
+			continue
+		}
+		if err != nil {
+			ll.Warn("Unable to download", esl.Error(err))
+			return err
+		}
+		cellarPath, err := z.extract(ver, dlPath)
+		if err != nil {
+			ll.Warn("Unable to extract", esl.Error(err))
+			return err
+		}
+		ll.Info("Extracted", esl.String("path", cellarPath))
+		z.clearRejected(ver)
+		installed = true
+		break
+	}
+
+	if installed && z.recipe.Retention != nil && z.recipe.Retention.AutoPrune {
+		if _, err := z.prune(false); err != nil {
+			l.Warn("Unable to prune the cellar", esl.Error(err))
+		}
+	}
+
+	if len(rejected) > 0 {
+		if installed {
+			l.Warn("Updated with the fallback version, newer versions are rejected", esl.Error(errors.Join(rejected...)))
+			return nil
+		}
+		return fmt.Errorf("%w: %w", ErrorVersionRejected, errors.Join(rejected...))
+	}
+	return nil
+}
+
+func (z binDstLocalWorkerImpl) UpdateForce() (err error) {
+	return z.update(true)
+}
+
+func (z binDstLocalWorkerImpl) UpdateIfRequired() (err error) {
+	return z.update(false)
+}
+
+func (z binDstLocalWorkerImpl) GetLocalLatest() (binaryPath string, version es_version.Version, err error) {
+	l := z.ctl.Log()
+	if version, versionPath, found := z.rollbackVersion(); found {
+		l.Info("Use the rollback version", esl.String("version", version.String()))
+		return versionPath, version, nil
+	}
+	localVersions, localVersionPaths, err := z.ListLocalVersions()
+	if err != nil {
+		l.Debug("Unable to list local versions", esl.Error(err))
+		return "", es_version.Zero(), err
+	}
+	localVersionLatest := es_version.Max(localVersions...)
+	if len(localVersions) < 1 || localVersionLatest.Equals(es_version.Zero()) {
+		updateErr := z.UpdateIfRequired()
+		localVersions, localVersionPaths, err = z.ListLocalVersions()
+		if err != nil {
+			l.Debug("Unable to list local versions", esl.Error(err))
+			return "", es_version.Zero(), err
+		}
+		localVersionLatest = es_version.Max(localVersions...)
+		if updateErr != nil {
+			// the fallback version may be installed even if the latest version is rejected.
+			if len(localVersions) < 1 || localVersionLatest.Equals(es_version.Zero()) {
+				l.Debug("Unable to update", esl.Error(updateErr))
+				return "", es_version.Zero(), updateErr
+			}
+			l.Warn("Updated with the fallback version", esl.String("version", localVersionLatest.String()), esl.Error(updateErr))
+		}
+	}
+	return localVersionPaths[localVersionLatest.String()], localVersionLatest, nil
+}
+
+func (z binDstLocalWorkerImpl) remoteVersionCacheName() string {
+	seeds := make([]string, 0)
+	seeds = append(seeds, z.source.Id())
+	seeds = append(seeds, z.recipe.Prefix)
+	seeds = append(seeds, z.recipe.Suffix)
+	seeds = append(seeds, z.recipe.BinaryName)
+	seed := sha256.Sum256([]byte(strings.Join(seeds, "-")))
+	return BinDstLocalVersionCacheName + hex.EncodeToString(seed[:])[0:16] + ".json"
+}
+
+// readRemoteVersionCache reads the remote version cache regardless of the age of the cache.
+func (z binDstLocalWorkerImpl) readRemoteVersionCache() (cache *BinDstLocalRemoteVersionCache, err error) {
+	l := z.ctl.Log()
+	cachePath := filepath.Join(z.ctl.Workspace().Cache(), z.remoteVersionCacheName())
+	cacheData, err := os.ReadFile(cachePath)
+	if err != nil {
+		l.Debug("Unable to read cache", esl.Error(err))
+		return nil, err
+	}
+	cache = &BinDstLocalRemoteVersionCache{}
+	if err = json.Unmarshal(cacheData, cache); err != nil {
+		l.Debug("Unable to unmarshal cache", esl.Error(err))
+		return nil, err
+	}
+	return cache, nil
+}
+
+func (z binDstLocalWorkerImpl) loadRemoteVersionsCache() (versions []es_version.Version, versionPaths map[string]string, metas map[string]BinRemoteVersionMeta, found bool) {
+	cache, err := z.readRemoteVersionCache()
+	if err != nil {
+		return versions, versionPaths, metas, false
+	}
+	z.ctl.Log().Debug("Cache found")
+	if cache.CacheTime+BinDstLocalVersionCacheLifecycle < time.Now().Unix() {
+		return versions, versionPaths, metas, false
+	}
+	if cache.Metas == nil {
+		cache.Metas = make(map[string]BinRemoteVersionMeta)
+	}
+	return cache.Versions, cache.VersionPaths, cache.Metas, true
+}
+
+func (z binDstLocalWorkerImpl) saveRemoteVersionCache(versions []es_version.Version, versionPaths map[string]string, metas map[string]BinRemoteVersionMeta, revoked []BinDeployRevokedVersion) (err error) {
+	l := z.ctl.Log()
+	if err := os.MkdirAll(z.ctl.Workspace().Cache(), 0755); err != nil {
+		l.Debug("Unable to create cache directory", esl.Error(err))
+		return err
+	}
+
+	cachePath := filepath.Join(z.ctl.Workspace().Cache(), z.remoteVersionCacheName())
+	cache := &BinDstLocalRemoteVersionCache{
+		CacheTime:    time.Now().Unix(),
+		Versions:     versions,
+		VersionPaths: versionPaths,
+		Metas:        metas,
+		Revoked:      revoked,
+	}
+	cacheData, err := json.Marshal(cache)
+	if err != nil {
+		l.Debug("Unable to marshal cache", esl.Error(err))
+		return err
+	}
+	// write into the temporary file then rename, to not expose the partially written cache to other processes.
+	tmpFile, err := os.CreateTemp(z.ctl.Workspace().Cache(), z.remoteVersionCacheName()+".*")
+	if err != nil {
+		l.Debug("Unable to create temporary cache", esl.Error(err))
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmpFile.Name())
+	}()
+	if _, err := tmpFile.Write(cacheData); err != nil {
+		_ = tmpFile.Close()
+		l.Debug("Unable to write cache", esl.Error(err))
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		l.Debug("Unable to write cache", esl.Error(err))
+		return err
+	}
+	if err := os.Rename(tmpFile.Name(), cachePath); err != nil {
+		l.Debug("Unable to replace cache", esl.Error(err))
+		return err
+	}
+	return nil
+}
+
+// channel returns the subscribed channel.
+func (z binDstLocalWorkerImpl) channel() (channel string, err error) {
+	if z.recipe.Channel == "" {
+		return ChannelStable, nil
+	}
+	channel, ok := utilChannelNormalize(z.recipe.Channel)
+	if !ok {
+		z.ctl.Log().Warn("Unknown channel", esl.String("channel", z.recipe.Channel))
+		return "", ErrorInvalidChannel
+	}
+	return channel, nil
+}
+
+// filter returns versions that satisfy the version constraint and the subscribed channel, and not revoked.
+// The channel of the version without the channel in metas is derived from the pre-release of the version.
+func (z binDstLocalWorkerImpl) filter(versions []es_version.Version, versionPaths map[string]string, metas map[string]BinRemoteVersionMeta) (filtered []es_version.Version, filteredPaths map[string]string, filteredMetas map[string]BinRemoteVersionMeta, err error) {
+	l := z.ctl.Log()
+	constraint, err := utilVersionConstraintParse(z.recipe.VersionConstraint)
+	if err != nil {
+		l.Warn("Unable to parse the version constraint", esl.String("constraint", z.recipe.VersionConstraint), esl.Error(err))
+		return nil, nil, nil, err
+	}
+	channel, err := z.channel()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	revoked := z.revocationList()
+
+	filtered = make([]es_version.Version, 0, len(versions))
+	filteredPaths = make(map[string]string)
+	filteredMetas = make(map[string]BinRemoteVersionMeta)
+	for _, v := range versions {
+		meta := metas[v.String()]
+		if meta.Channel == "" {
+			meta.Channel = utilChannelOfVersion(v)
+		}
+		versionChannel := meta.Channel
+		if !constraint.Match(v) {
+			l.Debug("Skip version out of the constraint", esl.String("version", v.String()))
+			continue
+		}
+		if !utilChannelIncludes(channel, versionChannel) {
+			l.Debug("Skip version out of the channel", esl.String("version", v.String()), esl.String("channel", versionChannel))
+			continue
+		}
+		if r, found := utilRevocationMatch(revoked, v); found {
+			l.Debug("Skip revoked version", esl.String("version", v.String()), esl.String("reason", r.Reason))
+			continue
+		}
+		filtered = append(filtered, v)
+		filteredPaths[v.String()] = versionPaths[v.String()]
+		filteredMetas[v.String()] = meta
+	}
+	return filtered, filteredPaths, filteredMetas, nil
+}
+
+// localChannel returns the channel recorded in the completion marker.
+// The channel is derived from the pre-release if not recorded.
+func (z binDstLocalWorkerImpl) localChannel(version es_version.Version, versionPath string) string {
+	if data, err := os.ReadFile(filepath.Join(versionPath, BinDstLocalCompletionMarkerName)); err == nil {
+		marker := &BinDstLocalCompletion{}
+		if err := json.Unmarshal(data, marker); err == nil {
+			if channel, ok := utilChannelNormalize(marker.Channel); ok {
+				return channel
+			}
+		}
+	}
+	return utilChannelOfVersion(version)
+}
+
+// remoteChannel returns the channel of the remote version in the remote version cache.
+// The channel is derived from the pre-release if not found.
+func (z binDstLocalWorkerImpl) remoteChannel(version es_version.Version) string {
+	if _, _, metas, found := z.loadRemoteVersionsCache(); found {
+		if meta, ok := metas[version.String()]; ok && meta.Channel != "" {
+			return meta.Channel
+		}
+	}
+	return utilChannelOfVersion(version)
+}
+
+func (z binDstLocalWorkerImpl) ListLocalVersions() (versions []es_version.Version, versionPaths map[string]string, err error) {
+	versions, versionPaths, err = utilLocalListLocalVersions(z.ctl, z.recipe.CellarPath, z.recipe.Prefix, z.recipe.BinaryName)
+	if err != nil {
+		return versions, versionPaths, err
+	}
+	metas := make(map[string]BinRemoteVersionMeta)
+	for _, v := range versions {
+		metas[v.String()] = BinRemoteVersionMeta{Channel: z.localChannel(v, versionPaths[v.String()])}
+	}
+	versions, versionPaths, _, err = z.filter(versions, versionPaths, metas)
+	return versions, versionPaths, err
+}
+
+func (z binDstLocalWorkerImpl) ListRemoteVersions() (versions []es_version.Version, versionPaths map[string]string, metas map[string]BinRemoteVersionMeta, err error) {
+	l := z.ctl.Log().With(esl.String("source", z.source.Id()))
+
+	if versions, versionPaths, metas, found := z.loadRemoteVersionsCache(); found {
+		l.Debug("Remote version cache found")
+		return z.filterRemote(versions, versionPaths, metas)
+	}
+
+	versions, versionPaths, metas, err = z.source.ListRemoteVersions()
+	if err != nil {
+		l.Debug("Unable to list remote versions", esl.Error(err))
+		return versions, versionPaths, metas, err
+	}
+
+	revoked, err := z.fetchRevocationList()
+	if err != nil {
+		l.Warn("Unable to retrieve the revocation list, use the last known list", esl.Error(err))
+		revoked = z.revocationList()
+	}
+
+	// the cache keeps all versions, because the constraint or the channel may be changed.
+	if err := z.saveRemoteVersionCache(versions, versionPaths, metas, revoked); err != nil {
+		l.Debug("Unable to save remote version cache", esl.Error(err))
+	}
+
+	return z.filterRemote(versions, versionPaths, metas)
+}
+
+func (z binDstLocalWorkerImpl) Download(version es_version.Version, versionPath string) (downloadPath string, err error) {
+	l := z.ctl.Log().With(esl.String("version", version.String()))
+	candidates := []binSrcCandidate{{source: z.source, path: versionPath}}
+	if c, ok := z.source.(binSrcCandidates); ok {
+		if candidates, err = c.candidates(version, versionPath); err != nil {
+			return "", err
+		}
+	}
+	lastErr := ErrorMirrorVersionNotFound
+	for _, candidate := range candidates {
+		downloadPath, err = z.download(candidate, version)
+		if err == nil {
+			return downloadPath, nil
+		}
+		if len(candidates) > 1 {
+			l.Warn("Unable to download or verify, try next source", esl.String("source", candidate.source.Id()), esl.Error(err))
+		}
+		lastErr = err
+	}
+	return "", lastErr
+}
+
+// download downloads the version from the candidate, then verifies with the checksum and the signature
+// published by the same candidate.
+func (z binDstLocalWorkerImpl) download(candidate binSrcCandidate, version es_version.Version) (downloadPath string, err error) {
+	downloadPath, err = candidate.source.Download(version, candidate.path)
+	if err != nil {
+		return "", err
+	}
+	if err := z.verify(candidate, version, downloadPath); err != nil {
+		if removeErr := os.Remove(downloadPath); removeErr != nil {
+			z.ctl.Log().Debug("Unable to remove the downloaded file", esl.Error(removeErr))
+		}
+		return "", err
+	}
+	if err := z.verifySignature(candidate, version, downloadPath); err != nil {
+		// the digest is kept with the error, to not download the same asset again.
+		if errors.Is(err, ErrorSignatureNotFound) || errors.Is(err, ErrorSignatureInvalid) {
+			if digest, digestErr := utilSha256File(downloadPath); digestErr == nil {
+				err = &binDstLocalRejection{sha256: digest, err: err}
+			}
+		}
+		if removeErr := os.Remove(downloadPath); removeErr != nil {
+			z.ctl.Log().Debug("Unable to remove the downloaded file", esl.Error(removeErr))
+		}
+		return "", err
+	}
+	return downloadPath, nil
+}
+
+// verify verifies the downloaded file with the checksum published by the source.
+func (z binDstLocalWorkerImpl) verify(candidate binSrcCandidate, version es_version.Version, downloadPath string) (err error) {
+	l := z.ctl.Log().With(esl.String("version", version.String()), esl.String("downloadPath", downloadPath))
+	expected, found, err := candidate.source.ExpectedSha256(version, candidate.path)
+	if err != nil {
+		l.Warn("Unable to retrieve the checksum", esl.Error(err))
+		return err
+	}
+	if !found {
+		if z.recipe.RequireChecksum {
+			l.Warn("No checksum published for the version")
+			return ErrorChecksumNotFound
+		}
+		l.Info("No checksum published for the version, skip verification")
+		return nil
+	}
+	actual, err := utilSha256File(downloadPath)
+	if err != nil {
+		l.Debug("Unable to compute the digest", esl.Error(err))
+		return err
+	}
+	if !strings.EqualFold(expected, actual) {
+		l.Warn("Checksum mismatch", esl.String("expected", expected), esl.String("actual", actual))
+		return ErrorChecksumMismatch
+	}
+	l.Info("Checksum verified", esl.String("sha256", actual))
+	return nil
+}
+
+// verifySignature verifies the downloaded file with the detached signature and pinned public keys.
+func (z binDstLocalWorkerImpl) verifySignature(candidate binSrcCandidate, version es_version.Version, downloadPath string) (err error) {
+	l := z.ctl.Log().With(esl.String("version", version.String()), esl.String("downloadPath", downloadPath))
+	if len(z.recipe.PublicKeys) < 1 {
+		return nil
+	}
+	keys, err := utilSignatureParsePublicKeys(z.recipe.PublicKeys)
+	if err != nil {
+		l.Warn("Unable to parse public keys", esl.Error(err))
+		return err
+	}
+	content, err := os.ReadFile(downloadPath)
+	if err != nil {
+		l.Debug("Unable to read the downloaded file", esl.Error(err))
+		return err
+	}
+	err = utilSignatureVerify(keys, content, func(extension string) (signature []byte, found bool, err error) {
+		return candidate.source.AssetSidecar(version, candidate.path, extension)
+	})
+	if err != nil {
+		l.Warn("Signature verification failed", esl.Error(err))
+		return err
+	}
+	l.Info("Signature verified")
+	return nil
+}
+
+func (z binDstLocalWorkerImpl) Extract(version es_version.Version, downloadPath string) (cellarPath string, err error) {
+	unlock, err := z.lockCellar()
+	if err != nil {
+		z.ctl.Log().Warn("Unable to lock the cellar", esl.Error(err))
+		return "", err
+	}
+	defer unlock()
+	return z.extract(version, downloadPath)
+}
+
+// extract extracts the version into the cellar. The caller must hold the lock of the cellar.
+func (z binDstLocalWorkerImpl) extract(version es_version.Version, downloadPath string) (cellarPath string, err error) {
+	limits := ArchiveLimits{}
+	if z.recipe.ArchiveLimits != nil {
+		limits = *z.recipe.ArchiveLimits
+	}
+	extraBinNames, err := z.extraBinaryNames()
+	if err != nil {
+		return "", err
+	}
+	return utilLocalExtract(z.ctl, z.recipe.CellarPath, z.recipe.Prefix, z.recipe.BinaryName, extraBinNames, z.recipe.ArchiveFormat, limits, version, z.remoteChannel(version), downloadPath)
+}
+
+func (z binDstLocalWorkerImpl) DeploySymlink() (err error) {
+	return z.deploy(false)
+}
+
+func (z binDstLocalWorkerImpl) DeploySymlinkForce() (err error) {
+	return z.deploy(true)
+}
+
+// deploy deploys binaries in the deploy mode. The file not deployed by switchbox is replaced only if forced.
+func (z binDstLocalWorkerImpl) deploy(force bool) (err error) {
+	l := z.ctl.Log()
+	mode, err := z.deployMode()
+	if err != nil {
+		return err
+	}
+	l.Info("Deploying", esl.String("mode", mode))
+
+	binaries, err := z.binaries()
+	if err != nil {
+		return err
+	}
+	versionPath, version, err := z.GetLocalLatest()
+	if err != nil {
+		l.Warn("Unable to get local latest", esl.Error(err))
+		return err
+	}
+
+	unlock, err := z.lockDeploy()
+	if err != nil {
+		l.Warn("Unable to lock the deploy path", esl.Error(err))
+		return err
+	}
+	defer unlock()
+
+	// verify all binaries before replacing any link.
+	for _, bin := range binaries {
+		if _, err := os.Stat(filepath.Join(versionPath, bin.path)); err != nil {
+			l.Warn("The binary is not found in the version", esl.String("version", version.String()), esl.String("binary", bin.path), esl.Error(err))
+			return ErrorBinaryNotFound
+		}
+	}
+	if err := os.MkdirAll(z.recipe.DeployPath, 0755); err != nil {
+		l.Warn("Unable to create deploy directory", esl.Error(err))
+		return err
+	}
+
+	// links are replaced all or nothing, previous links are restored on failure.
+	record := z.deployedRecord()
+	replaced := make([]deployLink, 0, len(binaries))
+	deployed := make([]BinDstLocalDeployedLink, 0, len(binaries))
+	for _, bin := range binaries {
+		binCellarPath := filepath.Join(versionPath, bin.path)
+		binDeployPath := filepath.Join(z.recipe.DeployPath, bin.link)
+		ll := l.With(esl.String("version", version.String()),
+			esl.String("binCellarPath", binCellarPath),
+			esl.String("binDeployPath", binDeployPath))
+		ll.Info("Deploying")
+
+		link, err := z.replaceLink(mode, binCellarPath, binDeployPath, force, record)
+		if err != nil {
+			ll.Warn("Unable to deploy, restore previous links", esl.Error(err))
+			z.restoreLinks(replaced)
+			return err
+		}
+		replaced = append(replaced, link)
+		deployed = append(deployed, link.deployed)
+		ll.Info("Deployed")
+	}
+	if err := z.saveDeployedRecord(deployed, nil); err != nil {
+		l.Warn("Unable to record deployed files, restore previous links", esl.Error(err))
+		z.restoreLinks(replaced)
+		return err
+	}
+	z.removeBackups(replaced)
+	return nil
+}
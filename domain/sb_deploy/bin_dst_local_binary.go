@@ -0,0 +1,148 @@
+package sb_deploy
+
+import (
+	"errors"
+	"github.com/watermint/toolbox/essentials/log/esl"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrorInvalidBinary = errors.New("invalid binary in the recipe")
+	ErrorUnknownBinary = errors.New("binary not defined in the recipe")
+)
+
+// BinDstLocalBinary is the executable in the package other than the binary name.
+type BinDstLocalBinary struct {
+	// Name is the path of the executable in the package, like `helper` or `bin/helper`.
+	// `.exe` is added on Windows as well as the binary name.
+	Name string `json:"name"`
+
+	// Alias is the name of the link in the deploy path. The base name of the name is used if empty.
+	Alias string `json:"alias,omitempty"`
+}
+
+// deployBinary is the binary of the platform.
+type deployBinary struct {
+	// path is the relative path in the version folder.
+	path string
+
+	// link is the name in the deploy path.
+	link string
+
+	// names are names to choose the binary, such as the name, the alias and the link name.
+	names []string
+}
+
+// binaries returns binaries of the package, the binary name first.
+func (z binDstLocalWorkerImpl) binaries() (binaries []deployBinary, err error) {
+	l := z.ctl.Log()
+	mode, err := z.deployMode()
+	if err != nil {
+		return nil, err
+	}
+	linkName := func(name string) string {
+		if mode == DeployModeShim {
+			return utilDeployShimName(name)
+		}
+		return name
+	}
+	binaries = []deployBinary{
+		{
+			path:  z.BinaryName(),
+			link:  linkName(z.BinaryName()),
+			names: []string{z.recipe.BinaryName, z.BinaryName()},
+		},
+	}
+	links := map[string]bool{binaries[0].link: true}
+	for _, b := range z.recipe.Binaries {
+		name := filepath.FromSlash(b.Name)
+		if b.Name == "" || !filepath.IsLocal(name) {
+			l.Warn("Invalid binary name", esl.String("name", b.Name))
+			return nil, ErrorInvalidBinary
+		}
+		link := b.Alias
+		if link == "" {
+			link = filepath.Base(name)
+		}
+		link = linkName(utilBinaryName(link))
+		if strings.ContainsAny(link, `/\`) || !filepath.IsLocal(link) || links[link] {
+			l.Warn("Invalid or duplicated alias", esl.String("name", b.Name), esl.String("alias", b.Alias))
+			return nil, ErrorInvalidBinary
+		}
+		links[link] = true
+		names := []string{b.Name, link}
+		if b.Alias != "" {
+			names = append(names, b.Alias)
+		}
+		binaries = append(binaries, deployBinary{
+			path:  utilBinaryName(name),
+			link:  link,
+			names: names,
+		})
+	}
+	return binaries, nil
+}
+
+// extraBinaryNames returns names of binaries in the package other than the binary name.
+func (z binDstLocalWorkerImpl) extraBinaryNames() (names []string, err error) {
+	if _, err := z.binaries(); err != nil {
+		return nil, err
+	}
+	names = make([]string, 0, len(z.recipe.Binaries))
+	for _, b := range z.recipe.Binaries {
+		names = append(names, filepath.FromSlash(b.Name))
+	}
+	return names, nil
+}
+
+func (z binDstLocalWorkerImpl) LocalLatestBinaryPathOf(binary string) (binaryPath string, err error) {
+	if binary == "" {
+		return z.LocalLatestBinaryPath(), nil
+	}
+	binaries, err := z.binaries()
+	if err != nil {
+		return "", err
+	}
+	for _, bin := range binaries {
+		for _, name := range bin.names {
+			if name != binary {
+				continue
+			}
+			latest := z.LocalLatestBinaryPath()
+			if latest == "" {
+				return "", nil
+			}
+			return filepath.Join(filepath.Dir(latest), bin.path), nil
+		}
+	}
+	z.ctl.Log().Warn("The binary is not defined in the recipe", esl.String("binary", binary))
+	return "", ErrorUnknownBinary
+}
+
+func (z binDstLocalWorkerImpl) IsDeployed() bool {
+	l := z.ctl.Log()
+	mode, err := z.deployMode()
+	if err != nil {
+		return false
+	}
+	binaries, err := z.binaries()
+	if err != nil {
+		return false
+	}
+	latest := z.LocalLatestBinaryPath()
+	if latest == "" {
+		return false
+	}
+	record := z.deployedRecord()
+	for _, bin := range binaries {
+		linkPath := filepath.Join(z.recipe.DeployPath, bin.link)
+		expected := filepath.Join(filepath.Dir(latest), bin.path)
+		deployedMode, target, found := z.deployedTarget(linkPath, record)
+		if !found || deployedMode != mode || filepath.Clean(target) != filepath.Clean(expected) {
+			l.Debug("The link is not to the version to use", esl.String("linkPath", linkPath), esl.String("mode", deployedMode), esl.String("target", target))
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,118 @@
+package sb_deploy
+
+import (
+	"errors"
+	"github.com/watermint/toolbox/essentials/strings/es_version"
+	"github.com/watermint/toolbox/infra/control/app_control"
+	"github.com/watermint/toolbox/quality/recipe/qtr_endtoend"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestBinDstLocal_Binaries(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlink is not supported")
+	}
+	qtr_endtoend.TestWithControl(t, func(ctl app_control.Control) {
+		sourcePath := t.TempDir()
+		for _, v := range []string{"1.0.0", "1.1.0"} {
+			folderPath := filepath.Join(sourcePath, "myapp-"+v)
+			if err := os.MkdirAll(folderPath, 0755); err != nil {
+				t.Fatal(err)
+			}
+			files := map[string]string{
+				"myapp":  v,
+				"helper": "helper " + v,
+			}
+			// 1.1.0 lacks the tool
+			if v == "1.0.0" {
+				files["bin/tool"] = "tool " + v
+			}
+			testZipArchive(t, filepath.Join(folderPath, "myapp-"+v+"-linux-amd64.zip"), files)
+		}
+		deployPath := filepath.Join(t.TempDir(), "bin")
+		worker := NewBinSrcLocalDirDstLocal(BinSrcLocalDirDstLocalRecipe{
+			SourcePath: sourcePath,
+			BinDstLocalRecipe: BinDstLocalRecipe{
+				BinaryName: "myapp",
+				Prefix:     "myapp",
+				Suffix:     "linux-amd64",
+				CellarPath: filepath.Join(t.TempDir(), "cellar"),
+				DeployPath: deployPath,
+				Binaries: []BinDstLocalBinary{
+					{Name: "helper"},
+					{Name: "bin/tool", Alias: "mytool"},
+				},
+			},
+		}, ctl)
+
+		// 1.1.0 is rejected by the missing binary
+		_, versionPaths, _, err := worker.ListRemoteVersions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range []string{"1.1.0", "1.0.0"} {
+			dlPath, err := worker.Download(es_version.MustParse(v), versionPaths[v])
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = worker.Extract(es_version.MustParse(v), dlPath)
+			if v == "1.1.0" && !errors.Is(err, ErrorBinaryNotFound) {
+				t.Error(v, err)
+			}
+			if v == "1.0.0" && err != nil {
+				t.Error(v, err)
+			}
+		}
+
+		if worker.IsDeployed() {
+			t.Error("not yet deployed")
+		}
+		if err := worker.DeploySymlink(); err != nil {
+			t.Fatal(err)
+		}
+		for link, content := range map[string]string{"myapp": "1.0.0", "helper": "helper 1.0.0", "mytool": "tool 1.0.0"} {
+			if c, err := os.ReadFile(filepath.Join(deployPath, link)); err != nil || string(c) != content {
+				t.Error(link, string(c), err)
+			}
+		}
+		if !worker.IsDeployed() {
+			t.Error("deployed")
+		}
+		if err := os.Remove(filepath.Join(deployPath, "helper")); err != nil {
+			t.Fatal(err)
+		}
+		if worker.IsDeployed() {
+			t.Error("the helper is missing")
+		}
+
+		for _, name := range []string{"mytool", "bin/tool"} {
+			if p, err := worker.LocalLatestBinaryPathOf(name); err != nil || filepath.Base(p) != "tool" {
+				t.Error(name, p, err)
+			}
+		}
+		if p, err := worker.LocalLatestBinaryPathOf(""); err != nil || p != worker.LocalLatestBinaryPath() {
+			t.Error(p, err)
+		}
+		if _, err := worker.LocalLatestBinaryPathOf("unknown"); err != ErrorUnknownBinary {
+			t.Error(err)
+		}
+
+		invalid := NewBinSrcLocalDirDstLocal(BinSrcLocalDirDstLocalRecipe{
+			SourcePath: sourcePath,
+			BinDstLocalRecipe: BinDstLocalRecipe{
+				BinaryName: "myapp",
+				Prefix:     "myapp",
+				Suffix:     "linux-amd64",
+				CellarPath: filepath.Join(t.TempDir(), "cellar"),
+				DeployPath: deployPath,
+				Binaries:   []BinDstLocalBinary{{Name: "../outside"}},
+			},
+		}, ctl)
+		if err := invalid.DeploySymlink(); err != ErrorInvalidBinary {
+			t.Error(err)
+		}
+	})
+}
@@ -0,0 +1,374 @@
+package sb_deploy
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/watermint/toolbox/essentials/log/esl"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DeployModeSymlink  = "symlink"
+	DeployModeHardlink = "hardlink"
+	DeployModeCopy     = "copy"
+	DeployModeShim     = "shim"
+)
+
+const (
+	// BinDstLocalLinkStagingPrefix is the prefix of the link in the deploy path, that is created before
+	// renamed over the existing link.
+	BinDstLocalLinkStagingPrefix = ".sb_deploy.link-"
+
+	// BinDstLocalBackupPrefix is the prefix of the file in the deploy path, that is the previous file
+	// replaced by the deployment. The backup is restored on failure, and removed on success.
+	BinDstLocalBackupPrefix = ".sb_deploy.backup-"
+
+	// BinDstLocalDeployedName is the name of the file in the cellar, that records files deployed to the deploy path.
+	// Files other than symlinks are replaced or removed only if recorded, and not modified since the deployment.
+	BinDstLocalDeployedName = ".sb_deploy.deployed.json"
+)
+
+var (
+	ErrorDeployPathConflict = errors.New("deploy path is occupied by the file not deployed by switchbox")
+	ErrorInvalidDeployMode  = errors.New("invalid deploy mode")
+)
+
+// BinDstLocalDeployed is the record of files deployed to the deploy path.
+type BinDstLocalDeployed struct {
+	Links []BinDstLocalDeployedLink `json:"links"`
+}
+
+// BinDstLocalDeployedLink is the file deployed to the deploy path.
+type BinDstLocalDeployedLink struct {
+	// Path is the path of the file in the deploy path.
+	Path string `json:"path"`
+
+	// Mode is the deploy mode of the file.
+	Mode string `json:"mode"`
+
+	// Target is the path of the binary in the cellar.
+	Target string `json:"target"`
+
+	// Sha256 is the SHA-256 digest of the deployed file. Empty for the symlink.
+	Sha256 string `json:"sha256,omitempty"`
+
+	DeployedAt string `json:"deployed_at"`
+}
+
+// find returns the record of the path.
+func (z BinDstLocalDeployed) find(path string) (link BinDstLocalDeployedLink, found bool) {
+	for _, link := range z.Links {
+		if filepath.Clean(link.Path) == filepath.Clean(path) {
+			return link, true
+		}
+	}
+	return BinDstLocalDeployedLink{}, false
+}
+
+// deployLink is the file in the deploy path, and the previous state of the path to restore.
+type deployLink struct {
+	// path is the path of the file in the deploy path.
+	path string
+
+	// target is the previous target of the symlink. Empty if the path was not a symlink.
+	target string
+
+	// backup is the path of the previous file. Empty if the path was a symlink or missing.
+	backup string
+
+	// deployed is the record of the new file.
+	deployed BinDstLocalDeployedLink
+}
+
+// utilDeployModeNormalize returns the deploy mode. The default mode is `symlink`.
+func utilDeployModeNormalize(mode string) (normalized string, err error) {
+	switch normalized = strings.ToLower(mode); normalized {
+	case "":
+		return DeployModeSymlink, nil
+	case DeployModeSymlink, DeployModeHardlink, DeployModeCopy, DeployModeShim:
+		return normalized, nil
+	default:
+		return "", ErrorInvalidDeployMode
+	}
+}
+
+// utilDeployShimName returns the name of the shim for the binary name.
+// The shim is the batch file on Windows, then `.exe` is replaced by `.cmd`.
+func utilDeployShimName(binName string) string {
+	if runtime.GOOS == "windows" {
+		return strings.TrimSuffix(binName, ".exe") + ".cmd"
+	}
+	return binName
+}
+
+// utilDeployShim returns the content of the shim that executes the target with arguments.
+func utilDeployShim(target string) []byte {
+	if runtime.GOOS == "windows" {
+		return []byte("@echo off\r\nrem generated by switchbox, do not edit\r\n\"" + target + "\" %*\r\n")
+	}
+	return []byte("#!/bin/sh\n# generated by switchbox, do not edit\nexec '" + strings.ReplaceAll(target, "'", `'\''`) + "' \"$@\"\n")
+}
+
+// utilDeployCreate creates the file at the path that executes the target in the deploy mode.
+func utilDeployCreate(mode, target, path string) error {
+	switch mode {
+	case DeployModeSymlink:
+		return os.Symlink(target, path)
+	case DeployModeHardlink:
+		return os.Link(target, path)
+	case DeployModeShim:
+		return os.WriteFile(path, utilDeployShim(target), 0755)
+	case DeployModeCopy:
+		src, err := os.Open(target)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = src.Close()
+		}()
+		dst, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0755)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(dst, src); err != nil {
+			_ = dst.Close()
+			return err
+		}
+		return dst.Close()
+	default:
+		return ErrorInvalidDeployMode
+	}
+}
+
+// utilLinkStagingPath returns the unique path next to the path, with the prefix.
+func utilLinkStagingPath(path, prefix string) string {
+	return filepath.Join(filepath.Dir(path), prefix+filepath.Base(path)+"-"+strconv.FormatInt(time.Now().UnixNano(), 36))
+}
+
+// utilLinkReplace creates the file in the deploy mode under the temporary name, then renames it over the path.
+// The path is never missing during the replacement, if the path exists.
+func utilLinkReplace(mode, target, path string) error {
+	stagingPath := utilLinkStagingPath(path, BinDstLocalLinkStagingPrefix)
+	if err := utilDeployCreate(mode, target, stagingPath); err != nil {
+		_ = os.Remove(stagingPath)
+		return err
+	}
+	if err := os.Rename(stagingPath, path); err != nil {
+		_ = os.Remove(stagingPath)
+		return err
+	}
+	return nil
+}
+
+// utilLinkBackup keeps the file at the path as the backup. The file is kept at the path if possible.
+func utilLinkBackup(path string) (backup string, err error) {
+	backup = utilLinkStagingPath(path, BinDstLocalBackupPrefix)
+	if err := os.Link(path, backup); err == nil {
+		return backup, nil
+	}
+	if err := os.Rename(path, backup); err != nil {
+		return "", err
+	}
+	return backup, nil
+}
+
+// deployMode returns the deploy mode of the recipe.
+func (z binDstLocalWorkerImpl) deployMode() (mode string, err error) {
+	mode, err = utilDeployModeNormalize(z.recipe.DeployMode)
+	if err != nil {
+		z.ctl.Log().Warn("Unknown deploy mode", esl.String("mode", z.recipe.DeployMode))
+	}
+	return mode, err
+}
+
+func (z binDstLocalWorkerImpl) deployedPath() string {
+	return filepath.Join(z.recipe.CellarPath, BinDstLocalDeployedName)
+}
+
+// deployedRecord returns the record of deployed files. Returns the empty record if not recorded.
+func (z binDstLocalWorkerImpl) deployedRecord() (record *BinDstLocalDeployed) {
+	record = &BinDstLocalDeployed{Links: make([]BinDstLocalDeployedLink, 0)}
+	data, err := os.ReadFile(z.deployedPath())
+	if err != nil {
+		return record
+	}
+	if err := json.Unmarshal(data, record); err != nil {
+		z.ctl.Log().Warn("Unable to parse the deployed record, ignored", esl.Error(err))
+		return &BinDstLocalDeployed{Links: make([]BinDstLocalDeployedLink, 0)}
+	}
+	return record
+}
+
+// saveDeployedRecord updates records of paths, then removes records of removed paths.
+// The record is shared by packages in the cellar, then updated in the lock of the cellar.
+func (z binDstLocalWorkerImpl) saveDeployedRecord(updated []BinDstLocalDeployedLink, removed []string) error {
+	unlock, err := z.lockCellar()
+	if err != nil {
+		z.ctl.Log().Warn("Unable to lock the cellar", esl.Error(err))
+		return err
+	}
+	defer unlock()
+
+	record := z.deployedRecord()
+	links := make([]BinDstLocalDeployedLink, 0, len(record.Links)+len(updated))
+	for _, link := range record.Links {
+		keep := true
+		for _, u := range updated {
+			keep = keep && filepath.Clean(u.Path) != filepath.Clean(link.Path)
+		}
+		for _, r := range removed {
+			keep = keep && filepath.Clean(r) != filepath.Clean(link.Path)
+		}
+		if keep {
+			links = append(links, link)
+		}
+	}
+	record.Links = append(links, updated...)
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return utilLocalWriteFile(z.deployedPath(), data, 0644)
+}
+
+// deployedTarget returns the mode and the cellar binary of the file at the deploy path.
+// Returns found=false if the path is neither a symlink, nor the file deployed by switchbox without modification.
+func (z binDstLocalWorkerImpl) deployedTarget(path string, record *BinDstLocalDeployed) (mode, target string, found bool) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return "", "", false
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err = os.Readlink(path)
+		return DeployModeSymlink, target, err == nil
+	}
+	link, found := record.find(path)
+	if !found || link.Mode == DeployModeSymlink || !info.Mode().IsRegular() {
+		return "", "", false
+	}
+	if digest, err := utilSha256File(path); err != nil || digest != link.Sha256 {
+		z.ctl.Log().Debug("The deployed file is modified", esl.String("path", path))
+		return "", "", false
+	}
+	return link.Mode, link.Target, true
+}
+
+// isOwnedSymlink returns true if the symlink at the deploy path is recorded as deployed, or points to the file in the cellar.
+func (z binDstLocalWorkerImpl) isOwnedSymlink(path, target string, record *BinDstLocalDeployed) bool {
+	if _, found := record.find(path); found {
+		return true
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(path), target)
+	}
+	cellarPath, err := filepath.Abs(z.recipe.CellarPath)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(cellarPath, filepath.Clean(target))
+	if err != nil {
+		return false
+	}
+	return rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// replaceLink replaces the file at the deploy path by the file of the deploy mode. Returns the previous state to restore.
+// The file not deployed by switchbox is replaced only if forced, otherwise ErrorDeployPathConflict is returned.
+func (z binDstLocalWorkerImpl) replaceLink(mode, target, binDeployPath string, force bool, record *BinDstLocalDeployed) (previous deployLink, err error) {
+	l := z.ctl.Log().With(esl.String("binDeployPath", binDeployPath), esl.String("mode", mode))
+	previous = deployLink{path: binDeployPath}
+	info, err := os.Lstat(binDeployPath)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		l.Warn("Unable to stat the deploy path", esl.Error(err))
+		return previous, err
+	case info.Mode()&os.ModeSymlink != 0:
+		if previous.target, err = os.Readlink(binDeployPath); err != nil {
+			l.Warn("Unable to read the existing link", esl.Error(err))
+			return previous, err
+		}
+		if !z.isOwnedSymlink(binDeployPath, previous.target, record) && !force {
+			l.Warn("The existing symlink does not point to the cellar, use the force option to replace", esl.String("target", previous.target))
+			return deployLink{path: binDeployPath}, ErrorDeployPathConflict
+		}
+		l.Info("Existing symlink found", esl.String("target", previous.target))
+	default:
+		if _, _, owned := z.deployedTarget(binDeployPath, record); !owned && !force {
+			l.Warn("The deploy path is not deployed by switchbox, use the force option to replace", esl.String("fileMode", info.Mode().String()))
+			return previous, ErrorDeployPathConflict
+		}
+		if previous.backup, err = utilLinkBackup(binDeployPath); err != nil {
+			l.Warn("Unable to keep the existing file", esl.Error(err))
+			return deployLink{path: binDeployPath}, err
+		}
+		l.Info("Existing file kept as the backup", esl.String("backup", previous.backup))
+	}
+
+	if err := utilLinkReplace(mode, target, binDeployPath); err != nil {
+		l.Warn("Unable to deploy", esl.Error(err))
+		z.restoreLinks([]deployLink{previous})
+		return previous, err
+	}
+	previous.deployed = BinDstLocalDeployedLink{
+		Path:       binDeployPath,
+		Mode:       mode,
+		Target:     target,
+		DeployedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	if mode != DeployModeSymlink {
+		if previous.deployed.Sha256, err = utilSha256File(binDeployPath); err != nil {
+			l.Warn("Unable to compute the digest of the deployed file", esl.Error(err))
+			z.restoreLinks([]deployLink{previous})
+			return previous, err
+		}
+	}
+	return previous, nil
+}
+
+// restoreLinks restores paths to previous states. The path without the previous state is removed.
+func (z binDstLocalWorkerImpl) restoreLinks(links []deployLink) {
+	l := z.ctl.Log()
+	for _, link := range links {
+		ll := l.With(esl.String("binDeployPath", link.path), esl.String("target", link.target), esl.String("backup", link.backup))
+		var err error
+		switch {
+		case link.target != "":
+			err = utilLinkReplace(DeployModeSymlink, link.target, link.path)
+		case link.backup != "":
+			if err = os.Rename(link.backup, link.path); err != nil {
+				// the backup is the directory, that is not renamed over the file.
+				_ = os.Remove(link.path)
+				err = os.Rename(link.backup, link.path)
+			}
+		default:
+			if err = os.Remove(link.path); os.IsNotExist(err) {
+				err = nil
+			}
+		}
+		if err != nil {
+			ll.Warn("Unable to restore the deploy path", esl.Error(err))
+			continue
+		}
+		ll.Info("Deploy path restored")
+	}
+}
+
+// removeBackups removes previous files replaced by the successful deployment.
+func (z binDstLocalWorkerImpl) removeBackups(links []deployLink) {
+	l := z.ctl.Log()
+	for _, link := range links {
+		if link.backup == "" {
+			continue
+		}
+		if err := os.RemoveAll(link.backup); err != nil {
+			l.Warn("Unable to remove the backup", esl.String("backup", link.backup), esl.Error(err))
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package sb_deploy
+
+import (
+	"errors"
+	"github.com/watermint/toolbox/essentials/strings/es_version"
+	"github.com/watermint/toolbox/infra/control/app_control"
+	"github.com/watermint/toolbox/quality/recipe/qtr_endtoend"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBinDstLocal_DeploySymlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlink is not supported")
+	}
+	qtr_endtoend.TestWithControl(t, func(ctl app_control.Control) {
+		sourcePath := t.TempDir()
+		testLocalDirSource(t, sourcePath, "linux-amd64", "1.0.0")
+		deployPath := filepath.Join(t.TempDir(), "bin")
+		if err := os.MkdirAll(deployPath, 0755); err != nil {
+			t.Fatal(err)
+		}
+		cellarPath := filepath.Join(t.TempDir(), "cellar")
+		newWorker := func(binaries ...BinDstLocalBinary) BinDeploy {
+			return NewBinSrcLocalDirDstLocal(BinSrcLocalDirDstLocalRecipe{
+				SourcePath: sourcePath,
+				BinDstLocalRecipe: BinDstLocalRecipe{
+					BinaryName: "myapp",
+					Prefix:     "myapp",
+					Suffix:     "linux-amd64",
+					CellarPath: cellarPath,
+					DeployPath: deployPath,
+					Binaries:   binaries,
+				},
+			}, ctl)
+		}
+		linkPath := filepath.Join(deployPath, "myapp")
+		deployed := func() []string {
+			entries, err := os.ReadDir(deployPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			names := make([]string, 0)
+			for _, e := range entries {
+				if e.Name() != LockName {
+					names = append(names, e.Name())
+				}
+			}
+			return names
+		}
+
+		// the regular file not created by switchbox
+		if err := os.WriteFile(linkPath, []byte("mine"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		worker := newWorker()
+		if err := worker.DeploySymlink(); !errors.Is(err, ErrorDeployPathConflict) {
+			t.Error(err)
+		}
+		if c, err := os.ReadFile(linkPath); err != nil || string(c) != "mine" {
+			t.Error(string(c), err)
+		}
+
+		// the existing link outside the cellar is not replaced
+		if err := os.Remove(linkPath); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink("/no/such/previous", linkPath); err != nil {
+			t.Fatal(err)
+		}
+		if err := worker.DeploySymlink(); !errors.Is(err, ErrorDeployPathConflict) {
+			t.Error(err)
+		}
+		if target, err := os.Readlink(linkPath); err != nil || target != "/no/such/previous" {
+			t.Error(target, err)
+		}
+
+		// the existing link to the cellar is replaced
+		previousPath := filepath.Join(cellarPath, "myapp-0.9.0", "myapp")
+		if err := os.Remove(linkPath); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(previousPath, linkPath); err != nil {
+			t.Fatal(err)
+		}
+		if err := worker.DeploySymlink(); err != nil {
+			t.Error(err)
+		}
+		if target, err := os.Readlink(linkPath); err != nil || target != worker.LocalLatestBinaryPath() {
+			t.Error(target, err)
+		}
+		if names := deployed(); len(names) != 1 {
+			t.Error(names)
+		}
+
+		// the previous link is restored on failure of the other binary
+		if err := os.Symlink(previousPath, linkPath+".tmp"); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(linkPath+".tmp", linkPath); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Mkdir(filepath.Join(deployPath, "helper"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		withHelper := newWorker(BinDstLocalBinary{Name: "myapp", Alias: "helper"})
+		if err := withHelper.DeploySymlink(); !errors.Is(err, ErrorDeployPathConflict) {
+			t.Error(err)
+		}
+		if target, err := os.Readlink(linkPath); err != nil || target != previousPath {
+			t.Error(target, err)
+		}
+
+		// forced
+		if err := withHelper.DeploySymlinkForce(); err != nil {
+			t.Error(err)
+		}
+		if !withHelper.IsDeployed() {
+			t.Error("not deployed")
+		}
+		if names := deployed(); len(names) != 2 {
+			t.Error(names)
+		}
+	})
+}
+
+func TestBinDstLocal_DeployMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shim is the batch file")
+	}
+	qtr_endtoend.TestWithControl(t, func(ctl app_control.Control) {
+		for _, mode := range []string{DeployModeHardlink, DeployModeCopy, DeployModeShim} {
+			sourcePath := t.TempDir()
+			testLocalDirSource(t, sourcePath, "linux-amd64", "1.0.0", "1.1.0")
+			deployPath := filepath.Join(t.TempDir(), "bin")
+			cellarPath := filepath.Join(t.TempDir(), "cellar")
+			worker := NewBinSrcLocalDirDstLocal(BinSrcLocalDirDstLocalRecipe{
+				SourcePath: sourcePath,
+				BinDstLocalRecipe: BinDstLocalRecipe{
+					BinaryName: "myapp",
+					Prefix:     "myapp",
+					Suffix:     "linux-amd64",
+					CellarPath: cellarPath,
+					DeployPath: deployPath,
+					DeployMode: mode,
+				},
+			}, ctl)
+			_, versionPaths, _, err := worker.ListRemoteVersions()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, v := range []string{"1.0.0", "1.1.0"} {
+				dlPath, err := worker.Download(es_version.MustParse(v), versionPaths[v])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := worker.Extract(es_version.MustParse(v), dlPath); err != nil {
+					t.Fatal(err)
+				}
+			}
+			binDeployPath := filepath.Join(deployPath, "myapp")
+			deployed := func() string {
+				info, err := os.Lstat(binDeployPath)
+				if err != nil || info.Mode()&os.ModeSymlink != 0 || info.Mode().Perm()&0111 == 0 {
+					t.Error(mode, info, err)
+				}
+				content, err := os.ReadFile(binDeployPath)
+				if err != nil {
+					t.Error(mode, err)
+				}
+				if mode == DeployModeShim {
+					// the shim executes the binary in the cellar, like `exec '/path/to/cellar/myapp-1.1.0/myapp' "$@"`
+					for _, v := range []string{"1.0.0", "1.1.0"} {
+						if strings.Contains(string(content), "myapp-"+v) {
+							return v
+						}
+					}
+				}
+				return string(content)
+			}
+
+			if err := worker.DeploySymlink(); err != nil || deployed() != "1.1.0" || !worker.IsDeployed() {
+				t.Error(mode, err)
+			}
+			if v, err := worker.Rollback(""); err != nil || v.String() != "1.0.0" || deployed() != "1.0.0" {
+				t.Error(mode, v, err)
+			}
+			if p := worker.(*binDstLocalWorkerImpl).linkedVersionPath(); filepath.Base(p) != "myapp-1.0.0" {
+				t.Error(mode, p)
+			}
+			if err := worker.ClearRollback(); err != nil || deployed() != "1.1.0" {
+				t.Error(mode, err)
+			}
+
+			// the file modified after the deployment is not replaced
+			if err := os.Remove(binDeployPath); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(binDeployPath, []byte("modified"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if worker.IsDeployed() {
+				t.Error(mode, "modified")
+			}
+			if err := worker.DeploySymlink(); !errors.Is(err, ErrorDeployPathConflict) || deployed() != "modified" {
+				t.Error(mode, err)
+			}
+			if err := worker.DeploySymlinkForce(); err != nil || deployed() != "1.1.0" || !worker.IsDeployed() {
+				t.Error(mode, err)
+			}
+			if entries, err := os.ReadDir(deployPath); err != nil || len(entries) != 2 {
+				t.Error(mode, entries, err)
+			}
+		}
+	})
+}
+
+func TestBinDstLocal_SaveDeployedRecordSharedCellar(t *testing.T) {
+	qtr_endtoend.TestWithControl(t, func(ctl app_control.Control) {
+		cellarPath := filepath.Join(t.TempDir(), "cellar")
+		workers := make([]*binDstLocalWorkerImpl, 0)
+		for _, prefix := range []string{"myapp", "other"} {
+			workers = append(workers, NewBinSrcLocalDirDstLocal(BinSrcLocalDirDstLocalRecipe{
+				SourcePath: t.TempDir(),
+				BinDstLocalRecipe: BinDstLocalRecipe{
+					BinaryName: prefix,
+					Prefix:     prefix,
+					Suffix:     "linux-amd64",
+					CellarPath: cellarPath,
+					DeployPath: filepath.Join(t.TempDir(), "bin"),
+				},
+			}, ctl).(*binDstLocalWorkerImpl))
+		}
+		linkOf := func(w *binDstLocalWorkerImpl) BinDstLocalDeployedLink {
+			return BinDstLocalDeployedLink{Path: filepath.Join(w.recipe.DeployPath, w.recipe.Prefix), Mode: DeployModeSymlink}
+		}
+		if err := workers[0].saveDeployedRecord([]BinDstLocalDeployedLink{linkOf(workers[0])}, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		// the package deployed into the other path waits for the cellar updated by another process
+		unlock, err := workers[0].lockCellar()
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved := make(chan error, 1)
+		go func() {
+			saved <- workers[1].saveDeployedRecord([]BinDstLocalDeployedLink{linkOf(workers[1])}, nil)
+		}()
+		time.Sleep(200 * time.Millisecond)
+		if len(saved) > 0 {
+			t.Error("saved without the lock")
+		}
+		unlock()
+		if err := <-saved; err != nil {
+			t.Error(err)
+		}
+
+		record := workers[0].deployedRecord()
+		for _, w := range workers {
+			if _, found := record.find(linkOf(w).Path); !found {
+				t.Error(w.recipe.Prefix, record)
+			}
+		}
+		if names, _ := filepath.Glob(filepath.Join(cellarPath, BinDstLocalDeployedName+".*")); len(names) != 0 {
+			t.Error(names)
+		}
+	})
+}
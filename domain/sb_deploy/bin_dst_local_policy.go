@@ -0,0 +1,285 @@
+package sb_deploy
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/watermint/toolbox/essentials/log/esl"
+	"github.com/watermint/toolbox/essentials/strings/es_version"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// BinDstLocalFirstSeenPrefix is the prefix of the file in the cellar, that records the time when remote versions
+	// are found for the first time. The time is the basis of the minimum age, if the source does not publish
+	// the release time. The file is per package, like `.sb_deploy.first_seen.myapp.json`.
+	BinDstLocalFirstSeenPrefix = ".sb_deploy.first_seen."
+)
+
+var (
+	ErrorInvalidUpdatePolicy = errors.New("invalid update policy")
+)
+
+var (
+	policyWeekdays = map[string]time.Weekday{
+		"sun": time.Sunday,
+		"mon": time.Monday,
+		"tue": time.Tuesday,
+		"wed": time.Wednesday,
+		"thu": time.Thursday,
+		"fri": time.Friday,
+		"sat": time.Saturday,
+	}
+)
+
+// BinDstLocalUpdatePolicy is the policy of updates by UpdateIfRequired, such as `dispatch run`.
+// The update out of the policy is deferred, and the current local version is used.
+// The policy is not applied to the forced update, and the first installation.
+type BinDstLocalUpdatePolicy struct {
+	// Windows are allowed update windows in local time. Updates are allowed anytime if empty.
+	Windows []BinDstLocalUpdateWindow `json:"windows,omitempty"`
+
+	// MinAge is the minimum age of the version to be eligible, like `72h` or `3d`.
+	// The age is from the release time published by the source, or the time when the version is found.
+	MinAge string `json:"min_age,omitempty"`
+
+	// MaxDeferral is the maximum duration to defer the eligible version out of windows, like `168h` or `7d`.
+	// The version is installed out of windows after the duration. The version is deferred until the window if empty.
+	MaxDeferral string `json:"max_deferral,omitempty"`
+}
+
+// BinDstLocalUpdateWindow is the allowed update window in local time.
+// The window crosses midnight if the end is earlier than the start, like `22:00` to `02:00`.
+type BinDstLocalUpdateWindow struct {
+	// Days are days of the week of the start, like `mon` or `saturday`. Every day if empty.
+	Days []string `json:"days,omitempty"`
+
+	// Start is the start time like `01:00`. The start of the day if empty.
+	Start string `json:"start,omitempty"`
+
+	// End is the end time like `05:00` (exclusive). The end of the day (`24:00`) if empty.
+	End string `json:"end,omitempty"`
+}
+
+// updateWindow is the parsed update window, times in minutes of the day.
+type updateWindow struct {
+	days  map[time.Weekday]bool
+	start int
+	end   int
+}
+
+func (z updateWindow) onDay(d time.Weekday) bool {
+	return len(z.days) < 1 || z.days[d]
+}
+
+func (z updateWindow) includes(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	if z.start < z.end {
+		return z.onDay(t.Weekday()) && z.start <= m && m < z.end
+	}
+	// crosses midnight
+	yesterday := (t.Weekday() + 6) % 7
+	return (z.onDay(t.Weekday()) && z.start <= m) || (z.onDay(yesterday) && m < z.end)
+}
+
+// updatePolicy is the parsed update policy.
+type updatePolicy struct {
+	windows     []updateWindow
+	minAge      time.Duration
+	maxDeferral time.Duration
+	hasDeferral bool
+}
+
+// inWindow returns true if updates are allowed at the time.
+func (z updatePolicy) inWindow(t time.Time) bool {
+	if len(z.windows) < 1 {
+		return true
+	}
+	for _, w := range z.windows {
+		if w.includes(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// utilPolicyParseClock parses the time like `01:00` into minutes of the day. The time after maxMinutes is rejected.
+func utilPolicyParseClock(s string, defaultMinutes, maxMinutes int) (minutes int, err error) {
+	if s == "" {
+		return defaultMinutes, nil
+	}
+	hh, mm, found := strings.Cut(s, ":")
+	if !found {
+		return 0, ErrorInvalidUpdatePolicy
+	}
+	h, err := strconv.Atoi(hh)
+	if err != nil {
+		return 0, ErrorInvalidUpdatePolicy
+	}
+	m, err := strconv.Atoi(mm)
+	if err != nil {
+		return 0, ErrorInvalidUpdatePolicy
+	}
+	minutes = h*60 + m
+	if h < 0 || m < 0 || 59 < m || maxMinutes < minutes {
+		return 0, ErrorInvalidUpdatePolicy
+	}
+	return minutes, nil
+}
+
+// utilPolicyParseDay parses the day of the week like `mon` or `Monday`.
+func utilPolicyParseDay(s string) (day time.Weekday, err error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if len(s) < 3 {
+		return 0, ErrorInvalidUpdatePolicy
+	}
+	day, ok := policyWeekdays[s[:3]]
+	if !ok || !strings.HasPrefix(strings.ToLower(day.String()), s) {
+		return 0, ErrorInvalidUpdatePolicy
+	}
+	return day, nil
+}
+
+func utilPolicyParse(policy BinDstLocalUpdatePolicy) (parsed updatePolicy, err error) {
+	parsed = updatePolicy{windows: make([]updateWindow, 0, len(policy.Windows))}
+	for _, w := range policy.Windows {
+		window := updateWindow{days: make(map[time.Weekday]bool)}
+		for _, d := range w.Days {
+			day, err := utilPolicyParseDay(d)
+			if err != nil {
+				return parsed, err
+			}
+			window.days[day] = true
+		}
+		// `24:00` is allowed only for the end, because the window from the end of the day never matches.
+		if window.start, err = utilPolicyParseClock(w.Start, 0, 24*60-1); err != nil {
+			return parsed, err
+		}
+		if window.end, err = utilPolicyParseClock(w.End, 24*60, 24*60); err != nil {
+			return parsed, err
+		}
+		if window.start == window.end {
+			return parsed, ErrorInvalidUpdatePolicy
+		}
+		parsed.windows = append(parsed.windows, window)
+	}
+	if policy.MinAge != "" {
+		if parsed.minAge, err = utilRetentionParseDuration(policy.MinAge); err != nil {
+			return parsed, ErrorInvalidUpdatePolicy
+		}
+	}
+	if policy.MaxDeferral != "" {
+		if parsed.maxDeferral, err = utilRetentionParseDuration(policy.MaxDeferral); err != nil {
+			return parsed, ErrorInvalidUpdatePolicy
+		}
+		parsed.hasDeferral = true
+	}
+	return parsed, nil
+}
+
+// utilPolicyEvaluate returns versions eligible by the minimum age, and deferred=true if the update of eligible versions
+// is deferred at the time. The released is the release time (or the time found) of versions, the version string as key.
+func utilPolicyEvaluate(policy updatePolicy, now time.Time, versions []es_version.Version, released map[string]time.Time) (eligible []es_version.Version, deferred bool) {
+	eligible = make([]es_version.Version, 0, len(versions))
+	var earliest time.Time
+	for _, v := range versions {
+		r, ok := released[v.String()]
+		if !ok {
+			r = now
+		}
+		eligibleAt := r.Add(policy.minAge)
+		if now.Before(eligibleAt) {
+			continue
+		}
+		eligible = append(eligible, v)
+		if earliest.IsZero() || eligibleAt.Before(earliest) {
+			earliest = eligibleAt
+		}
+	}
+	if len(eligible) < 1 || policy.inWindow(now) {
+		return eligible, false
+	}
+	if policy.hasDeferral && now.Sub(earliest) >= policy.maxDeferral {
+		return eligible, false
+	}
+	return eligible, true
+}
+
+func (z binDstLocalWorkerImpl) firstSeenPath() string {
+	return filepath.Join(z.recipe.CellarPath, BinDstLocalFirstSeenPrefix+z.recipe.Prefix+".json")
+}
+
+// recordFirstSeen records the time of versions found for the first time, then returns times of all versions.
+func (z binDstLocalWorkerImpl) recordFirstSeen(versions []es_version.Version, now time.Time) (firstSeen map[string]string) {
+	l := z.ctl.Log()
+	firstSeen = make(map[string]string)
+	if data, err := os.ReadFile(z.firstSeenPath()); err == nil {
+		if err := json.Unmarshal(data, &firstSeen); err != nil {
+			l.Debug("Unable to parse the first seen record, ignored", esl.Error(err))
+			firstSeen = make(map[string]string)
+		}
+	}
+	updated := false
+	for _, v := range versions {
+		if _, ok := firstSeen[v.String()]; !ok {
+			firstSeen[v.String()] = now.UTC().Format(time.RFC3339)
+			updated = true
+		}
+	}
+	if !updated {
+		return firstSeen
+	}
+	data, err := json.Marshal(firstSeen)
+	if err != nil {
+		l.Debug("Unable to marshal the first seen record", esl.Error(err))
+		return firstSeen
+	}
+	// written through the temporary file, because processes like `dispatch run` may check updates at the same time.
+	if err := utilLocalWriteFile(z.firstSeenPath(), data, 0644); err != nil {
+		l.Debug("Unable to write the first seen record", esl.Error(err))
+	}
+	return firstSeen
+}
+
+// applyUpdatePolicy returns remote versions eligible by the update policy, and deferred=true if the update
+// is deferred. All versions are returned if no policy is defined.
+func (z binDstLocalWorkerImpl) applyUpdatePolicy(localVersionLatest es_version.Version, remoteVersions []es_version.Version, metas map[string]BinRemoteVersionMeta) (eligible []es_version.Version, deferred bool, err error) {
+	l := z.ctl.Log()
+	if z.recipe.UpdatePolicy == nil {
+		return remoteVersions, false, nil
+	}
+	policy, err := utilPolicyParse(*z.recipe.UpdatePolicy)
+	if err != nil {
+		l.Warn("Invalid update policy", esl.Error(err))
+		return nil, false, err
+	}
+
+	now := time.Now()
+	firstSeen := z.recordFirstSeen(remoteVersions, now)
+	pending := make([]es_version.Version, 0)
+	released := make(map[string]time.Time)
+	for _, v := range remoteVersions {
+		if es_version.Compare(v, localVersionLatest) <= 0 {
+			continue
+		}
+		pending = append(pending, v)
+		for _, t := range []string{metas[v.String()].PublishedAt, firstSeen[v.String()]} {
+			if r, err := time.Parse(time.RFC3339, t); err == nil {
+				released[v.String()] = r
+				break
+			}
+		}
+	}
+
+	eligible, deferred = utilPolicyEvaluate(policy, now, pending, released)
+	if len(eligible) < len(pending) {
+		l.Info("Versions newer than the local version are not eligible yet", esl.Int("pending", len(pending)), esl.Int("eligible", len(eligible)), esl.String("minAge", z.recipe.UpdatePolicy.MinAge))
+	}
+	if deferred {
+		l.Info("The update is deferred until the update window", esl.String("version", es_version.Max(eligible...).String()))
+	}
+	return eligible, deferred, nil
+}
@@ -0,0 +1,195 @@
+package sb_deploy
+
+import (
+	"github.com/watermint/toolbox/essentials/strings/es_version"
+	"github.com/watermint/toolbox/infra/control/app_control"
+	"github.com/watermint/toolbox/quality/recipe/qtr_endtoend"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUtilPolicyEvaluate(t *testing.T) {
+	policy, err := utilPolicyParse(BinDstLocalUpdatePolicy{
+		Windows: []BinDstLocalUpdateWindow{
+			{Days: []string{"sat", "Sunday"}},
+			{Days: []string{"thu"}, Start: "22:00", End: "02:00"},
+		},
+		MinAge:      "3d",
+		MaxDeferral: "168h",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := utilPolicyParse(BinDstLocalUpdatePolicy{Windows: []BinDstLocalUpdateWindow{{Start: "22:00", End: "24:00"}}}); err != nil {
+		t.Error(err)
+	}
+	// 2024-05-01 is Wednesday
+	at := func(s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	windows := map[string]bool{
+		"2024-05-01 12:00": false,
+		"2024-05-02 21:59": false,
+		"2024-05-02 22:00": true,
+		"2024-05-03 01:59": true,
+		"2024-05-03 02:00": false,
+		"2024-05-04 12:00": true,
+		"2024-05-05 23:59": true,
+		"2024-05-06 01:00": false,
+	}
+	for s, expected := range windows {
+		if policy.inWindow(at(s)) != expected {
+			t.Error(s, expected)
+		}
+	}
+
+	versions := []es_version.Version{es_version.MustParse("1.1.0"), es_version.MustParse("1.2.0")}
+	released := map[string]time.Time{
+		"1.1.0": at("2024-04-26 12:00"),
+		"1.2.0": at("2024-04-30 12:00"),
+	}
+	// 1.2.0 is not old enough, 1.1.0 is deferred until the window
+	if eligible, deferred := utilPolicyEvaluate(policy, at("2024-05-01 12:00"), versions, released); len(eligible) != 1 || !deferred {
+		t.Error(eligible, deferred)
+	}
+	// deferred more than 7 days
+	if eligible, deferred := utilPolicyEvaluate(policy, at("2024-05-07 12:00"), versions, released); len(eligible) != 2 || deferred {
+		t.Error(eligible, deferred)
+	}
+	// no eligible version
+	if eligible, deferred := utilPolicyEvaluate(policy, at("2024-04-27 12:00"), versions, released); len(eligible) != 0 || deferred {
+		t.Error(eligible, deferred)
+	}
+
+	for _, invalid := range []BinDstLocalUpdatePolicy{
+		{Windows: []BinDstLocalUpdateWindow{{Days: []string{"someday"}}}},
+		{Windows: []BinDstLocalUpdateWindow{{Start: "25:00"}}},
+		{Windows: []BinDstLocalUpdateWindow{{Start: "24:00"}}},
+		{Windows: []BinDstLocalUpdateWindow{{Start: "01:00", End: "01:00"}}},
+		{MinAge: "three days"},
+		{MaxDeferral: "-1h"},
+	} {
+		if _, err := utilPolicyParse(invalid); err != ErrorInvalidUpdatePolicy {
+			t.Error(invalid, err)
+		}
+	}
+}
+
+func TestBinDstLocal_UpdatePolicy(t *testing.T) {
+	qtr_endtoend.TestWithControl(t, func(ctl app_control.Control) {
+		sourcePath := t.TempDir()
+		testLocalDirSource(t, sourcePath, "linux-amd64", "1.0.0")
+		cellarPath := filepath.Join(t.TempDir(), "cellar")
+		newWorker := func(policy *BinDstLocalUpdatePolicy) BinDeploy {
+			return NewBinSrcLocalDirDstLocal(BinSrcLocalDirDstLocalRecipe{
+				SourcePath: sourcePath,
+				BinDstLocalRecipe: BinDstLocalRecipe{
+					BinaryName:   "myapp",
+					Prefix:       "myapp",
+					Suffix:       "linux-amd64",
+					CellarPath:   cellarPath,
+					UpdatePolicy: policy,
+				},
+			}, ctl)
+		}
+		// the window of the day after tomorrow
+		closed := &BinDstLocalUpdatePolicy{
+			Windows: []BinDstLocalUpdateWindow{
+				{Days: []string{strings.ToLower(time.Now().Add(48 * time.Hour).Weekday().String())}},
+			},
+		}
+
+		// the first installation is not deferred
+		if err := newWorker(closed).UpdateIfRequired(); err != nil {
+			t.Fatal(err)
+		}
+		testLocalDirSource(t, sourcePath, "linux-amd64", "1.1.0")
+		caches, _ := filepath.Glob(filepath.Join(ctl.Workspace().Cache(), BinDstLocalVersionCacheName+"*"))
+		for _, c := range caches {
+			if err := os.Remove(c); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, policy := range []*BinDstLocalUpdatePolicy{closed, {MinAge: "1h"}} {
+			worker := newWorker(policy)
+			if required, err := worker.IsUpdateRequired(); err != nil || required {
+				t.Error(required, err)
+			}
+			if err := worker.UpdateIfRequired(); err != nil {
+				t.Error(err)
+			}
+			if _, v, err := worker.GetLocalLatest(); err != nil || v.String() != "1.0.0" {
+				t.Error(v, err)
+			}
+			// the update keeps the local version
+			if status, err := worker.ListRemoteVersionStatus(); err != nil || len(status) != 2 || status[0].Latest || !status[1].Latest {
+				t.Error(status, err)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(cellarPath, BinDstLocalFirstSeenPrefix+"myapp.json")); err != nil {
+			t.Error(err)
+		}
+
+		if required, err := newWorker(nil).IsUpdateRequired(); err != nil || !required {
+			t.Error(required, err)
+		}
+		if status, err := newWorker(nil).ListRemoteVersionStatus(); err != nil || len(status) != 2 || !status[0].Latest || status[0].Version != "1.1.0" {
+			t.Error(status, err)
+		}
+
+		// the forced update is not deferred
+		worker := newWorker(closed)
+		if err := worker.UpdateForce(); err != nil {
+			t.Error(err)
+		}
+		if _, v, err := worker.GetLocalLatest(); err != nil || v.String() != "1.1.0" {
+			t.Error(v, err)
+		}
+	})
+}
+
+func TestBinDstLocal_RecordFirstSeen(t *testing.T) {
+	qtr_endtoend.TestWithControl(t, func(ctl app_control.Control) {
+		cellarPath := filepath.Join(t.TempDir(), "cellar")
+		if err := os.MkdirAll(cellarPath, 0755); err != nil {
+			t.Fatal(err)
+		}
+		newWorker := func(prefix string) *binDstLocalWorkerImpl {
+			return NewBinSrcLocalDirDstLocal(BinSrcLocalDirDstLocalRecipe{
+				SourcePath: t.TempDir(),
+				BinDstLocalRecipe: BinDstLocalRecipe{
+					BinaryName: prefix,
+					Prefix:     prefix,
+					Suffix:     "linux-amd64",
+					CellarPath: cellarPath,
+				},
+			}, ctl).(*binDstLocalWorkerImpl)
+		}
+		versions := []es_version.Version{es_version.MustParse("1.0.0")}
+		seenAt := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+		laterAt := seenAt.Add(72 * time.Hour)
+
+		if firstSeen := newWorker("myapp").recordFirstSeen(versions, seenAt); firstSeen["1.0.0"] != "2024-04-01T00:00:00Z" {
+			t.Error(firstSeen)
+		}
+		if firstSeen := newWorker("myapp").recordFirstSeen(versions, laterAt); firstSeen["1.0.0"] != "2024-04-01T00:00:00Z" {
+			t.Error(firstSeen)
+		}
+		// the same version of the other package in the same cellar
+		if firstSeen := newWorker("other").recordFirstSeen(versions, laterAt); firstSeen["1.0.0"] != "2024-04-04T00:00:00Z" {
+			t.Error(firstSeen)
+		}
+		// no temporary file is left
+		if entries, err := os.ReadDir(cellarPath); err != nil || len(entries) != 2 {
+			t.Error(entries, err)
+		}
+	})
+}
@@ -0,0 +1,143 @@
+package sb_deploy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/watermint/toolbox/essentials/log/esl"
+	"github.com/watermint/toolbox/essentials/strings/es_version"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// BinDstLocalRejectedPrefix is the prefix of the file in the cellar, that records versions rejected by the signature.
+	// The file is per package, like `.sb_deploy.rejected.myapp.json`, since packages may share the cellar.
+	BinDstLocalRejectedPrefix = ".sb_deploy.rejected."
+)
+
+// BinDstLocalRejected is the record of the version rejected by the signature. The version is not downloaded
+// again while the rejection is effective, then the update is not retried on every check.
+type BinDstLocalRejected struct {
+	// Path is the version path of the rejected asset.
+	Path string `json:"path"`
+
+	// Sha256 is the SHA-256 digest of the rejected asset.
+	Sha256 string `json:"sha256"`
+
+	// Reason is the error of the rejection.
+	Reason string `json:"reason"`
+
+	// RejectedAt is the time of the rejection.
+	RejectedAt string `json:"rejected_at"`
+}
+
+// binDstLocalRejection is the error of the asset rejected by the signature, with the digest of the asset.
+type binDstLocalRejection struct {
+	sha256 string
+	err    error
+}
+
+func (z *binDstLocalRejection) Error() string {
+	return z.err.Error()
+}
+
+func (z *binDstLocalRejection) Unwrap() error {
+	return z.err
+}
+
+func (z binDstLocalWorkerImpl) rejectedPath() string {
+	return filepath.Join(z.recipe.CellarPath, BinDstLocalRejectedPrefix+z.recipe.Prefix+".json")
+}
+
+// rejectedRecord returns records of rejected versions, the version string as key.
+func (z binDstLocalWorkerImpl) rejectedRecord() (records map[string]BinDstLocalRejected) {
+	records = make(map[string]BinDstLocalRejected)
+	data, err := os.ReadFile(z.rejectedPath())
+	if err != nil {
+		return records
+	}
+	if err := json.Unmarshal(data, &records); err != nil {
+		z.ctl.Log().Debug("Unable to parse the rejected record, ignored", esl.Error(err))
+		return make(map[string]BinDstLocalRejected)
+	}
+	return records
+}
+
+func (z binDstLocalWorkerImpl) saveRejectedRecord(records map[string]BinDstLocalRejected) error {
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(z.recipe.CellarPath, 0755); err != nil {
+		return err
+	}
+	// written through the temporary file, because the record is read without the lock of the cellar.
+	return utilLocalWriteFile(z.rejectedPath(), data, 0644)
+}
+
+// recordRejected records the version rejected by the signature. The caller must hold the lock of the cellar.
+func (z binDstLocalWorkerImpl) recordRejected(version es_version.Version, versionPath string, rejectErr error) {
+	l := z.ctl.Log().With(esl.String("version", version.String()))
+	rejection := &binDstLocalRejection{}
+	if !errors.As(rejectErr, &rejection) {
+		l.Debug("No digest of the rejected asset, skip recording", esl.Error(rejectErr))
+		return
+	}
+	records := z.rejectedRecord()
+	if previous, found := records[version.String()]; found && previous.Sha256 == rejection.sha256 {
+		l.Info("The same asset is rejected again", esl.String("sha256", rejection.sha256))
+	}
+	records[version.String()] = BinDstLocalRejected{
+		Path:       versionPath,
+		Sha256:     rejection.sha256,
+		Reason:     rejectErr.Error(),
+		RejectedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	if err := z.saveRejectedRecord(records); err != nil {
+		l.Debug("Unable to write the rejected record", esl.Error(err))
+	}
+}
+
+// clearRejected clears the record of the version installed. The caller must hold the lock of the cellar.
+func (z binDstLocalWorkerImpl) clearRejected(version es_version.Version) {
+	records := z.rejectedRecord()
+	if _, found := records[version.String()]; !found {
+		return
+	}
+	delete(records, version.String())
+	if err := z.saveRejectedRecord(records); err != nil {
+		z.ctl.Log().Debug("Unable to write the rejected record", esl.Error(err))
+	}
+}
+
+// isRejectionEffective returns true if the rejection of the version is still effective. The rejection expires if the
+// version path is changed, or after BinDstLocalVersionCacheLifecycle as well as remote versions, then the version is
+// verified again, because the signature may be published or fixed without changing the asset.
+func (z binDstLocalWorkerImpl) isRejectionEffective(versionPath string, record BinDstLocalRejected) bool {
+	if record.Path != versionPath {
+		return false
+	}
+	rejectedAt, err := time.Parse(time.RFC3339, record.RejectedAt)
+	return err == nil && time.Since(rejectedAt) < BinDstLocalVersionCacheLifecycle*time.Second
+}
+
+// excludeRejected excludes versions rejected by the signature while the rejection is effective.
+// Returns errors of the rejection of excluded versions.
+func (z binDstLocalWorkerImpl) excludeRejected(versions []es_version.Version, versionPaths map[string]string) (accepted []es_version.Version, rejected []error) {
+	l := z.ctl.Log()
+	records := z.rejectedRecord()
+	accepted = make([]es_version.Version, 0, len(versions))
+	rejected = make([]error, 0)
+	for _, v := range versions {
+		record, found := records[v.String()]
+		if !found || !z.isRejectionEffective(versionPaths[v.String()], record) {
+			accepted = append(accepted, v)
+			continue
+		}
+		l.Debug("Skip the rejected version", esl.String("version", v.String()), esl.String("reason", record.Reason))
+		rejected = append(rejected, fmt.Errorf("%s: %s", v.String(), record.Reason))
+	}
+	return accepted, rejected
+}
@@ -0,0 +1,266 @@
+package sb_deploy
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/watermint/toolbox/essentials/log/esl"
+	"github.com/watermint/toolbox/essentials/strings/es_version"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// BinDstLocalDefaultKeepLatest is the number of latest versions to keep, if no retention policy is defined.
+	BinDstLocalDefaultKeepLatest = 3
+)
+
+const (
+	PruneStatusKeep    = "keep"
+	PruneStatusRemove  = "remove"
+	PruneStatusRemoved = "removed"
+	PruneStatusFailed  = "failed"
+
+	PruneReasonLatest     = "latest"
+	PruneReasonWithin     = "within"
+	PruneReasonLinked     = "linked"
+	PruneReasonPinned     = "pinned"
+	PruneReasonRollback   = "rollback"
+	PruneReasonExpired    = "expired"
+	PruneReasonIncomplete = "incomplete"
+)
+
+var (
+	ErrorInvalidRetention = errors.New("invalid retention policy")
+)
+
+// BinDstLocalRetention is the retention policy of versions in the cellar.
+// The version is kept if any of conditions matches. The latest version, the latest version of the constraint and the channel,
+// the version linked from the deploy path, pinned versions and the rollback version are always kept.
+type BinDstLocalRetention struct {
+	// KeepLatest is the number of latest versions to keep.
+	KeepLatest int `json:"keep_latest,omitempty"`
+
+	// KeepWithin keeps versions installed within the duration, like `720h` or `30d`.
+	KeepWithin string `json:"keep_within,omitempty"`
+
+	// Pinned is the list of versions to keep.
+	Pinned []string `json:"pinned,omitempty"`
+
+	// AutoPrune is true to prune the cellar after the successful update.
+	AutoPrune bool `json:"auto_prune,omitempty"`
+}
+
+// BinDeployPruneResult is the result of the version in the cellar.
+type BinDeployPruneResult struct {
+	Version string `json:"version"`
+	Path    string `json:"path"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason"`
+	Error   string `json:"error"`
+}
+
+// utilRetentionParseDuration parses the duration like `720h` (Go duration), or `30d` (days).
+func utilRetentionParseDuration(s string) (time.Duration, error) {
+	if days, found := strings.CutSuffix(s, "d"); found {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, ErrorInvalidRetention
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, ErrorInvalidRetention
+	}
+	return d, nil
+}
+
+func (z binDstLocalWorkerImpl) retention() BinDstLocalRetention {
+	if z.recipe.Retention == nil {
+		return BinDstLocalRetention{KeepLatest: BinDstLocalDefaultKeepLatest}
+	}
+	r := *z.recipe.Retention
+	if r.KeepLatest < 1 && r.KeepWithin == "" {
+		r.KeepLatest = BinDstLocalDefaultKeepLatest
+	}
+	return r
+}
+
+// completedAt returns the time when the version is installed.
+// The modified time of the folder is used for the version without the time in the completion marker.
+func (z binDstLocalWorkerImpl) completedAt(versionPath string) (time.Time, error) {
+	if data, err := os.ReadFile(filepath.Join(versionPath, BinDstLocalCompletionMarkerName)); err == nil {
+		marker := &BinDstLocalCompletion{}
+		if err := json.Unmarshal(data, marker); err == nil {
+			if t, err := time.Parse(time.RFC3339, marker.CompletedAt); err == nil {
+				return t, nil
+			}
+		}
+	}
+	info, err := os.Stat(versionPath)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// linkedVersionPath returns the version folder that the binary in the deploy path is deployed from. Returns empty if not deployed.
+func (z binDstLocalWorkerImpl) linkedVersionPath() string {
+	if z.recipe.DeployPath == "" {
+		return ""
+	}
+	binaries, err := z.binaries()
+	if err != nil {
+		return ""
+	}
+	_, target, found := z.deployedTarget(filepath.Join(z.recipe.DeployPath, binaries[0].link), z.deployedRecord())
+	if !found {
+		return ""
+	}
+	target, err = filepath.EvalSymlinks(target)
+	if err != nil {
+		return ""
+	}
+	cellarPath, err := filepath.EvalSymlinks(z.recipe.CellarPath)
+	if err != nil {
+		return ""
+	}
+	rel, err := filepath.Rel(cellarPath, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return ""
+	}
+	return filepath.Join(z.recipe.CellarPath, strings.Split(rel, string(filepath.Separator))[0])
+}
+
+func (z binDstLocalWorkerImpl) Prune(dryRun bool) (results []BinDeployPruneResult, err error) {
+	unlock, err := z.lockCellar()
+	if err != nil {
+		z.ctl.Log().Warn("Unable to lock the cellar", esl.Error(err))
+		return nil, err
+	}
+	defer unlock()
+	return z.prune(dryRun)
+}
+
+// prune removes versions by the retention policy. The caller must hold the lock of the cellar.
+func (z binDstLocalWorkerImpl) prune(dryRun bool) (results []BinDeployPruneResult, err error) {
+	l := z.ctl.Log().With(esl.String("cellarPath", z.recipe.CellarPath), esl.Bool("dryRun", dryRun))
+	results = make([]BinDeployPruneResult, 0)
+
+	retention := z.retention()
+	var keepWithin time.Duration
+	if retention.KeepWithin != "" {
+		keepWithin, err = utilRetentionParseDuration(retention.KeepWithin)
+		if err != nil {
+			l.Warn("Unable to parse the duration of the retention", esl.String("keepWithin", retention.KeepWithin), esl.Error(err))
+			return nil, err
+		}
+	}
+	pinned := make(map[string]bool)
+	for _, p := range retention.Pinned {
+		if v, err := es_version.Parse(p); err == nil {
+			pinned[v.String()] = true
+		} else {
+			l.Warn("Unable to parse the pinned version", esl.String("version", p), esl.Error(err))
+		}
+	}
+	linkedPath := z.linkedVersionPath()
+	rollbackVersion, _, rollbackFound := z.rollbackVersion()
+
+	// all versions in the cellar, including versions out of the version constraint.
+	versions, versionPaths, err := utilLocalListLocalVersions(z.ctl, z.recipe.CellarPath, z.recipe.Prefix, z.recipe.BinaryName)
+	if err != nil {
+		l.Debug("Unable to list local versions", esl.Error(err))
+		return nil, err
+	}
+	constrainedVersions, _, err := z.ListLocalVersions()
+	if err != nil {
+		return nil, err
+	}
+	constrainedLatest := es_version.Max(constrainedVersions...)
+	sort.Slice(versions, func(i, j int) bool {
+		return es_version.Compare(versions[i], versions[j]) > 0
+	})
+
+	now := time.Now()
+	for i, ver := range versions {
+		versionPath := versionPaths[ver.String()]
+		result := BinDeployPruneResult{
+			Version: ver.String(),
+			Path:    versionPath,
+			Status:  PruneStatusKeep,
+		}
+		switch {
+		case i == 0 || i < retention.KeepLatest || (len(constrainedVersions) > 0 && constrainedLatest.Equals(ver)):
+			result.Reason = PruneReasonLatest
+		case linkedPath != "" && filepath.Clean(versionPath) == filepath.Clean(linkedPath):
+			result.Reason = PruneReasonLinked
+		case pinned[ver.String()]:
+			result.Reason = PruneReasonPinned
+		case rollbackFound && rollbackVersion.Equals(ver):
+			result.Reason = PruneReasonRollback
+		default:
+			result.Reason = PruneReasonExpired
+			if keepWithin > 0 {
+				if t, err := z.completedAt(versionPath); err == nil && now.Sub(t) <= keepWithin {
+					result.Reason = PruneReasonWithin
+				}
+			}
+		}
+		if result.Reason == PruneReasonExpired {
+			result = z.pruneRemove(result, dryRun)
+		}
+		results = append(results, result)
+	}
+
+	// folders of interrupted installations
+	entries, err := os.ReadDir(z.recipe.CellarPath)
+	if err != nil && !os.IsNotExist(err) {
+		l.Debug("Unable to read the cellar", esl.Error(err))
+		return results, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() {
+			continue
+		}
+		isStaging := strings.HasPrefix(name, BinDstLocalStagingPrefix+z.recipe.Prefix+"-")
+		isIncomplete := false
+		if verStr, found := strings.CutPrefix(name, z.recipe.Prefix+"-"); found {
+			if _, err := es_version.Parse(verStr); err == nil {
+				isIncomplete = !utilLocalIsComplete(filepath.Join(z.recipe.CellarPath, name))
+			}
+		}
+		if !isStaging && !isIncomplete {
+			continue
+		}
+		results = append(results, z.pruneRemove(BinDeployPruneResult{
+			Version: strings.TrimPrefix(strings.TrimPrefix(name, BinDstLocalStagingPrefix), z.recipe.Prefix+"-"),
+			Path:    filepath.Join(z.recipe.CellarPath, name),
+			Reason:  PruneReasonIncomplete,
+		}, dryRun))
+	}
+	return results, nil
+}
+
+func (z binDstLocalWorkerImpl) pruneRemove(result BinDeployPruneResult, dryRun bool) BinDeployPruneResult {
+	l := z.ctl.Log().With(esl.String("version", result.Version), esl.String("path", result.Path), esl.String("reason", result.Reason))
+	if dryRun {
+		l.Info("Remove (dry run)")
+		result.Status = PruneStatusRemove
+		return result
+	}
+	if err := os.RemoveAll(result.Path); err != nil {
+		l.Warn("Unable to remove the version", esl.Error(err))
+		result.Status = PruneStatusFailed
+		result.Error = err.Error()
+		return result
+	}
+	l.Info("Removed")
+	result.Status = PruneStatusRemoved
+	return result
+}
@@ -0,0 +1,125 @@
+package sb_deploy
+
+import (
+	"github.com/watermint/toolbox/essentials/strings/es_version"
+	"github.com/watermint/toolbox/infra/control/app_control"
+	"github.com/watermint/toolbox/quality/recipe/qtr_endtoend"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestUtilRetentionParseDuration(t *testing.T) {
+	if d, err := utilRetentionParseDuration("30d"); err != nil || d != 30*24*time.Hour {
+		t.Error(d, err)
+	}
+	if d, err := utilRetentionParseDuration("36h"); err != nil || d != 36*time.Hour {
+		t.Error(d, err)
+	}
+	if _, err := utilRetentionParseDuration("a week"); err != ErrorInvalidRetention {
+		t.Error(err)
+	}
+}
+
+func TestBinDstLocal_Prune(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlink is not supported")
+	}
+	qtr_endtoend.TestWithControl(t, func(ctl app_control.Control) {
+		sourcePath := t.TempDir()
+		testLocalDirSource(t, sourcePath, "linux-amd64", "1.0.0", "1.1.0", "1.2.0", "1.3.0", "1.4.0")
+		cellarPath := filepath.Join(t.TempDir(), "cellar")
+		deployPath := filepath.Join(t.TempDir(), "bin")
+		worker := NewBinSrcLocalDirDstLocal(BinSrcLocalDirDstLocalRecipe{
+			SourcePath: sourcePath,
+			BinDstLocalRecipe: BinDstLocalRecipe{
+				BinaryName: "myapp",
+				Prefix:     "myapp",
+				Suffix:     "linux-amd64",
+				CellarPath: cellarPath,
+				DeployPath: deployPath,
+				Retention: &BinDstLocalRetention{
+					KeepLatest: 2,
+					Pinned:     []string{"1.0.0"},
+				},
+			},
+		}, ctl)
+
+		_, versionPaths, _, err := worker.ListRemoteVersions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range []string{"1.0.0", "1.1.0", "1.2.0", "1.3.0", "1.4.0"} {
+			dlPath, err := worker.Download(es_version.MustParse(v), versionPaths[v])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := worker.Extract(es_version.MustParse(v), dlPath); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// 1.1.0 is linked, and 9.9.9 is the interrupted installation
+		if err := os.MkdirAll(deployPath, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(filepath.Join(cellarPath, "myapp-1.1.0", "myapp"), filepath.Join(deployPath, "myapp")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Join(cellarPath, "myapp-9.9.9"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		// the folder moved aside on the replacement, and not removed
+		if err := os.Mkdir(filepath.Join(cellarPath, utilLocalAsidePrefix(filepath.Join(cellarPath, "myapp-1.2.0"))+"x"), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := map[string]string{
+			"1.4.0":       PruneReasonLatest,
+			"1.3.0":       PruneReasonLatest,
+			"1.2.0":       PruneReasonExpired,
+			"1.1.0":       PruneReasonLinked,
+			"1.0.0":       PruneReasonPinned,
+			"9.9.9":       PruneReasonIncomplete,
+			"1.2.0-old-x": PruneReasonIncomplete,
+		}
+		check := func(results []BinDeployPruneResult, removedStatus string) {
+			if len(results) != len(expected) {
+				t.Error(results)
+			}
+			for _, r := range results {
+				status := PruneStatusKeep
+				if r.Reason == PruneReasonExpired || r.Reason == PruneReasonIncomplete {
+					status = removedStatus
+				}
+				if expected[r.Version] != r.Reason || r.Status != status {
+					t.Error(r)
+				}
+			}
+		}
+
+		results, err := worker.Prune(true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(results, PruneStatusRemove)
+		if _, err := os.Stat(filepath.Join(cellarPath, "myapp-1.2.0")); err != nil {
+			t.Error(err)
+		}
+
+		results, err = worker.Prune(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(results, PruneStatusRemoved)
+		for _, name := range []string{"myapp-1.2.0", "myapp-9.9.9"} {
+			if _, err := os.Stat(filepath.Join(cellarPath, name)); !os.IsNotExist(err) {
+				t.Error(name, err)
+			}
+		}
+		if versions, _, err := worker.ListLocalVersions(); err != nil || len(versions) != 4 {
+			t.Error(versions, err)
+		}
+	})
+}
@@ -0,0 +1,126 @@
+package sb_deploy
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/watermint/toolbox/essentials/log/esl"
+	"github.com/watermint/toolbox/essentials/strings/es_version"
+)
+
+const (
+	// RevocationListName is the name of the revocation list at the root of the source.
+	RevocationListName = "revoked.json"
+)
+
+var (
+	ErrorInvalidRevocationList = errors.New("invalid revocation list")
+	ErrorRevocationListPartial = errors.New("revocation lists of some sources are not available")
+)
+
+// BinDeployRevocationList is the list of versions that must not be installed or run.
+// The revocation list is placed at the root of the source like this:
+//
+//	{
+//	  "revoked": [
+//	    {"version": "1.4.2", "reason": "corrupts the data on save"},
+//	    {"version": ">=1.5.0 <1.5.3"}
+//	  ]
+//	}
+//
+// The version is the exact version or the version constraint.
+type BinDeployRevocationList struct {
+	Revoked []BinDeployRevokedVersion `json:"revoked"`
+}
+
+type BinDeployRevokedVersion struct {
+	// Version is the exact version, or the version constraint like `>=1.5.0 <1.5.3`.
+	Version string `json:"version"`
+
+	// Reason is the reason of the revocation, for logs.
+	Reason string `json:"reason,omitempty"`
+}
+
+// utilRevocationParse parses the revocation list. Entries with the invalid version are ignored.
+func utilRevocationParse(content []byte) (revoked []BinDeployRevokedVersion, err error) {
+	list := &BinDeployRevocationList{}
+	if err := json.Unmarshal(content, list); err != nil {
+		return nil, ErrorInvalidRevocationList
+	}
+	revoked = make([]BinDeployRevokedVersion, 0, len(list.Revoked))
+	for _, r := range list.Revoked {
+		if r.Version == "" {
+			continue
+		}
+		if _, err := utilVersionConstraintParse(r.Version); err != nil {
+			continue
+		}
+		revoked = append(revoked, r)
+	}
+	return revoked, nil
+}
+
+// utilRevocationUnion returns entries of both lists, without duplicates.
+func utilRevocationUnion(revoked, others []BinDeployRevokedVersion) []BinDeployRevokedVersion {
+	union := make([]BinDeployRevokedVersion, 0, len(revoked)+len(others))
+	seen := make(map[string]bool)
+	for _, r := range append(append([]BinDeployRevokedVersion{}, revoked...), others...) {
+		if seen[r.Version] {
+			continue
+		}
+		seen[r.Version] = true
+		union = append(union, r)
+	}
+	return union
+}
+
+// utilRevocationMatch returns the entry that revokes the version.
+func utilRevocationMatch(revoked []BinDeployRevokedVersion, version es_version.Version) (entry BinDeployRevokedVersion, found bool) {
+	for _, r := range revoked {
+		constraint, err := utilVersionConstraintParse(r.Version)
+		if err != nil {
+			continue
+		}
+		if constraint.Match(version) {
+			return r, true
+		}
+	}
+	return BinDeployRevokedVersion{}, false
+}
+
+// fetchRevocationList retrieves the revocation list from the source. Returns the empty list if not published.
+// If lists of some sources of the mirror are not available, the last known list is merged into the list.
+func (z binDstLocalWorkerImpl) fetchRevocationList() (revoked []BinDeployRevokedVersion, err error) {
+	l := z.ctl.Log().With(esl.String("source", z.source.Id()))
+	content, found, err := z.source.RevocationList()
+	partial := errors.Is(err, ErrorRevocationListPartial)
+	if err != nil && !partial {
+		l.Debug("Unable to retrieve the revocation list", esl.Error(err))
+		return nil, err
+	}
+	revoked = make([]BinDeployRevokedVersion, 0)
+	if found {
+		revoked, err = utilRevocationParse(content)
+		if err != nil {
+			l.Debug("Unable to parse the revocation list", esl.Error(err))
+			return nil, err
+		}
+	} else {
+		l.Debug("No revocation list published")
+	}
+	if partial {
+		l.Warn("Revocation lists of some sources are not available, merged with the last known list")
+		revoked = utilRevocationUnion(revoked, z.revocationList())
+	}
+	l.Debug("Revocation list", esl.Any("revoked", revoked))
+	return revoked, nil
+}
+
+// revocationList returns the last known revocation list in the remote version cache, regardless of the age
+// of the cache. Then the revoked version is not used even if the source is not reachable.
+func (z binDstLocalWorkerImpl) revocationList() []BinDeployRevokedVersion {
+	cache, err := z.readRemoteVersionCache()
+	if err != nil {
+		return []BinDeployRevokedVersion{}
+	}
+	return cache.Revoked
+}
@@ -0,0 +1,177 @@
+package sb_deploy
+
+import (
+	"encoding/json"
+	"github.com/watermint/toolbox/essentials/strings/es_version"
+	"github.com/watermint/toolbox/infra/control/app_control"
+	"github.com/watermint/toolbox/quality/recipe/qtr_endtoend"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestUtilRevocationParse(t *testing.T) {
+	revoked, err := utilRevocationParse([]byte(`{"revoked":[{"version":"1.4.2","reason":"broken"},{"version":">=1.5.0 <1.5.3"},{"version":"latest"},{"version":""}]}`))
+	if err != nil || len(revoked) != 2 {
+		t.Error(revoked, err)
+	}
+	for v, expected := range map[string]bool{"1.4.2": true, "1.4.3": false, "1.5.2": true, "1.5.3": false} {
+		if _, found := utilRevocationMatch(revoked, es_version.MustParse(v)); found != expected {
+			t.Error(v, found)
+		}
+	}
+	if _, err := utilRevocationParse([]byte(`[]`)); err != ErrorInvalidRevocationList {
+		t.Error(err)
+	}
+}
+
+func TestBinDstLocal_Revocation(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlink is not supported")
+	}
+	qtr_endtoend.TestWithControl(t, func(ctl app_control.Control) {
+		sourcePath := t.TempDir()
+		testLocalDirSource(t, sourcePath, "linux-amd64", "1.0.0", "1.1.0", "1.2.0")
+		if err := os.WriteFile(filepath.Join(sourcePath, RevocationListName), []byte(`{"revoked":[{"version":"1.2.0","reason":"broken"}]}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cellarPath := filepath.Join(t.TempDir(), "cellar")
+		deployPath := filepath.Join(t.TempDir(), "bin")
+		worker := NewBinSrcLocalDirDstLocal(BinSrcLocalDirDstLocalRecipe{
+			SourcePath: sourcePath,
+			BinDstLocalRecipe: BinDstLocalRecipe{
+				BinaryName: "myapp",
+				Prefix:     "myapp",
+				Suffix:     "linux-amd64",
+				CellarPath: cellarPath,
+				DeployPath: deployPath,
+			},
+		}, ctl)
+
+		versions, _, _, err := worker.ListRemoteVersions()
+		if err != nil || len(versions) != 2 || es_version.Max(versions...).String() != "1.1.0" {
+			t.Error(versions, err)
+		}
+
+		// 1.2.0 is installed and linked before the revocation
+		for _, v := range []string{"1.1.0", "1.2.0"} {
+			assetPath := filepath.Join(sourcePath, "myapp-"+v, "myapp-"+v+"-linux-amd64.zip")
+			dlPath, err := worker.Download(es_version.MustParse(v), assetPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := worker.Extract(es_version.MustParse(v), dlPath); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.MkdirAll(deployPath, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(filepath.Join(cellarPath, "myapp-1.2.0", "myapp"), filepath.Join(deployPath, "myapp")); err != nil {
+			t.Fatal(err)
+		}
+
+		// the last known revocation list is used even if the list is removed from the source
+		if err := os.Remove(filepath.Join(sourcePath, RevocationListName)); err != nil {
+			t.Fatal(err)
+		}
+		if p := worker.LocalLatestBinaryPath(); filepath.Base(filepath.Dir(p)) != "myapp-1.1.0" {
+			t.Error(p)
+		}
+		if required, err := worker.IsUpdateRequired(); err != nil || required {
+			t.Error(required, err)
+		}
+		if err := worker.DeploySymlink(); err != nil {
+			t.Error(err)
+		}
+		if content, err := os.ReadFile(filepath.Join(deployPath, "myapp")); err != nil || string(content) != "1.1.0" {
+			t.Error(string(content), err)
+		}
+	})
+}
+
+func TestBinSrcMirror_Revocation(t *testing.T) {
+	qtr_endtoend.TestWithControl(t, func(ctl app_control.Control) {
+		primaryPath := t.TempDir()
+		secondaryPath := filepath.Join(t.TempDir(), "secondary")
+		testLocalDirSource(t, primaryPath, "linux-amd64", "1.0.0", "1.1.0", "1.2.0")
+		testLocalDirSource(t, secondaryPath, "linux-amd64", "1.0.0", "1.1.0", "1.2.0")
+		writeList := func(sourcePath, content string) {
+			if err := os.WriteFile(filepath.Join(sourcePath, RevocationListName), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		writeList(primaryPath, `{"revoked":[{"version":"1.2.0"}]}`)
+		writeList(secondaryPath, `{"revoked":[{"version":"1.1.0"}]}`)
+
+		content, err := json.Marshal(map[string]interface{}{
+			"binary_name": "myapp",
+			"prefix":      "myapp",
+			"suffix":      "linux-amd64",
+			"cellar_path": filepath.Join(t.TempDir(), "cellar"),
+			"sources": []map[string]string{
+				{"source_type": SourceTypeLocalDir, "source_path": primaryPath},
+				{"source_type": SourceTypeLocalDir, "source_path": secondaryPath},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		worker, err := NewBinDeployFromJson(ctl, content, "default")
+		if err != nil {
+			t.Fatal(err)
+		}
+		impl := worker.(*binDstLocalWorkerImpl)
+		expireCache := func() {
+			cachePath := filepath.Join(ctl.Workspace().Cache(), impl.remoteVersionCacheName())
+			cache, err := impl.readRemoteVersionCache()
+			if err != nil {
+				t.Fatal(err)
+			}
+			cache.CacheTime = 0
+			data, err := json.Marshal(cache)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(cachePath, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		latest := func() string {
+			versions, _, _, err := worker.ListRemoteVersions()
+			if err != nil {
+				t.Fatal(err)
+			}
+			return es_version.Max(versions...).String()
+		}
+
+		if v := latest(); v != "1.0.0" {
+			t.Error(v)
+		}
+
+		// the version revoked by the unreachable source is still revoked
+		if err := os.Rename(secondaryPath, secondaryPath+".offline"); err != nil {
+			t.Fatal(err)
+		}
+		expireCache()
+		if v := latest(); v != "1.0.0" {
+			t.Error(v)
+		}
+		if err := os.Rename(secondaryPath+".offline", secondaryPath); err != nil {
+			t.Fatal(err)
+		}
+
+		// the invalid list is not treated as no revocation
+		writeList(secondaryPath, `{"revoked":`)
+		expireCache()
+		if v := latest(); v != "1.0.0" {
+			t.Error(v)
+		}
+		writeList(primaryPath, `{"revoked":`)
+		expireCache()
+		if v := latest(); v != "1.0.0" {
+			t.Error(v)
+		}
+	})
+}
@@ -0,0 +1,174 @@
+package sb_deploy
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/watermint/toolbox/essentials/log/esl"
+	"github.com/watermint/toolbox/essentials/strings/es_version"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// BinDstLocalRollbackPrefix is the prefix of the file in the cellar, that records the rollback version.
+	// The file is per package, like `.sb_deploy.rollback.myapp.json`, since packages may share the cellar.
+	BinDstLocalRollbackPrefix = ".sb_deploy.rollback."
+)
+
+var (
+	ErrorRollbackVersionNotFound = errors.New("rollback version not found in the cellar")
+	ErrorNoPreviousVersion       = errors.New("no previous version in the cellar")
+)
+
+// BinDstLocalRollback is the record of the rollback. The version is used instead of the latest version
+// until the record is cleared.
+type BinDstLocalRollback struct {
+	Version    string `json:"version"`
+	RecordedAt string `json:"recorded_at"`
+}
+
+func (z binDstLocalWorkerImpl) rollbackPath() string {
+	return filepath.Join(z.recipe.CellarPath, BinDstLocalRollbackPrefix+z.recipe.Prefix+".json")
+}
+
+// rollbackVersion returns the recorded rollback version and the path in the cellar.
+// Returns found=false if no rollback is recorded, or the version is no longer in the cellar.
+func (z binDstLocalWorkerImpl) rollbackVersion() (version es_version.Version, versionPath string, found bool) {
+	l := z.ctl.Log()
+	data, err := os.ReadFile(z.rollbackPath())
+	if err != nil {
+		return es_version.Zero(), "", false
+	}
+	record := &BinDstLocalRollback{}
+	if err := json.Unmarshal(data, record); err != nil {
+		l.Warn("Unable to parse the rollback record, ignored", esl.Error(err))
+		return es_version.Zero(), "", false
+	}
+	version, err = es_version.Parse(record.Version)
+	if err != nil {
+		l.Warn("Unable to parse the rollback version, ignored", esl.String("version", record.Version), esl.Error(err))
+		return es_version.Zero(), "", false
+	}
+	_, localVersionPaths, err := z.ListLocalVersions()
+	if err != nil {
+		l.Debug("Unable to list local versions", esl.Error(err))
+		return es_version.Zero(), "", false
+	}
+	versionPath, ok := localVersionPaths[version.String()]
+	if !ok {
+		l.Warn("The rollback version is no longer in the cellar, ignored", esl.String("version", version.String()))
+		return es_version.Zero(), "", false
+	}
+	return version, versionPath, true
+}
+
+// currentVersion returns the version that is linked from the deploy path, or used by the dispatch.
+func (z binDstLocalWorkerImpl) currentVersion(localVersions []es_version.Version) es_version.Version {
+	if linked := z.linkedVersionPath(); linked != "" {
+		if v, err := es_version.Parse(strings.TrimPrefix(filepath.Base(linked), z.recipe.Prefix+"-")); err == nil {
+			return v
+		}
+	}
+	if v, _, found := z.rollbackVersion(); found {
+		return v
+	}
+	return es_version.Max(localVersions...)
+}
+
+func (z binDstLocalWorkerImpl) Rollback(version string) (rolledBack es_version.Version, err error) {
+	rolledBack, err = z.recordRollback(version)
+	if err != nil {
+		return es_version.Zero(), err
+	}
+	if z.recipe.DeployPath != "" {
+		if err := z.DeploySymlink(); err != nil {
+			return rolledBack, err
+		}
+	}
+	return rolledBack, nil
+}
+
+// recordRollback determines the version, then records the rollback in the cellar.
+// The previous version of the current version is used if the version is empty.
+func (z binDstLocalWorkerImpl) recordRollback(version string) (rolledBack es_version.Version, err error) {
+	l := z.ctl.Log()
+	unlock, err := z.lockCellar()
+	if err != nil {
+		l.Warn("Unable to lock the cellar", esl.Error(err))
+		return es_version.Zero(), err
+	}
+	defer unlock()
+
+	localVersions, localVersionPaths, err := z.ListLocalVersions()
+	if err != nil {
+		l.Debug("Unable to list local versions", esl.Error(err))
+		return es_version.Zero(), err
+	}
+
+	if version != "" {
+		rolledBack, err = es_version.Parse(version)
+		if err != nil {
+			l.Warn("Unable to parse the version", esl.String("version", version), esl.Error(err))
+			return es_version.Zero(), err
+		}
+		if _, ok := localVersionPaths[rolledBack.String()]; !ok {
+			l.Warn("The version is not in the cellar", esl.String("version", rolledBack.String()))
+			return es_version.Zero(), ErrorRollbackVersionNotFound
+		}
+	} else {
+		current := z.currentVersion(localVersions)
+		previous := make([]es_version.Version, 0)
+		for _, v := range localVersions {
+			if es_version.Compare(v, current) < 0 {
+				previous = append(previous, v)
+			}
+		}
+		if len(previous) < 1 {
+			l.Warn("No previous version of the current version", esl.String("current", current.String()))
+			return es_version.Zero(), ErrorNoPreviousVersion
+		}
+		rolledBack = es_version.Max(previous...)
+	}
+
+	data, err := json.Marshal(&BinDstLocalRollback{
+		Version:    rolledBack.String(),
+		RecordedAt: time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return es_version.Zero(), err
+	}
+	if err := os.WriteFile(z.rollbackPath(), data, 0644); err != nil {
+		l.Warn("Unable to record the rollback", esl.Error(err))
+		return es_version.Zero(), err
+	}
+	l.Info("Rollback recorded", esl.String("version", rolledBack.String()))
+	return rolledBack, nil
+}
+
+func (z binDstLocalWorkerImpl) ClearRollback() (err error) {
+	l := z.ctl.Log()
+	unlock, err := z.lockCellar()
+	if err != nil {
+		l.Warn("Unable to lock the cellar", esl.Error(err))
+		return err
+	}
+	err = os.Remove(z.rollbackPath())
+	unlock()
+
+	switch {
+	case os.IsNotExist(err):
+		l.Info("No rollback recorded")
+	case err != nil:
+		l.Warn("Unable to clear the rollback", esl.Error(err))
+		return err
+	default:
+		l.Info("Rollback cleared")
+	}
+
+	if z.recipe.DeployPath != "" {
+		return z.DeploySymlink()
+	}
+	return nil
+}
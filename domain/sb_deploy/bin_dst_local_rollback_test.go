@@ -0,0 +1,150 @@
+package sb_deploy
+
+import (
+	"errors"
+	"github.com/watermint/toolbox/essentials/strings/es_version"
+	"github.com/watermint/toolbox/infra/control/app_control"
+	"github.com/watermint/toolbox/quality/recipe/qtr_endtoend"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestBinDstLocal_Rollback(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlink is not supported")
+	}
+	qtr_endtoend.TestWithControl(t, func(ctl app_control.Control) {
+		sourcePath := t.TempDir()
+		testLocalDirSource(t, sourcePath, "linux-amd64", "1.0.0", "1.1.0", "1.2.0")
+		deployPath := filepath.Join(t.TempDir(), "bin")
+		worker := NewBinSrcLocalDirDstLocal(BinSrcLocalDirDstLocalRecipe{
+			SourcePath: sourcePath,
+			BinDstLocalRecipe: BinDstLocalRecipe{
+				BinaryName: "myapp",
+				Prefix:     "myapp",
+				Suffix:     "linux-amd64",
+				CellarPath: filepath.Join(t.TempDir(), "cellar"),
+				DeployPath: deployPath,
+			},
+		}, ctl)
+
+		_, versionPaths, _, err := worker.ListRemoteVersions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range []string{"1.0.0", "1.1.0", "1.2.0"} {
+			dlPath, err := worker.Download(es_version.MustParse(v), versionPaths[v])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := worker.Extract(es_version.MustParse(v), dlPath); err != nil {
+				t.Fatal(err)
+			}
+		}
+		linked := func() string {
+			content, err := os.ReadFile(filepath.Join(deployPath, "myapp"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return string(content)
+		}
+		if err := worker.DeploySymlink(); err != nil || linked() != "1.2.0" {
+			t.Fatal(err)
+		}
+
+		// previous of the linked version
+		if v, err := worker.Rollback(""); err != nil || v.String() != "1.1.0" || linked() != "1.1.0" {
+			t.Error(v, err)
+		}
+		// the choice is kept on later update and link
+		if err := worker.UpdateIfRequired(); err != nil {
+			t.Error(err)
+		}
+		if err := worker.DeploySymlink(); err != nil || linked() != "1.1.0" {
+			t.Error(linked(), err)
+		}
+		if p := worker.LocalLatestBinaryPath(); filepath.Base(filepath.Dir(p)) != "myapp-1.1.0" {
+			t.Error(p)
+		}
+		if v, err := worker.Rollback(""); err != nil || v.String() != "1.0.0" || linked() != "1.0.0" {
+			t.Error(v, err)
+		}
+		if _, err := worker.Rollback(""); !errors.Is(err, ErrorNoPreviousVersion) {
+			t.Error(err)
+		}
+		if _, err := worker.Rollback("9.9.9"); !errors.Is(err, ErrorRollbackVersionNotFound) {
+			t.Error(err)
+		}
+		if v, err := worker.Rollback("1.1.0"); err != nil || v.String() != "1.1.0" || linked() != "1.1.0" {
+			t.Error(v, err)
+		}
+
+		if err := worker.ClearRollback(); err != nil || linked() != "1.2.0" {
+			t.Error(linked(), err)
+		}
+	})
+}
+
+func TestBinDstLocal_RollbackSharedCellar(t *testing.T) {
+	qtr_endtoend.TestWithControl(t, func(ctl app_control.Control) {
+		cellarPath := filepath.Join(t.TempDir(), "cellar")
+		workers := make(map[string]BinDeploy)
+		for _, prefix := range []string{"myapp", "other"} {
+			sourcePath := t.TempDir()
+			testLocalDirSourceOf(t, sourcePath, prefix, "linux-amd64", "1.0.0", "1.1.0")
+			worker := NewBinSrcLocalDirDstLocal(BinSrcLocalDirDstLocalRecipe{
+				SourcePath: sourcePath,
+				BinDstLocalRecipe: BinDstLocalRecipe{
+					BinaryName: prefix,
+					Prefix:     prefix,
+					Suffix:     "linux-amd64",
+					CellarPath: cellarPath,
+				},
+			}, ctl)
+			_, versionPaths, _, err := worker.ListRemoteVersions()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, v := range []string{"1.0.0", "1.1.0"} {
+				dlPath, err := worker.Download(es_version.MustParse(v), versionPaths[v])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := worker.Extract(es_version.MustParse(v), dlPath); err != nil {
+					t.Fatal(err)
+				}
+			}
+			workers[prefix] = worker
+		}
+		latest := func(prefix string) string {
+			return filepath.Base(filepath.Dir(workers[prefix].LocalLatestBinaryPath()))
+		}
+
+		// the rollback of the package does not pin the other package of the same version
+		if v, err := workers["myapp"].Rollback("1.0.0"); err != nil || v.String() != "1.0.0" {
+			t.Error(v, err)
+		}
+		if l := latest("myapp"); l != "myapp-1.0.0" {
+			t.Error(l)
+		}
+		if l := latest("other"); l != "other-1.1.0" {
+			t.Error(l)
+		}
+
+		// clearing the other package keeps the rollback of the package
+		if v, err := workers["other"].Rollback("1.0.0"); err != nil || v.String() != "1.0.0" {
+			t.Error(v, err)
+		}
+		if err := workers["other"].ClearRollback(); err != nil {
+			t.Error(err)
+		}
+		if l := latest("other"); l != "other-1.1.0" {
+			t.Error(l)
+		}
+		if l := latest("myapp"); l != "myapp-1.0.0" {
+			t.Error(l)
+		}
+	})
+}
@@ -0,0 +1,56 @@
+package sb_deploy
+
+import (
+	"github.com/watermint/toolbox/essentials/log/esl"
+	"github.com/watermint/toolbox/essentials/strings/es_version"
+)
+
+// rolloutBucket returns the bucket (0-99) of this machine for the package.
+func (z binDstLocalWorkerImpl) rolloutBucket() (bucket int, err error) {
+	machineId, err := utilRolloutMachineId(z.recipe.CellarPath)
+	if err != nil {
+		z.ctl.Log().Debug("Unable to retrieve the machine ID", esl.Error(err))
+		return 0, err
+	}
+	return utilRolloutBucket(machineId, z.recipe.Prefix), nil
+}
+
+// filterRemote filters remote versions by filter, then excludes versions not rolled out to this machine.
+func (z binDstLocalWorkerImpl) filterRemote(versions []es_version.Version, versionPaths map[string]string, metas map[string]BinRemoteVersionMeta) (filtered []es_version.Version, filteredPaths map[string]string, filteredMetas map[string]BinRemoteVersionMeta, err error) {
+	l := z.ctl.Log()
+	versions, versionPaths, metas, err = z.filter(versions, versionPaths, metas)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	staged := false
+	for _, meta := range metas {
+		if meta.Rollout != nil && *meta.Rollout < 100 {
+			staged = true
+			break
+		}
+	}
+	if !staged {
+		return versions, versionPaths, metas, nil
+	}
+
+	bucket, err := z.rolloutBucket()
+	if err != nil {
+		l.Warn("Unable to determine the rollout cohort, skip staged versions", esl.Error(err))
+		bucket = 100
+	}
+	filtered = make([]es_version.Version, 0, len(versions))
+	filteredPaths = make(map[string]string)
+	filteredMetas = make(map[string]BinRemoteVersionMeta)
+	for _, v := range versions {
+		meta := metas[v.String()]
+		if !utilRolloutIncludes(bucket, meta.Rollout) {
+			l.Debug("Skip version not rolled out to this machine", esl.String("version", v.String()), esl.Int("rollout", *meta.Rollout), esl.Int("bucket", bucket))
+			continue
+		}
+		filtered = append(filtered, v)
+		filteredPaths[v.String()] = versionPaths[v.String()]
+		filteredMetas[v.String()] = meta
+	}
+	return filtered, filteredPaths, filteredMetas, nil
+}
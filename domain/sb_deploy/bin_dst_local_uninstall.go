@@ -0,0 +1,290 @@
+package sb_deploy
+
+import (
+	"errors"
+	"github.com/watermint/toolbox/essentials/log/esl"
+	"github.com/watermint/toolbox/essentials/strings/es_version"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	UninstallKindLink    = "link"
+	UninstallKindVersion = "version"
+	UninstallKindState   = "state"
+	UninstallKindCache   = "cache"
+	UninstallKindCellar  = "cellar"
+	UninstallKindLock    = "lock"
+
+	UninstallStatusRemove  = "remove"
+	UninstallStatusRemoved = "removed"
+	UninstallStatusSkipped = "skipped"
+	UninstallStatusFailed  = "failed"
+)
+
+var (
+	ErrorUninstallFailed = errors.New("unable to remove some files of the package")
+)
+
+// BinDeployUninstallResult is the result of the file of the package.
+type BinDeployUninstallResult struct {
+	Kind   string `json:"kind"`
+	Path   string `json:"path"`
+	Status string `json:"status"`
+	Error  string `json:"error"`
+}
+
+func (z binDstLocalWorkerImpl) uninstallRemove(result BinDeployUninstallResult, dryRun bool) BinDeployUninstallResult {
+	l := z.ctl.Log().With(esl.String("kind", result.Kind), esl.String("path", result.Path))
+	if dryRun {
+		l.Info("Remove (dry run)")
+		result.Status = UninstallStatusRemove
+		return result
+	}
+	if err := os.RemoveAll(result.Path); err != nil {
+		l.Warn("Unable to remove", esl.Error(err))
+		result.Status = UninstallStatusFailed
+		result.Error = err.Error()
+		return result
+	}
+	l.Info("Removed")
+	result.Status = UninstallStatusRemoved
+	return result
+}
+
+// isVersionName returns true if the name is the version folder of the package in the cellar.
+func (z binDstLocalWorkerImpl) isVersionName(name string) bool {
+	verStr, found := strings.CutPrefix(name, z.recipe.Prefix+"-")
+	if !found {
+		return false
+	}
+	_, err := es_version.Parse(verStr)
+	return err == nil
+}
+
+// isPackageTarget returns true if the target is in the version folder of the package.
+// Targets of other packages in the shared cellar are not owned by the package.
+func (z binDstLocalWorkerImpl) isPackageTarget(target string) bool {
+	cellarPath, err := filepath.Abs(z.recipe.CellarPath)
+	if err != nil {
+		return false
+	}
+	if target, err = filepath.Abs(target); err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(cellarPath, target)
+	if err != nil {
+		return false
+	}
+	versionName, _, found := strings.Cut(rel, string(filepath.Separator))
+	return found && z.isVersionName(versionName)
+}
+
+// uninstallLinks removes files in the deploy path deployed by switchbox, such as symlinks to the cellar
+// and recorded files without modification. Other files are reported as skipped.
+func (z binDstLocalWorkerImpl) uninstallLinks(dryRun bool) (results []BinDeployUninstallResult, err error) {
+	l := z.ctl.Log()
+	results = make([]BinDeployUninstallResult, 0)
+	record := z.deployedRecord()
+	paths := make([]string, 0)
+	if z.recipe.DeployPath != "" {
+		binaries, err := z.binaries()
+		if err != nil {
+			return nil, err
+		}
+		for _, bin := range binaries {
+			paths = append(paths, filepath.Join(z.recipe.DeployPath, bin.link))
+		}
+	}
+	// files deployed by previous recipes, such as binaries removed from the recipe.
+	// The record is shared by packages in the cellar, then only links to the package are included.
+	// Records of the package are cleared, including skipped files that are no longer owned by the package.
+	removed := make([]string, 0)
+	for _, link := range record.Links {
+		if z.isPackageTarget(link.Target) {
+			paths = append(paths, link.Path)
+			removed = append(removed, link.Path)
+		}
+	}
+	sort.Strings(paths)
+
+	var lastPath string
+	for _, path := range paths {
+		if path == lastPath {
+			continue
+		}
+		lastPath = path
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			removed = append(removed, path)
+			continue
+		}
+		result := BinDeployUninstallResult{Kind: UninstallKindLink, Path: path}
+		mode, target, found := z.deployedTarget(path, record)
+		if found && mode == DeployModeSymlink && !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		if !found || !z.isPackageTarget(target) {
+			l.Warn("Skip the file not deployed by switchbox", esl.String("path", path))
+			result.Status = UninstallStatusSkipped
+			results = append(results, result)
+			continue
+		}
+		result = z.uninstallRemove(result, dryRun)
+		if result.Status == UninstallStatusRemoved {
+			removed = append(removed, path)
+		}
+		results = append(results, result)
+	}
+	if !dryRun && len(removed) > 0 {
+		if err := z.saveDeployedRecord(nil, removed); err != nil {
+			l.Debug("Unable to update the deployed record", esl.Error(err))
+		}
+	}
+	return results, nil
+}
+
+// uninstallCellar removes versions and states of the package in the cellar.
+// Files shared by packages in the cellar are left to uninstallShared.
+// The caller must hold the lock of the cellar.
+func (z binDstLocalWorkerImpl) uninstallCellar(dryRun bool) (results []BinDeployUninstallResult, err error) {
+	l := z.ctl.Log().With(esl.String("cellarPath", z.recipe.CellarPath))
+	results = make([]BinDeployUninstallResult, 0)
+	entries, err := os.ReadDir(z.recipe.CellarPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return results, nil
+		}
+		l.Debug("Unable to read the cellar", esl.Error(err))
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		path := filepath.Join(z.recipe.CellarPath, name)
+		switch {
+		case entry.IsDir() && (z.isVersionName(name) || strings.HasPrefix(name, BinDstLocalStagingPrefix+z.recipe.Prefix+"-")):
+			results = append(results, z.uninstallRemove(BinDeployUninstallResult{Kind: UninstallKindVersion, Path: path}, dryRun))
+		case !entry.IsDir() && (path == z.rollbackPath() || path == z.firstSeenPath() || path == z.rejectedPath()):
+			results = append(results, z.uninstallRemove(BinDeployUninstallResult{Kind: UninstallKindState, Path: path}, dryRun))
+		}
+	}
+	return results, nil
+}
+
+// uninstallShared removes files shared by packages in the cellar, such as the machine ID and the deployed record,
+// then removes the cellar. Shared files are kept if the cellar still has other files, like versions of other packages.
+func (z binDstLocalWorkerImpl) uninstallShared() (results []BinDeployUninstallResult, err error) {
+	l := z.ctl.Log().With(esl.String("cellarPath", z.recipe.CellarPath))
+	results = make([]BinDeployUninstallResult, 0)
+	unlock, err := z.lockCellar()
+	if err != nil {
+		l.Warn("Unable to lock the cellar", esl.Error(err))
+		return nil, err
+	}
+	entries, err := os.ReadDir(z.recipe.CellarPath)
+	if err != nil {
+		unlock()
+		l.Debug("Unable to read the cellar", esl.Error(err))
+		return nil, err
+	}
+	shared := make([]string, 0)
+	for _, entry := range entries {
+		switch name := entry.Name(); {
+		case name == LockName:
+		case name == RolloutMachineIdName:
+			shared = append(shared, filepath.Join(z.recipe.CellarPath, name))
+		case name == BinDstLocalDeployedName && len(z.deployedRecord().Links) < 1:
+			shared = append(shared, filepath.Join(z.recipe.CellarPath, name))
+		default:
+			unlock()
+			l.Info("The cellar is used by other packages, keep the cellar", esl.String("name", name))
+			return results, nil
+		}
+	}
+	for _, path := range shared {
+		results = append(results, z.uninstallRemove(BinDeployUninstallResult{Kind: UninstallKindState, Path: path}, false))
+	}
+	unlock()
+
+	_ = os.Remove(filepath.Join(z.recipe.CellarPath, LockName))
+	err = os.Remove(z.recipe.CellarPath)
+	switch {
+	case err == nil:
+		l.Info("Cellar removed")
+		results = append(results, BinDeployUninstallResult{Kind: UninstallKindCellar, Path: z.recipe.CellarPath, Status: UninstallStatusRemoved})
+	case errors.Is(err, os.ErrNotExist):
+	default:
+		l.Info("The cellar is not empty, keep the cellar", esl.Error(err))
+	}
+	return results, nil
+}
+
+// uninstallDeployLock removes the lock of the deploy path, if nothing is left other than the lock.
+// The lock is kept while other files are in the deploy path, since the lock is shared by packages
+// deployed into the same path. The deploy path itself is not removed, as it may not be created by switchbox.
+func (z binDstLocalWorkerImpl) uninstallDeployLock() (results []BinDeployUninstallResult) {
+	results = make([]BinDeployUninstallResult, 0)
+	entries, err := os.ReadDir(z.recipe.DeployPath)
+	if err != nil || len(entries) != 1 || entries[0].Name() != LockName {
+		return results
+	}
+	return append(results, z.uninstallRemove(BinDeployUninstallResult{Kind: UninstallKindLock, Path: filepath.Join(z.recipe.DeployPath, LockName)}, false))
+}
+
+func (z binDstLocalWorkerImpl) Uninstall(dryRun bool) (results []BinDeployUninstallResult, err error) {
+	l := z.ctl.Log().With(esl.Bool("dryRun", dryRun))
+
+	// locks are acquired only for existing directories, to not create directories by uninstallation.
+	unlock := func() {}
+	_, deployErr := os.Stat(z.recipe.DeployPath)
+	if z.recipe.DeployPath != "" && deployErr == nil {
+		if unlock, err = z.lockDeploy(); err != nil {
+			l.Warn("Unable to lock the deploy path", esl.Error(err))
+			return nil, err
+		}
+	}
+	results, err = z.uninstallLinks(dryRun)
+	unlock()
+	if err != nil {
+		return results, err
+	}
+	if !dryRun && z.recipe.DeployPath != "" && deployErr == nil {
+		results = append(results, z.uninstallDeployLock()...)
+	}
+
+	_, cellarErr := os.Stat(z.recipe.CellarPath)
+	if cellarErr == nil {
+		unlock, err = z.lockCellar()
+		if err != nil {
+			l.Warn("Unable to lock the cellar", esl.Error(err))
+			return results, err
+		}
+		cellarResults, err := z.uninstallCellar(dryRun)
+		unlock()
+		results = append(results, cellarResults...)
+		if err != nil {
+			return results, err
+		}
+	}
+
+	cachePath := filepath.Join(z.ctl.Workspace().Cache(), z.remoteVersionCacheName())
+	if _, err := os.Lstat(cachePath); err == nil {
+		results = append(results, z.uninstallRemove(BinDeployUninstallResult{Kind: UninstallKindCache, Path: cachePath}, dryRun))
+	}
+
+	if !dryRun && cellarErr == nil {
+		sharedResults, err := z.uninstallShared()
+		results = append(results, sharedResults...)
+		if err != nil {
+			return results, err
+		}
+	}
+
+	for _, r := range results {
+		if r.Status == UninstallStatusFailed {
+			return results, ErrorUninstallFailed
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,190 @@
+package sb_deploy
+
+import (
+	"errors"
+	"github.com/watermint/toolbox/infra/control/app_control"
+	"github.com/watermint/toolbox/quality/recipe/qtr_endtoend"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestBinDstLocal_Uninstall(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlink is not supported")
+	}
+	qtr_endtoend.TestWithControl(t, func(ctl app_control.Control) {
+		sourcePath := t.TempDir()
+		testLocalDirSource(t, sourcePath, "linux-amd64", "1.0.0")
+		deployPath := filepath.Join(t.TempDir(), "bin")
+		cellarPath := filepath.Join(t.TempDir(), "cellar")
+		worker := NewBinSrcLocalDirDstLocal(BinSrcLocalDirDstLocalRecipe{
+			SourcePath: sourcePath,
+			BinDstLocalRecipe: BinDstLocalRecipe{
+				BinaryName: "myapp",
+				Prefix:     "myapp",
+				Suffix:     "linux-amd64",
+				CellarPath: cellarPath,
+				DeployPath: deployPath,
+			},
+		}, ctl)
+		if err := worker.UpdateForce(); err != nil {
+			t.Fatal(err)
+		}
+		if err := worker.DeploySymlink(); err != nil {
+			t.Fatal(err)
+		}
+		linkPath := filepath.Join(deployPath, "myapp")
+		minePath := filepath.Join(deployPath, "mine")
+		if err := os.WriteFile(minePath, []byte("mine"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		countStatus := func(results []BinDeployUninstallResult, kind, status string) (n int) {
+			for _, r := range results {
+				if r.Kind == kind && r.Status == status {
+					n++
+				}
+			}
+			return n
+		}
+
+		// dry run
+		results, err := worker.Uninstall(true)
+		if err != nil {
+			t.Error(err)
+		}
+		if countStatus(results, UninstallKindLink, UninstallStatusRemove) != 1 ||
+			countStatus(results, UninstallKindVersion, UninstallStatusRemove) != 1 {
+			t.Error(results)
+		}
+		if _, err := os.Lstat(linkPath); err != nil {
+			t.Error(err)
+		}
+		if !worker.IsDeployed() {
+			t.Error("removed by dry run")
+		}
+
+		// the folder moved aside on the replacement, and not removed
+		if err := os.Mkdir(filepath.Join(cellarPath, utilLocalAsidePrefix(filepath.Join(cellarPath, "myapp-1.0.0"))+"x"), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		// the link replaced by the user is not removed
+		if err := os.Remove(linkPath); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(minePath, linkPath); err != nil {
+			t.Fatal(err)
+		}
+		results, err = worker.Uninstall(false)
+		if err != nil {
+			t.Error(err)
+		}
+		if countStatus(results, UninstallKindLink, UninstallStatusSkipped) != 1 ||
+			countStatus(results, UninstallKindVersion, UninstallStatusRemoved) != 2 ||
+			countStatus(results, UninstallKindCellar, UninstallStatusRemoved) != 1 {
+			t.Error(results)
+		}
+		if target, err := os.Readlink(linkPath); err != nil || target != minePath {
+			t.Error(target, err)
+		}
+		if c, err := os.ReadFile(minePath); err != nil || string(c) != "mine" {
+			t.Error(string(c), err)
+		}
+		if _, err := os.Stat(cellarPath); !errors.Is(err, os.ErrNotExist) {
+			t.Error(err)
+		}
+		for _, r := range results {
+			if _, err := os.Lstat(r.Path); r.Status == UninstallStatusRemoved && !errors.Is(err, os.ErrNotExist) {
+				t.Error(r, err)
+			}
+		}
+		if countStatus(results, UninstallKindCache, UninstallStatusRemoved) != 1 {
+			t.Error(results)
+		}
+
+		// nothing left, and the cellar is not created
+		results, err = worker.Uninstall(false)
+		if err != nil || countStatus(results, UninstallKindLink, UninstallStatusSkipped) != 1 || len(results) != 1 {
+			t.Error(results, err)
+		}
+		if _, err := os.Stat(cellarPath); !errors.Is(err, os.ErrNotExist) {
+			t.Error(err)
+		}
+	})
+}
+
+func TestBinDstLocal_UninstallSharedCellar(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlink is not supported")
+	}
+	qtr_endtoend.TestWithControl(t, func(ctl app_control.Control) {
+		deployPath := filepath.Join(t.TempDir(), "bin")
+		cellarPath := filepath.Join(t.TempDir(), "cellar")
+		workers := make(map[string]BinDeploy)
+		for prefix, mode := range map[string]string{"myapp": DeployModeSymlink, "other": DeployModeCopy} {
+			sourcePath := t.TempDir()
+			testLocalDirSourceOf(t, sourcePath, prefix, "linux-amd64", "1.0.0", "1.1.0")
+			worker := NewBinSrcLocalDirDstLocal(BinSrcLocalDirDstLocalRecipe{
+				SourcePath: sourcePath,
+				BinDstLocalRecipe: BinDstLocalRecipe{
+					BinaryName: prefix,
+					Prefix:     prefix,
+					Suffix:     "linux-amd64",
+					CellarPath: cellarPath,
+					DeployPath: deployPath,
+					DeployMode: mode,
+				},
+			}, ctl)
+			if err := worker.UpdateForce(); err != nil {
+				t.Fatal(err)
+			}
+			if err := worker.DeploySymlink(); err != nil {
+				t.Fatal(err)
+			}
+			workers[prefix] = worker
+		}
+		machineIdPath := filepath.Join(cellarPath, RolloutMachineIdName)
+		if err := os.WriteFile(machineIdPath, []byte("machine"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		// the other package is not affected
+		if _, err := workers["myapp"].Uninstall(false); err != nil {
+			t.Error(err)
+		}
+		if _, err := os.Lstat(filepath.Join(deployPath, "myapp")); !errors.Is(err, os.ErrNotExist) {
+			t.Error(err)
+		}
+		if !workers["other"].IsDeployed() {
+			t.Error("the other package is not deployed")
+		}
+		for _, path := range []string{machineIdPath, filepath.Join(cellarPath, BinDstLocalDeployedName), filepath.Join(deployPath, LockName)} {
+			if _, err := os.Stat(path); err != nil {
+				t.Error(err)
+			}
+		}
+
+		// the last package in the cellar
+		results, err := workers["other"].Uninstall(false)
+		if err != nil {
+			t.Error(err)
+		}
+		for _, kind := range []string{UninstallKindLink, UninstallKindVersion, UninstallKindCellar, UninstallKindLock} {
+			found := false
+			for _, r := range results {
+				found = found || (r.Kind == kind && r.Status == UninstallStatusRemoved)
+			}
+			if !found {
+				t.Error(kind, results)
+			}
+		}
+		if _, err := os.Stat(cellarPath); !errors.Is(err, os.ErrNotExist) {
+			t.Error(err)
+		}
+		if entries, err := os.ReadDir(deployPath); err != nil || len(entries) != 0 {
+			t.Error(entries, err)
+		}
+	})
+}
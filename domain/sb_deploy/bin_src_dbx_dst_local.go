@@ -1,27 +1,29 @@
 package sb_deploy
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
-	"encoding/json"
+	"errors"
+	"github.com/watermint/toolbox/domain/dropbox/api/dbx_auth"
 	"github.com/watermint/toolbox/domain/dropbox/api/dbx_client"
+	"github.com/watermint/toolbox/domain/dropbox/api/dbx_conn"
+	"github.com/watermint/toolbox/domain/dropbox/api/dbx_conn_impl"
 	"github.com/watermint/toolbox/domain/dropbox/model/mo_file"
 	dbx_path "github.com/watermint/toolbox/domain/dropbox/model/mo_path"
 	"github.com/watermint/toolbox/domain/dropbox/model/mo_url"
+	"github.com/watermint/toolbox/domain/dropbox/service/sv_file"
+	"github.com/watermint/toolbox/domain/dropbox/service/sv_file_content"
 	"github.com/watermint/toolbox/domain/dropbox/service/sv_sharedlink_file"
+	"github.com/watermint/toolbox/essentials/api/api_conn"
 	"github.com/watermint/toolbox/essentials/log/esl"
 	"github.com/watermint/toolbox/essentials/model/mo_path"
 	"github.com/watermint/toolbox/essentials/strings/es_version"
 	"github.com/watermint/toolbox/infra/control/app_control"
+	"github.com/watermint/toolbox/ingredient/ig_dropbox/ig_teamspace"
 	"os"
-	"path/filepath"
-	"strings"
-	"time"
+	"path"
 )
 
-const (
-	BinSrcDropboxDstLocalVersionCacheLifecycle = 86400
-	BinSrcDropboxDstLocalVersionCacheName      = "sb_deploy-bin_src_dbx_dst_local_version_cache"
+var (
+	ErrorDropboxSourceNotSpecified = errors.New("either source_url or source_path is required")
 )
 
 // BinSrcDropboxDstLocalRecipe Deploy binary from Dropbox to local
@@ -29,259 +31,239 @@ const (
 // `PREFIX-VERSION/PREFIX-VERSION-SUFFIX.zip`.
 // For example, if prefix is `myapp` and suffix is `linux-amd64`, the folder structure is:
 // `myapp-1.0.0/myapp-1.0.0-linux-amd64.zip`.
+// The folder is the shared link folder (SourceUrl), or the folder in the account (SourcePath).
 type BinSrcDropboxDstLocalRecipe struct {
 	// SourceUrl is the url to the shared link folder
-	SourceUrl string `json:"source_url"`
+	SourceUrl string `json:"source_url,omitempty"`
 
 	// SourcePassword is the password to access the source. If empty, no password is used.
 	SourcePassword string `json:"source_password,omitempty"`
 
-	// BinaryName is the name of the binary file
-	BinaryName string `json:"binary_name"`
-
-	// Prefix is the prefix of the file/folder basename.
-	Prefix string `json:"prefix"`
+	// SourcePath is the path to the folder in the account, e.g. `/Deploy/myapp`.
+	// SourcePath is used when SourceUrl is empty.
+	SourcePath string `json:"source_path,omitempty"`
 
-	// Suffix is the suffix of the file basename.
-	Suffix string `json:"suffix"`
+	// TeamSpace is true to resolve SourcePath from the root of the team space, instead of the member folder.
+	TeamSpace bool `json:"team_space,omitempty"`
 
-	// CellarPath is the path to store extracted binaries of versions
-	CellarPath string `json:"cellar_path"`
-
-	// DeployPath is the path to deploy symlink to the binary.
-	// This field is options when no symlink deployment is required.
-	DeployPath string `json:"deploy_path,omitempty"`
+	BinDstLocalRecipe
 }
 
-type BinSrcDropboxDstLocalRemoteVersionCache struct {
-	// CacheTime is the time when the cache is created in Unix time
-	CacheTime int64 `json:"cache_time,omitempty"`
-
-	// Versions is the list of versions
-	Versions []es_version.Version `json:"versions,omitempty"`
+var (
+	// BinSrcDropboxScopes is the scopes required to access the source.
+	BinSrcDropboxScopes = []string{
+		dbx_auth.ScopeAccountInfoRead,
+		dbx_auth.ScopeFilesContentRead,
+		dbx_auth.ScopeFilesMetadataRead,
+		dbx_auth.ScopeSharingRead,
+	}
+)
 
-	// Versions is the list of versions, version string as key and path as value
-	VersionPaths map[string]string `json:"version_paths,omitempty"`
+// NewBinSrcDropboxClient connects to Dropbox with the peer name.
+// The connection is established only when the source is Dropbox, then the machine that
+// uses other sources never asks the authorization.
+func NewBinSrcDropboxClient(ctl app_control.Control, peerName string) (client dbx_client.Client, err error) {
+	conn := dbx_conn_impl.NewConnScopedIndividual(peerName)
+	conn.SetScopes(BinSrcDropboxScopes...)
+	if err := conn.Connect(ctl); err != nil {
+		ctl.Log().Debug("Unable to connect", esl.Error(err))
+		return nil, err
+	}
+	return conn.Client(), nil
 }
 
-func NewBinSrcDropboxDstLocal(recipe BinSrcDropboxDstLocalRecipe, ctl app_control.Control, client dbx_client.Client) BinDeploy {
-	return &binSrcDropboxDstLocalWorkerImpl{
-		recipe: recipe,
-		ctl:    ctl,
-		client: client,
+// NewBinSrcDropboxConn returns the connection for the `-peer` option of recipes. The connection is not established
+// on the start of the recipe, but by NewBinSrcDropboxClient with the peer name when the recipe uses the Dropbox source.
+// Client of the connection is not available.
+func NewBinSrcDropboxConn() dbx_conn.ConnScopedIndividual {
+	conn := dbx_conn_impl.NewConnScopedIndividual(api_conn.DefaultPeerName)
+	conn.SetScopes(BinSrcDropboxScopes...)
+	return &binSrcDropboxConnImpl{
+		ConnScopedIndividual: conn,
 	}
 }
 
-type binSrcDropboxDstLocalWorkerImpl struct {
-	recipe BinSrcDropboxDstLocalRecipe
-	ctl    app_control.Control
-	client dbx_client.Client
+type binSrcDropboxConnImpl struct {
+	dbx_conn.ConnScopedIndividual
+}
+
+// Connect defers the connection until the Dropbox source is used.
+func (z *binSrcDropboxConnImpl) Connect(ctl app_control.Control) (err error) {
+	return nil
 }
 
-func (z binSrcDropboxDstLocalWorkerImpl) IsUpdateRequired() (required bool, err error) {
-	l := z.ctl.Log()
-	localVersions, _, err := z.ListLocalVersions()
+// NewBinSrcDropboxTeamSpaceClient returns the client that resolves paths from the root of the team space.
+func NewBinSrcDropboxTeamSpaceClient(ctl app_control.Control, client dbx_client.Client) (dbx_client.Client, error) {
+	rootNamespaceId, err := ig_teamspace.FindRootNamespaceIdAsMember(client)
 	if err != nil {
-		l.Debug("Unable to list local versions", esl.Error(err))
-		return false, err
+		ctl.Log().Debug("Unable to find the root namespace", esl.Error(err))
+		return nil, err
 	}
-	remoteVersions, _, err := z.ListRemoteVersions()
-	if err != nil {
-		l.Debug("Unable to list remote versions", esl.Error(err))
-		return false, err
+	if rootNamespaceId == "" {
+		return nil, ig_teamspace.ErrorRootNamespaceNotFound
 	}
+	return client.WithPath(dbx_client.Root(rootNamespaceId)), nil
+}
 
-	l.Debug("Local versions", esl.Any("versions", localVersions))
-	l.Debug("Remote versions", esl.Any("versions", remoteVersions))
-
-	remoteVersionLatest := es_version.Max(remoteVersions...)
-	localVersionLatest := es_version.Max(localVersions...)
-
-	l.Debug("Local latest version", esl.String("version", localVersionLatest.String()))
-	l.Debug("Remote latest version", esl.String("version", remoteVersionLatest.String()))
+const (
+	SourceTypeDropbox = "dropbox"
+)
 
-	required = !localVersionLatest.Equals(remoteVersionLatest)
+func init() {
+	RegisterBinSource(BinSourceRegistration{
+		SourceType: SourceTypeDropbox,
+		Recipe: func() interface{} {
+			return &BinSrcDropboxDstLocalRecipe{}
+		},
+		Auth: func(ctl app_control.Control, recipe interface{}, peerName string) (auth interface{}, err error) {
+			r := recipe.(*BinSrcDropboxDstLocalRecipe)
+			if r.SourceUrl == "" && r.SourcePath == "" {
+				return nil, ErrorDropboxSourceNotSpecified
+			}
+			client, err := NewBinSrcDropboxClient(ctl, peerName)
+			if err != nil {
+				return nil, err
+			}
+			if r.SourceUrl == "" && r.TeamSpace {
+				return NewBinSrcDropboxTeamSpaceClient(ctl, client)
+			}
+			return client, nil
+		},
+		New: func(ctl app_control.Control, recipe interface{}, auth interface{}) (BinSource, error) {
+			return NewBinSrcDropboxSource(*recipe.(*BinSrcDropboxDstLocalRecipe), ctl, auth.(dbx_client.Client)), nil
+		},
+	})
+}
 
-	l.Debug("Update required", esl.Bool("required", required))
+func NewBinSrcDropboxDstLocal(recipe BinSrcDropboxDstLocalRecipe, ctl app_control.Control, client dbx_client.Client) BinDeploy {
+	return newBinDstLocal(recipe.BinDstLocalRecipe, ctl, NewBinSrcDropboxSource(recipe, ctl, client))
+}
 
-	return required, nil
+func NewBinSrcDropboxSource(recipe BinSrcDropboxDstLocalRecipe, ctl app_control.Control, client dbx_client.Client) BinSource {
+	var folder binSrcDropboxFolder
+	if recipe.SourceUrl != "" {
+		folder = &binSrcDropboxSharedLinkFolder{
+			recipe: recipe,
+			ctl:    ctl,
+			client: client,
+		}
+	} else {
+		folder = &binSrcDropboxAccountFolder{
+			recipe: recipe,
+			client: client,
+		}
+	}
+	return &binSrcDropboxImpl{
+		recipe: recipe,
+		ctl:    ctl,
+		folder: folder,
+	}
 }
 
-func (z binSrcDropboxDstLocalWorkerImpl) LocalLatestBinaryPath() string {
-	return utilLocalLatestBinaryPath(z.ctl, z.recipe.CellarPath, z.recipe.Prefix, z.recipe.BinaryName)
+// binSrcDropboxFolder is the access to the folder of versions.
+// Paths are relative to the folder, like `/PREFIX-VERSION/PREFIX-VERSION-SUFFIX.zip`.
+type binSrcDropboxFolder interface {
+	Id() string
+	List(path dbx_path.DropboxPath, onEntry func(entry mo_file.Entry)) error
+	Download(path dbx_path.DropboxPath) (localPath string, err error)
 }
 
-func (z binSrcDropboxDstLocalWorkerImpl) BinaryName() string {
-	return utilBinaryName(z.recipe.BinaryName)
+type binSrcDropboxSharedLinkFolder struct {
+	recipe BinSrcDropboxDstLocalRecipe
+	ctl    app_control.Control
+	client dbx_client.Client
 }
 
-func (z binSrcDropboxDstLocalWorkerImpl) update(force bool) (err error) {
-	l := z.ctl.Log()
+func (z binSrcDropboxSharedLinkFolder) Id() string {
+	return z.recipe.SourceUrl
+}
 
-	updateRequired, err := z.IsUpdateRequired()
+func (z binSrcDropboxSharedLinkFolder) List(path dbx_path.DropboxPath, onEntry func(entry mo_file.Entry)) error {
+	url, err := mo_url.NewUrl(z.recipe.SourceUrl)
 	if err != nil {
-		l.Warn("Unable to check update required", esl.Error(err))
-		return err
-	}
-	if !force && !updateRequired {
-		l.Info("No update required")
-		return nil
-	}
-
-	if err := os.MkdirAll(z.recipe.CellarPath, 0755); err != nil {
-		l.Warn("Unable to create cellar directory", esl.Error(err))
 		return err
 	}
+	return sv_sharedlink_file.New(z.client).List(url, path, onEntry)
+}
 
-	localVersions, localVersionPaths, err := z.ListLocalVersions()
-	if err != nil {
-		l.Warn("Unable to list local versions", esl.Error(err))
-		return err
-	}
-	remoteVersions, remoteVersionPaths, err := z.ListRemoteVersions()
+func (z binSrcDropboxSharedLinkFolder) Download(path dbx_path.DropboxPath) (localPath string, err error) {
+	url, err := mo_url.NewUrl(z.recipe.SourceUrl)
 	if err != nil {
-		l.Warn("Unable to list remote versions", esl.Error(err))
-		return err
+		return "", err
 	}
-
-	localVersionLatest := es_version.Max(localVersions...)
-	remoteVersionLatest := es_version.Max(remoteVersions...)
-
-	l.Info("Local latest version", esl.String("version", localVersionLatest.String()), esl.String("path", localVersionPaths[localVersionLatest.String()]))
-	l.Info("Remote latest version", esl.String("version", remoteVersionLatest.String()), esl.String("path", remoteVersionPaths[remoteVersionLatest.String()]))
-
-	dlPath, err := z.Download(remoteVersionLatest, remoteVersionPaths[remoteVersionLatest.String()])
+	// the deploy path is managed by switchbox, then the archive is downloaded into the workspace as other sources.
+	downloadFolder, err := z.ctl.Workspace().Descendant("download")
 	if err != nil {
-		l.Warn("Unable to download", esl.Error(err))
-		return err
+		return "", err
 	}
-	cellarPath, err := z.Extract(remoteVersionLatest, dlPath)
+	_, downloadPath, err := sv_sharedlink_file.New(z.client).Download(
+		url,
+		path,
+		mo_path.NewFileSystemPath(downloadFolder),
+		sv_sharedlink_file.Password(z.recipe.SourcePassword))
 	if err != nil {
-		l.Warn("Unable to extract", esl.Error(err))
-		return err
+		return "", err
 	}
-	l.Info("Extracted", esl.String("path", cellarPath))
-
-	return nil
+	return downloadPath.Path(), nil
 }
 
-func (z binSrcDropboxDstLocalWorkerImpl) UpdateForce() (err error) {
-	return z.update(true)
+type binSrcDropboxAccountFolder struct {
+	recipe BinSrcDropboxDstLocalRecipe
+	client dbx_client.Client
 }
 
-func (z binSrcDropboxDstLocalWorkerImpl) UpdateIfRequired() (err error) {
-	return z.update(false)
+func (z binSrcDropboxAccountFolder) Id() string {
+	if z.recipe.TeamSpace {
+		return "teamspace:" + z.recipe.SourcePath
+	}
+	return z.recipe.SourcePath
 }
 
-func (z binSrcDropboxDstLocalWorkerImpl) GetLocalLatest() (binaryPath string, version es_version.Version, err error) {
-	l := z.ctl.Log()
-	localVersions, localVersionPaths, err := z.ListLocalVersions()
-	if err != nil {
-		l.Debug("Unable to list local versions", esl.Error(err))
-		return "", es_version.Zero(), err
-	}
-	localVersionLatest := es_version.Max(localVersions...)
-	if len(localVersions) < 1 || localVersionLatest.Equals(es_version.Zero()) {
-		if err := z.UpdateIfRequired(); err != nil {
-			l.Debug("Unable to update", esl.Error(err))
-			return "", es_version.Zero(), err
-		}
-	}
-	return localVersionPaths[localVersionLatest.String()], localVersionLatest, nil
+func (z binSrcDropboxAccountFolder) accountPath(path dbx_path.DropboxPath) dbx_path.DropboxPath {
+	return dbx_path.NewDropboxPath(z.recipe.SourcePath).ChildPath(path.Path())
 }
 
-func (z binSrcDropboxDstLocalWorkerImpl) remoteVersionCacheName() string {
-	seeds := make([]string, 0)
-	seeds = append(seeds, z.recipe.SourceUrl)
-	seeds = append(seeds, z.recipe.Prefix)
-	seeds = append(seeds, z.recipe.Suffix)
-	seeds = append(seeds, z.recipe.BinaryName)
-	seed := sha256.Sum256([]byte(strings.Join(seeds, "-")))
-	return BinSrcDropboxDstLocalVersionCacheName + hex.EncodeToString(seed[:])[0:16] + ".json"
+func (z binSrcDropboxAccountFolder) List(path dbx_path.DropboxPath, onEntry func(entry mo_file.Entry)) error {
+	return sv_file.NewFiles(z.client).ListEach(z.accountPath(path), onEntry)
 }
 
-func (z binSrcDropboxDstLocalWorkerImpl) loadRemoteVersionsCache() (versions []es_version.Version, versionPaths map[string]string, found bool) {
-	l := z.ctl.Log()
-	cachePath := filepath.Join(z.ctl.Workspace().Cache(), z.remoteVersionCacheName())
-	cacheData, err := os.ReadFile(cachePath)
+func (z binSrcDropboxAccountFolder) Download(path dbx_path.DropboxPath) (localPath string, err error) {
+	_, downloadPath, err := sv_file_content.NewDownload(z.client).Download(z.accountPath(path))
 	if err != nil {
-		l.Debug("Unable to read cache", esl.Error(err))
-		return versions, versionPaths, false
-	}
-	l.Debug("Cache found")
-	cache := &BinSrcDropboxDstLocalRemoteVersionCache{}
-	if err = json.Unmarshal(cacheData, cache); err != nil {
-		l.Debug("Unable to unmarshal cache", esl.Error(err))
-		return versions, versionPaths, false
-	}
-	if cache.CacheTime+BinSrcDropboxDstLocalVersionCacheLifecycle < time.Now().Unix() {
-		return versions, versionPaths, false
+		return "", err
 	}
-	return cache.Versions, cache.VersionPaths, true
+	return downloadPath.Path(), nil
 }
 
-func (z binSrcDropboxDstLocalWorkerImpl) saveRemoteVersionCache(versions []es_version.Version, versionPaths map[string]string) (err error) {
-	l := z.ctl.Log()
-	if err := os.MkdirAll(z.ctl.Workspace().Cache(), 0755); err != nil {
-		l.Debug("Unable to create cache directory", esl.Error(err))
-		return err
-	}
-
-	cachePath := filepath.Join(z.ctl.Workspace().Cache(), z.remoteVersionCacheName())
-	cache := &BinSrcDropboxDstLocalRemoteVersionCache{
-		CacheTime:    time.Now().Unix(),
-		Versions:     versions,
-		VersionPaths: versionPaths,
-	}
-	cacheData, err := json.Marshal(cache)
-	if err != nil {
-		l.Debug("Unable to marshal cache", esl.Error(err))
-		return err
-	}
-	if err := os.WriteFile(cachePath, cacheData, 0644); err != nil {
-		l.Debug("Unable to write cache", esl.Error(err))
-		return err
-	}
-	return nil
+type binSrcDropboxImpl struct {
+	recipe BinSrcDropboxDstLocalRecipe
+	ctl    app_control.Control
+	folder binSrcDropboxFolder
 }
 
-func (z binSrcDropboxDstLocalWorkerImpl) ListLocalVersions() (versions []es_version.Version, versionPaths map[string]string, err error) {
-	return utilLocalListLocalVersions(z.ctl, z.recipe.CellarPath, z.recipe.Prefix)
+func (z binSrcDropboxImpl) Id() string {
+	return z.folder.Id()
 }
 
-func (z binSrcDropboxDstLocalWorkerImpl) ListRemoteVersions() (versions []es_version.Version, versionPaths map[string]string, err error) {
-	l := z.ctl.Log().With(esl.String("sourceUrl", z.recipe.SourceUrl))
+func (z binSrcDropboxImpl) ListRemoteVersions() (versions []es_version.Version, versionPaths map[string]string, metas map[string]BinRemoteVersionMeta, err error) {
+	l := z.ctl.Log().With(esl.String("source", z.folder.Id()))
 	versions = make([]es_version.Version, 0)
 	versionPaths = make(map[string]string)
+	metas = make(map[string]BinRemoteVersionMeta)
 
-	if versions, versionPaths, found := z.loadRemoteVersionsCache(); found {
-		l.Debug("Remote version cache found")
-		return versions, versionPaths, nil
-	}
-
-	url, err := mo_url.NewUrl(z.recipe.SourceUrl)
-	if err != nil {
-		l.Debug("Unable to parse url", esl.Error(err))
-		return versions, versionPaths, err
-	}
-	fullPrefix := z.recipe.Prefix + "-"
-	fullFileSuffix := "-" + z.recipe.Suffix + ".zip"
-
-	svs := sv_sharedlink_file.New(z.client)
-	err = svs.List(url, dbx_path.NewDropboxPath(""), func(folderEntry mo_file.Entry) {
+	err = z.folder.List(dbx_path.NewDropboxPath(""), func(folderEntry mo_file.Entry) {
 		if folder, ok := folderEntry.Folder(); !ok {
 			l.Debug("Skip entry", esl.String("name", folderEntry.Name()))
 		} else {
 			folderPath := dbx_path.NewDropboxPath("").ChildPath(folder.Name())
-			err = svs.List(url, folderPath, func(fileEntry mo_file.Entry) {
-				if !strings.HasSuffix(fileEntry.Name(), fullFileSuffix) || !strings.HasPrefix(fileEntry.Name(), fullPrefix) {
-					l.Debug("Skip entry", esl.String("name", fileEntry.Name()))
+			err := z.folder.List(folderPath, func(fileEntry mo_file.Entry) {
+				if utilSourceParseMarker(z.recipe.Prefix, folder.Name(), fileEntry.Name(), metas) {
+					l.Debug("Found marker", esl.String("name", fileEntry.Name()))
 					return
 				}
-				verStr := strings.TrimPrefix(folder.Name(), fullPrefix)
-				ver, err := es_version.Parse(verStr)
-				if err != nil {
-					l.Debug("Unable to parse version", esl.Error(err))
+				ver, ok := utilSourceParseVersion(z.recipe.Prefix, z.recipe.Suffix, folder.Name(), fileEntry.Name())
+				if _, dup := versionPaths[ver.String()]; !ok || dup {
+					l.Debug("Skip entry", esl.String("name", fileEntry.Name()))
 					return
 				}
 				if file, ok := fileEntry.File(); ok {
@@ -290,88 +272,105 @@ func (z binSrcDropboxDstLocalWorkerImpl) ListRemoteVersions() (versions []es_ver
 					versionPaths[ver.String()] = folderPath.ChildPath(file.Name()).Path()
 				}
 			})
+			if err != nil {
+				l.Debug("Unable to list the version folder", esl.String("name", folder.Name()), esl.Error(err))
+			}
 		}
 	})
 	if err != nil {
 		l.Debug("Unable to list remote versions", esl.Error(err))
-		return versions, versionPaths, err
-	}
-
-	if err := z.saveRemoteVersionCache(versions, versionPaths); err != nil {
-		l.Debug("Unable to save remote version cache", esl.Error(err))
+		return versions, versionPaths, metas, err
 	}
 
-	return versions, versionPaths, nil
+	return versions, versionPaths, metas, nil
 }
 
-func (z binSrcDropboxDstLocalWorkerImpl) Download(version es_version.Version, versionPath string) (downloadPath string, err error) {
+func (z binSrcDropboxImpl) Download(version es_version.Version, versionPath string) (downloadPath string, err error) {
 	l := z.ctl.Log().With(esl.String("version", version.String()), esl.String("versionPath", versionPath))
 	l.Debug("Download version")
 
-	svs := sv_sharedlink_file.New(z.client)
-	url, err := mo_url.NewUrl(z.recipe.SourceUrl)
-	if err != nil {
-		l.Debug("Unable to parse url", esl.Error(err))
-		return "", err
-	}
-	entry, path, err := svs.Download(
-		url,
-		dbx_path.NewDropboxPath(versionPath),
-		mo_path.NewFileSystemPath(z.recipe.DeployPath),
-		sv_sharedlink_file.Password(z.recipe.SourcePassword))
+	downloadPath, err = z.folder.Download(dbx_path.NewDropboxPath(versionPath))
 	if err != nil {
 		l.Debug("Unable to download version", esl.Error(err))
 		return "", err
 	}
-	l.Info("Downloaded", esl.String("path", path.Path()), esl.String("entry", entry.Path().Path()))
+	l.Info("Downloaded", esl.String("path", downloadPath))
 
-	return path.Path(), nil
+	return downloadPath, nil
 }
 
-func (z binSrcDropboxDstLocalWorkerImpl) Extract(version es_version.Version, downloadPath string) (cellarPath string, err error) {
-	return utilLocalExtract(z.ctl, z.recipe.CellarPath, z.recipe.Prefix, z.recipe.BinaryName, version, downloadPath)
+// sidecars lists the version folder, then returns the function that retrieves the file in the folder.
+func (z binSrcDropboxImpl) sidecars(versionPath string) (fetch func(name string) (content []byte, found bool, err error), err error) {
+	l := z.ctl.Log().With(esl.String("versionPath", versionPath))
+	folderPath := dbx_path.NewDropboxPath(versionPath).Parent()
+	names := make(map[string]bool)
+	if err := z.folder.List(folderPath, func(entry mo_file.Entry) {
+		names[entry.Name()] = true
+	}); err != nil {
+		l.Debug("Unable to list the version folder", esl.Error(err))
+		return nil, err
+	}
+	return func(name string) (content []byte, found bool, err error) {
+		if !names[name] {
+			return nil, false, nil
+		}
+		localPath, err := z.folder.Download(folderPath.ChildPath(name))
+		if err != nil {
+			l.Debug("Unable to download the sidecar", esl.String("name", name), esl.Error(err))
+			return nil, false, err
+		}
+		defer func() {
+			_ = os.Remove(localPath)
+		}()
+		content, err = os.ReadFile(localPath)
+		if err != nil {
+			return nil, false, err
+		}
+		return content, true, nil
+	}, nil
 }
 
-func (z binSrcDropboxDstLocalWorkerImpl) DeploySymlink() (err error) {
-	l := z.ctl.Log()
-	l.Info("Deploying symlink")
-
-	versionPath, version, err := z.GetLocalLatest()
+func (z binSrcDropboxImpl) ExpectedSha256(version es_version.Version, versionPath string) (digest string, found bool, err error) {
+	fetch, err := z.sidecars(versionPath)
 	if err != nil {
-		l.Warn("Unable to get local latest", esl.Error(err))
-		return err
+		return "", false, err
 	}
+	return utilChecksumFromSidecars(path.Base(versionPath), fetch)
+}
 
-	binName := z.BinaryName()
-	binCellarPath := filepath.Join(versionPath, binName)
-	binDeployPath := filepath.Join(z.recipe.DeployPath, binName)
-
-	l.Info("Deploying",
-		esl.String("version", version.String()),
-		esl.String("binCellarPath", binCellarPath),
-		esl.String("binDeployPath", binDeployPath))
+func (z binSrcDropboxImpl) AssetSidecar(version es_version.Version, versionPath, extension string) (content []byte, found bool, err error) {
+	fetch, err := z.sidecars(versionPath)
+	if err != nil {
+		return nil, false, err
+	}
+	return fetch(path.Base(versionPath) + extension)
+}
 
-	_, err = os.Lstat(binDeployPath)
-	if err != nil && !os.IsNotExist(err) {
-		l.Warn("Unable to stat existing symlink", esl.Error(err))
-		return err
-	} else if err == nil || os.IsExist(err) {
-		l.Info("Existing symlink found, try removing existing link", esl.String("binDeployPath", binDeployPath))
-		if err := os.Remove(binDeployPath); err != nil {
-			l.Warn("Unable to remove existing symlink", esl.Error(err))
-			return err
+func (z binSrcDropboxImpl) RevocationList() (content []byte, found bool, err error) {
+	l := z.ctl.Log().With(esl.String("source", z.folder.Id()))
+	rootPath := dbx_path.NewDropboxPath("")
+	if err := z.folder.List(rootPath, func(entry mo_file.Entry) {
+		if _, ok := entry.File(); ok && entry.Name() == RevocationListName {
+			found = true
 		}
-		l.Info("Existing symlink removed", esl.String("binDeployPath", binDeployPath))
+	}); err != nil {
+		l.Debug("Unable to list the root folder", esl.Error(err))
+		return nil, false, err
 	}
-	if err := os.MkdirAll(z.recipe.DeployPath, 0755); err != nil {
-		l.Warn("Unable to create deploy directory", esl.Error(err))
-		return err
+	if !found {
+		return nil, false, nil
 	}
-
-	if err := os.Symlink(binCellarPath, binDeployPath); err != nil {
-		l.Warn("Unable to create symlink", esl.Error(err))
-		return err
+	localPath, err := z.folder.Download(rootPath.ChildPath(RevocationListName))
+	if err != nil {
+		l.Debug("Unable to download the revocation list", esl.Error(err))
+		return nil, false, err
 	}
-	l.Info("Deployed", esl.String("binDeployPath", binDeployPath))
-	return nil
+	defer func() {
+		_ = os.Remove(localPath)
+	}()
+	content, err = os.ReadFile(localPath)
+	if err != nil {
+		return nil, false, err
+	}
+	return content, true, nil
 }
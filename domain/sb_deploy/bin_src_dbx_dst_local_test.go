@@ -0,0 +1,110 @@
+package sb_deploy
+
+import (
+	"encoding/json"
+	"github.com/watermint/toolbox/domain/dropbox/model/mo_file"
+	dbx_path "github.com/watermint/toolbox/domain/dropbox/model/mo_path"
+	"github.com/watermint/toolbox/essentials/io/es_file_copy"
+	"github.com/watermint/toolbox/essentials/strings/es_version"
+	"github.com/watermint/toolbox/infra/control/app_control"
+	"github.com/watermint/toolbox/quality/recipe/qtr_endtoend"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testDropboxFolder is the fake Dropbox folder backed by the local directory.
+type testDropboxFolder struct {
+	t          *testing.T
+	sourcePath string
+}
+
+func (z testDropboxFolder) Id() string {
+	return z.sourcePath
+}
+
+func (z testDropboxFolder) List(path dbx_path.DropboxPath, onEntry func(entry mo_file.Entry)) error {
+	entries, err := os.ReadDir(filepath.Join(z.sourcePath, path.Path()))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		tag := "file"
+		if entry.IsDir() {
+			tag = "folder"
+		}
+		raw, err := json.Marshal(map[string]string{
+			".tag":         tag,
+			"name":         entry.Name(),
+			"path_display": path.ChildPath(entry.Name()).Path(),
+		})
+		if err != nil {
+			return err
+		}
+		onEntry(&mo_file.Metadata{
+			Raw:              raw,
+			EntryTag:         tag,
+			EntryName:        entry.Name(),
+			EntryPathDisplay: path.ChildPath(entry.Name()).Path(),
+		})
+	}
+	return nil
+}
+
+func (z testDropboxFolder) Download(path dbx_path.DropboxPath) (localPath string, err error) {
+	localPath = filepath.Join(z.t.TempDir(), filepath.Base(path.Path()))
+	return localPath, es_file_copy.Copy(filepath.Join(z.sourcePath, path.Path()), localPath)
+}
+
+func TestBinSrcDropboxDstLocal(t *testing.T) {
+	qtr_endtoend.TestWithControl(t, func(ctl app_control.Control) {
+		sourcePath := t.TempDir()
+		testLocalDirSource(t, sourcePath, "linux-amd64", "1.0.0", "1.1.0")
+		testLocalDirSource(t, sourcePath, "darwin-arm64", "1.2.0")
+
+		recipe := BinSrcDropboxDstLocalRecipe{
+			SourcePath: "/Deploy/myapp",
+			BinDstLocalRecipe: BinDstLocalRecipe{
+				BinaryName: "myapp",
+				Prefix:     "myapp",
+				Suffix:     "linux-amd64",
+				CellarPath: filepath.Join(t.TempDir(), "cellar"),
+			},
+		}
+		worker := newBinDstLocal(recipe.BinDstLocalRecipe, ctl, &binSrcDropboxImpl{
+			recipe: recipe,
+			ctl:    ctl,
+			folder: testDropboxFolder{t: t, sourcePath: sourcePath},
+		})
+
+		versions, versionPaths, _, err := worker.ListRemoteVersions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if latest := es_version.Max(versions...); len(versions) != 2 || latest.String() != "1.1.0" {
+			t.Error(versions)
+		}
+		if p := versionPaths["1.1.0"]; p != "/myapp-1.1.0/myapp-1.1.0-linux-amd64.zip" {
+			t.Error(p)
+		}
+
+		if err := worker.UpdateIfRequired(); err != nil {
+			t.Fatal(err)
+		}
+		if content, err := os.ReadFile(worker.LocalLatestBinaryPath()); err != nil || string(content) != "1.1.0" {
+			t.Error(string(content), err)
+		}
+	})
+}
+
+func TestBinSrcDropboxAccountFolder_AccountPath(t *testing.T) {
+	folder := binSrcDropboxAccountFolder{
+		recipe: BinSrcDropboxDstLocalRecipe{SourcePath: "/Deploy/myapp"},
+	}
+	if p := folder.accountPath(dbx_path.NewDropboxPath("")).Path(); p != "/Deploy/myapp" {
+		t.Error(p)
+	}
+	if p := folder.accountPath(dbx_path.NewDropboxPath("/myapp-1.0.0/myapp-1.0.0-linux-amd64.zip")).Path(); p != "/Deploy/myapp/myapp-1.0.0/myapp-1.0.0-linux-amd64.zip" {
+		t.Error(p)
+	}
+}
@@ -0,0 +1,240 @@
+package sb_deploy
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/watermint/toolbox/essentials/log/esl"
+	"github.com/watermint/toolbox/essentials/strings/es_version"
+	"github.com/watermint/toolbox/infra/control/app_control"
+	"net/url"
+	"path"
+	"strings"
+)
+
+const (
+	SourceTypeGithubRelease          = "github_release"
+	BinSrcGithubReleaseDefaultApiUrl = "https://api.github.com"
+	binSrcGithubReleasePerPage       = 100
+)
+
+// BinSrcGithubReleaseDstLocalRecipe Deploy binary from GitHub releases to local
+// This recipe expect release tags like `VERSION`, `vVERSION` or `PREFIX-VERSION`, and
+// release assets named like `PREFIX-VERSION-SUFFIX.zip` (or `.tar.gz`, `.tar.xz`, `.tar.zst` and the raw binary).
+// For example, if prefix is `myapp` and suffix is `linux-amd64`, the release `v1.0.0` should
+// have the asset `myapp-1.0.0-linux-amd64.zip`.
+// Pre-releases are in the beta channel, and drafts are ignored. Release assets named like `CHANNEL.beta` or `ROLLOUT.10`
+// are markers of the version.
+type BinSrcGithubReleaseDstLocalRecipe struct {
+	// ApiUrl is the base url of the GitHub API. If empty, `https://api.github.com` is used.
+	ApiUrl string `json:"api_url,omitempty"`
+
+	// Owner is the owner of the repository
+	Owner string `json:"owner"`
+
+	// Repository is the name of the repository
+	Repository string `json:"repository"`
+
+	BinDstLocalRecipe
+}
+
+// BinSrcGithubRelease is the subset of the release model of the GitHub API.
+type BinSrcGithubRelease struct {
+	TagName     string                     `json:"tag_name"`
+	Draft       bool                       `json:"draft"`
+	Prerelease  bool                       `json:"prerelease"`
+	PublishedAt string                     `json:"published_at"`
+	Assets      []BinSrcGithubReleaseAsset `json:"assets"`
+}
+
+// BinSrcGithubReleaseAsset is the subset of the release asset model of the GitHub API.
+type BinSrcGithubReleaseAsset struct {
+	Name               string `json:"name"`
+	Size               int64  `json:"size"`
+	BrowserDownloadUrl string `json:"browser_download_url"`
+}
+
+func init() {
+	RegisterBinSource(BinSourceRegistration{
+		SourceType: SourceTypeGithubRelease,
+		Recipe: func() interface{} {
+			return &BinSrcGithubReleaseDstLocalRecipe{}
+		},
+		New: func(ctl app_control.Control, recipe interface{}, auth interface{}) (BinSource, error) {
+			return NewBinSrcGithubReleaseSource(*recipe.(*BinSrcGithubReleaseDstLocalRecipe), ctl), nil
+		},
+	})
+}
+
+func NewBinSrcGithubReleaseDstLocal(recipe BinSrcGithubReleaseDstLocalRecipe, ctl app_control.Control) BinDeploy {
+	return newBinDstLocal(recipe.BinDstLocalRecipe, ctl, NewBinSrcGithubReleaseSource(recipe, ctl))
+}
+
+func NewBinSrcGithubReleaseSource(recipe BinSrcGithubReleaseDstLocalRecipe, ctl app_control.Control) BinSource {
+	return &binSrcGithubReleaseImpl{
+		recipe: recipe,
+		ctl:    ctl,
+	}
+}
+
+type binSrcGithubReleaseImpl struct {
+	recipe BinSrcGithubReleaseDstLocalRecipe
+	ctl    app_control.Control
+}
+
+func (z binSrcGithubReleaseImpl) apiUrl() string {
+	if z.recipe.ApiUrl == "" {
+		return BinSrcGithubReleaseDefaultApiUrl
+	}
+	return strings.TrimSuffix(z.recipe.ApiUrl, "/")
+}
+
+func (z binSrcGithubReleaseImpl) Id() string {
+	return z.apiUrl() + "/repos/" + z.recipe.Owner + "/" + z.recipe.Repository
+}
+
+// tagVersion parses the tag name as the version. Returns false if the tag is not a version.
+func (z binSrcGithubReleaseImpl) tagVersion(tagName string) (version es_version.Version, ok bool) {
+	verStr := strings.TrimPrefix(tagName, z.recipe.Prefix+"-")
+	verStr = strings.TrimPrefix(verStr, "v")
+	ver, err := es_version.Parse(verStr)
+	if err != nil {
+		return es_version.Zero(), false
+	}
+	return ver, true
+}
+
+func (z binSrcGithubReleaseImpl) listReleases() (releases []BinSrcGithubRelease, err error) {
+	l := z.ctl.Log().With(esl.String("source", z.Id()))
+	releases = make([]BinSrcGithubRelease, 0)
+	header := map[string]string{
+		"Accept": "application/vnd.github+json",
+	}
+	for page := 1; ; page++ {
+		q := url.Values{}
+		q.Set("per_page", fmt.Sprintf("%d", binSrcGithubReleasePerPage))
+		q.Set("page", fmt.Sprintf("%d", page))
+		content, err := utilHttpGet(z.ctl, z.Id()+"/releases?"+q.Encode(), header)
+		if err != nil {
+			l.Debug("Unable to retrieve releases", esl.Error(err))
+			return nil, err
+		}
+		pageReleases := make([]BinSrcGithubRelease, 0)
+		if err := json.Unmarshal(content, &pageReleases); err != nil {
+			l.Debug("Unable to parse releases", esl.Error(err))
+			return nil, err
+		}
+		releases = append(releases, pageReleases...)
+		if len(pageReleases) < binSrcGithubReleasePerPage {
+			return releases, nil
+		}
+	}
+}
+
+func (z binSrcGithubReleaseImpl) ListRemoteVersions() (versions []es_version.Version, versionPaths map[string]string, metas map[string]BinRemoteVersionMeta, err error) {
+	l := z.ctl.Log().With(esl.String("source", z.Id()))
+	versions = make([]es_version.Version, 0)
+	versionPaths = make(map[string]string)
+	metas = make(map[string]BinRemoteVersionMeta)
+
+	releases, err := z.listReleases()
+	if err != nil {
+		return versions, versionPaths, metas, err
+	}
+
+	for _, release := range releases {
+		if release.Draft {
+			l.Debug("Skip draft", esl.String("tag", release.TagName))
+			continue
+		}
+		ver, ok := z.tagVersion(release.TagName)
+		if !ok {
+			l.Debug("Skip tag", esl.String("tag", release.TagName))
+			continue
+		}
+		// markers are release assets like `CHANNEL.beta` or `ROLLOUT.10`.
+		meta := BinRemoteVersionMeta{PublishedAt: release.PublishedAt}
+		for _, asset := range release.Assets {
+			if utilSourceApplyMarker(asset.Name, &meta) {
+				l.Debug("Found marker", esl.String("tag", release.TagName), esl.String("name", asset.Name))
+			}
+		}
+		// the pre-release is beta, unless the tag is a nightly build like `v1.0.0-nightly.1`.
+		if release.Prerelease && meta.Channel == "" {
+			meta.Channel = utilChannelOfVersion(ver)
+			if meta.Channel == ChannelStable {
+				meta.Channel = ChannelBeta
+			}
+		}
+		metas[ver.String()] = meta
+		for _, asset := range release.Assets {
+			if !utilSourceIsAsset(z.recipe.Prefix, z.recipe.Suffix, asset.Name) {
+				l.Debug("Skip asset", esl.String("name", asset.Name))
+				continue
+			}
+			l.Debug("Found version", esl.String("version", ver.String()), esl.String("url", asset.BrowserDownloadUrl))
+			versions = append(versions, ver)
+			versionPaths[ver.String()] = asset.BrowserDownloadUrl
+			break
+		}
+	}
+
+	return versions, versionPaths, metas, nil
+}
+
+func (z binSrcGithubReleaseImpl) Download(version es_version.Version, versionPath string) (downloadPath string, err error) {
+	l := z.ctl.Log().With(esl.String("version", version.String()), esl.String("versionPath", versionPath))
+	l.Debug("Download version")
+
+	name := z.recipe.Prefix + "-" + version.String() + "-" + z.recipe.Suffix
+	if assetUrl, err := url.Parse(versionPath); err == nil {
+		if base := path.Base(assetUrl.Path); base != "." && base != "/" {
+			name = base
+		}
+	}
+	downloadPath, err = utilHttpDownload(z.ctl, versionPath, name, map[string]string{
+		"Accept": "application/octet-stream",
+	})
+	if err != nil {
+		l.Debug("Unable to download version", esl.Error(err))
+		return "", err
+	}
+	l.Info("Downloaded", esl.String("path", downloadPath))
+	return downloadPath, nil
+}
+
+func (z binSrcGithubReleaseImpl) ExpectedSha256(version es_version.Version, versionPath string) (digest string, found bool, err error) {
+	assetUrl, err := url.Parse(versionPath)
+	if err != nil {
+		return "", false, err
+	}
+	// checksum files are release assets of the same release, e.g. `ASSET.sha256` or `SHA256SUMS`.
+	return utilChecksumFromSidecars(path.Base(assetUrl.Path), func(name string) (content []byte, found bool, err error) {
+		return z.sidecar(versionPath, name)
+	})
+}
+
+func (z binSrcGithubReleaseImpl) AssetSidecar(version es_version.Version, versionPath, extension string) (content []byte, found bool, err error) {
+	assetUrl, err := url.Parse(versionPath)
+	if err != nil {
+		return nil, false, err
+	}
+	return z.sidecar(versionPath, path.Base(assetUrl.Path)+extension)
+}
+
+// sidecar retrieves the release asset of the same release as the asset.
+func (z binSrcGithubReleaseImpl) sidecar(versionPath, name string) (content []byte, found bool, err error) {
+	sidecarUrl, err := utilHttpSiblingUrl(versionPath, name)
+	if err != nil {
+		return nil, false, err
+	}
+	return utilHttpGetIfExists(z.ctl, sidecarUrl, map[string]string{
+		"Accept": "application/octet-stream",
+	})
+}
+
+// RevocationList retrieves `revoked.json` at the root of the default branch of the repository.
+func (z binSrcGithubReleaseImpl) RevocationList() (content []byte, found bool, err error) {
+	return utilHttpGetIfExists(z.ctl, z.Id()+"/contents/"+RevocationListName, map[string]string{
+		"Accept": "application/vnd.github.raw",
+	})
+}
@@ -0,0 +1,122 @@
+package sb_deploy
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"github.com/watermint/toolbox/essentials/strings/es_version"
+	"github.com/watermint/toolbox/infra/control/app_control"
+	"github.com/watermint/toolbox/quality/recipe/qtr_endtoend"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testZipArchive creates a zip archive that contains files (name as key, content as value).
+func testZipArchive(t *testing.T, path string, files map[string]string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	zw := zip.NewWriter(f)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBinSrcGithubReleaseDstLocal(t *testing.T) {
+	qtr_endtoend.TestWithControl(t, func(ctl app_control.Control) {
+		archivePath := filepath.Join(t.TempDir(), "myapp-1.1.0-linux-amd64.zip")
+		testZipArchive(t, archivePath, map[string]string{
+			utilBinaryName("myapp"): "1.1.0",
+		})
+
+		var server *httptest.Server
+		mux := http.NewServeMux()
+		mux.HandleFunc("/repos/watermint/myapp/releases", func(w http.ResponseWriter, r *http.Request) {
+			asset := func(name string) BinSrcGithubReleaseAsset {
+				return BinSrcGithubReleaseAsset{
+					Name:               name,
+					BrowserDownloadUrl: server.URL + "/download/" + name,
+				}
+			}
+			releases := []BinSrcGithubRelease{
+				{TagName: "v2.0.0-beta", Prerelease: true, Assets: []BinSrcGithubReleaseAsset{asset("myapp-2.0.0-beta-linux-amd64.zip")}},
+				{TagName: "v1.2.0", Draft: true, Assets: []BinSrcGithubReleaseAsset{asset("myapp-1.2.0-linux-amd64.zip")}},
+				{TagName: "v1.1.0", Assets: []BinSrcGithubReleaseAsset{asset("myapp-1.1.0-darwin-arm64.zip"), asset("myapp-1.1.0-linux-amd64.zip")}},
+				{TagName: "myapp-1.0.0", Assets: []BinSrcGithubReleaseAsset{asset("myapp-1.0.0-linux-amd64.zip")}},
+				{TagName: "nightly", Assets: []BinSrcGithubReleaseAsset{asset("myapp-nightly-linux-amd64.zip")}},
+			}
+			_ = json.NewEncoder(w).Encode(releases)
+		})
+		mux.HandleFunc("/download/myapp-1.1.0-linux-amd64.zip", func(w http.ResponseWriter, r *http.Request) {
+			http.ServeFile(w, r, archivePath)
+		})
+		mux.HandleFunc("/download/SHA256SUMS", func(w http.ResponseWriter, r *http.Request) {
+			digest, err := utilSha256File(archivePath)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			_, _ = w.Write([]byte(digest + "  myapp-1.1.0-linux-amd64.zip\n"))
+		})
+		server = httptest.NewServer(mux)
+		defer server.Close()
+
+		cellarPath := filepath.Join(t.TempDir(), "cellar")
+		worker := NewBinSrcGithubReleaseDstLocal(BinSrcGithubReleaseDstLocalRecipe{
+			ApiUrl:     server.URL,
+			Owner:      "watermint",
+			Repository: "myapp",
+			BinDstLocalRecipe: BinDstLocalRecipe{
+				BinaryName: "myapp",
+				Prefix:     "myapp",
+				Suffix:     "linux-amd64",
+				CellarPath: cellarPath,
+
+				RequireChecksum: true,
+			},
+		}, ctl)
+
+		versions, versionPaths, _, err := worker.ListRemoteVersions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(versions) != 2 {
+			t.Error(versions)
+		}
+		if latest := es_version.Max(versions...); latest.String() != "1.1.0" {
+			t.Error(latest)
+		}
+		if p := versionPaths["1.1.0"]; p != server.URL+"/download/myapp-1.1.0-linux-amd64.zip" {
+			t.Error(p)
+		}
+
+		if err := worker.UpdateIfRequired(); err != nil {
+			t.Fatal(err)
+		}
+		binPath := worker.LocalLatestBinaryPath()
+		if binPath != filepath.Join(cellarPath, "myapp-1.1.0", utilBinaryName("myapp")) {
+			t.Error(binPath)
+		}
+		if content, err := os.ReadFile(binPath); err != nil || string(content) != "1.1.0" {
+			t.Error(string(content), err)
+		}
+		if required, err := worker.IsUpdateRequired(); err != nil || required {
+			t.Error(required, err)
+		}
+	})
+}
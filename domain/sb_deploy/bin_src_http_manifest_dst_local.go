@@ -0,0 +1,328 @@
+package sb_deploy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/watermint/toolbox/essentials/log/esl"
+	"github.com/watermint/toolbox/essentials/strings/es_version"
+	"github.com/watermint/toolbox/infra/control/app_control"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+var (
+	ErrorManifestVersionNotFound = errors.New("version not found in the manifest")
+	ErrorManifestInvalidDigest   = errors.New("invalid sha256 digest in the manifest")
+	ErrorSizeMismatch            = errors.New("size mismatch")
+)
+
+// BinSrcHttpManifestDstLocalRecipe Deploy binary from HTTP(S) server to local
+// This recipe expect the manifest file (e.g. `versions.json`) like this:
+//
+//	{
+//	  "versions": [
+//	    {
+//	      "version": "1.0.0",
+//	      "channel": "stable",
+//	      "rollout": 10,
+//	      "published_at": "2024-05-01T00:00:00Z",
+//	      "platforms": {
+//	        "linux-amd64": {
+//	          "url": "myapp-1.0.0/myapp-1.0.0-linux-amd64.zip",
+//	          "size": 1234,
+//	          "sha256": "..."
+//	        }
+//	      }
+//	    }
+//	  ]
+//	}
+//
+// The platform key is the suffix of the recipe, and the url can be relative to the manifest url.
+type BinSrcHttpManifestDstLocalRecipe struct {
+	// ManifestUrl is the url to the manifest file
+	ManifestUrl string `json:"manifest_url"`
+
+	BinDstLocalRecipe
+}
+
+type BinSrcHttpManifest struct {
+	Versions []BinSrcHttpManifestVersion `json:"versions"`
+}
+
+type BinSrcHttpManifestVersion struct {
+	// Version is the version string
+	Version string `json:"version"`
+
+	// Channel is the release channel of the version: `stable`, `beta` or `nightly`.
+	// The channel is derived from the pre-release of the version if empty.
+	Channel string `json:"channel,omitempty"`
+
+	// Rollout is the percentage (0-100) of machines to receive the version. All machines receive the version if omitted.
+	Rollout *int `json:"rollout,omitempty"`
+
+	// PublishedAt is the release time in RFC3339, the basis of the minimum age of the update policy.
+	PublishedAt string `json:"published_at,omitempty"`
+
+	// Platforms is the map of platforms, the suffix as key
+	Platforms map[string]BinSrcHttpManifestAsset `json:"platforms"`
+}
+
+type BinSrcHttpManifestAsset struct {
+	// Url is the url to the archive. The url can be relative to the manifest url.
+	Url string `json:"url"`
+
+	// Size is the size of the archive in bytes. The size is not verified if zero.
+	Size int64 `json:"size,omitempty"`
+
+	// Sha256 is the SHA-256 digest of the archive in hex.
+	// The sidecar file `ASSET.sha256` or `SHA256SUMS` next to the archive is used if empty.
+	Sha256 string `json:"sha256,omitempty"`
+}
+
+const (
+	SourceTypeHttpManifest = "http_manifest"
+)
+
+func init() {
+	RegisterBinSource(BinSourceRegistration{
+		SourceType: SourceTypeHttpManifest,
+		Recipe: func() interface{} {
+			return &BinSrcHttpManifestDstLocalRecipe{}
+		},
+		New: func(ctl app_control.Control, recipe interface{}, auth interface{}) (BinSource, error) {
+			return NewBinSrcHttpManifestSource(*recipe.(*BinSrcHttpManifestDstLocalRecipe), ctl), nil
+		},
+	})
+}
+
+func NewBinSrcHttpManifestDstLocal(recipe BinSrcHttpManifestDstLocalRecipe, ctl app_control.Control) BinDeploy {
+	return newBinDstLocal(recipe.BinDstLocalRecipe, ctl, NewBinSrcHttpManifestSource(recipe, ctl))
+}
+
+func NewBinSrcHttpManifestSource(recipe BinSrcHttpManifestDstLocalRecipe, ctl app_control.Control) BinSource {
+	return &binSrcHttpManifestImpl{
+		recipe: recipe,
+		ctl:    ctl,
+	}
+}
+
+type binSrcHttpManifestImpl struct {
+	recipe BinSrcHttpManifestDstLocalRecipe
+	ctl    app_control.Control
+}
+
+func (z binSrcHttpManifestImpl) Id() string {
+	return z.recipe.ManifestUrl
+}
+
+func (z binSrcHttpManifestImpl) manifest() (manifest *BinSrcHttpManifest, err error) {
+	l := z.ctl.Log().With(esl.String("manifestUrl", z.recipe.ManifestUrl))
+	content, err := utilHttpGet(z.ctl, z.recipe.ManifestUrl, map[string]string{})
+	if err != nil {
+		l.Debug("Unable to retrieve the manifest", esl.Error(err))
+		return nil, err
+	}
+	manifest = &BinSrcHttpManifest{}
+	if err := json.Unmarshal(content, manifest); err != nil {
+		l.Debug("Unable to parse the manifest", esl.Error(err))
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// assets returns assets and metadata of the platform, the version string as key.
+func (z binSrcHttpManifestImpl) assets() (versions []es_version.Version, assets map[string]BinSrcHttpManifestAsset, metas map[string]BinRemoteVersionMeta, err error) {
+	l := z.ctl.Log().With(esl.String("manifestUrl", z.recipe.ManifestUrl))
+	versions = make([]es_version.Version, 0)
+	assets = make(map[string]BinSrcHttpManifestAsset)
+	metas = make(map[string]BinRemoteVersionMeta)
+
+	manifest, err := z.manifest()
+	if err != nil {
+		return versions, assets, metas, err
+	}
+	base, err := url.Parse(z.recipe.ManifestUrl)
+	if err != nil {
+		l.Debug("Unable to parse the manifest url", esl.Error(err))
+		return versions, assets, metas, err
+	}
+
+	for _, mv := range manifest.Versions {
+		ver, err := es_version.Parse(mv.Version)
+		if err != nil {
+			l.Debug("Unable to parse version", esl.String("version", mv.Version), esl.Error(err))
+			continue
+		}
+		asset, ok := mv.Platforms[z.recipe.Suffix]
+		if !ok {
+			l.Debug("Skip version without the platform", esl.String("version", mv.Version))
+			continue
+		}
+		assetUrl, err := base.Parse(asset.Url)
+		if err != nil {
+			l.Debug("Unable to parse the asset url", esl.String("url", asset.Url), esl.Error(err))
+			continue
+		}
+		asset.Url = assetUrl.String()
+		if asset.Sha256 != "" {
+			asset.Sha256 = strings.ToLower(asset.Sha256)
+			if !utilChecksumIsDigest(asset.Sha256) {
+				l.Warn("Invalid digest of the asset", esl.String("version", mv.Version), esl.String("sha256", asset.Sha256))
+				return versions, assets, metas, fmt.Errorf("%w: %s", ErrorManifestInvalidDigest, mv.Version)
+			}
+		}
+		versions = append(versions, ver)
+		assets[ver.String()] = asset
+		meta := BinRemoteVersionMeta{PublishedAt: mv.PublishedAt}
+		if mv.Channel != "" {
+			if channel, ok := utilChannelNormalize(mv.Channel); ok {
+				meta.Channel = channel
+			} else {
+				l.Debug("Ignore unknown channel", esl.String("version", mv.Version), esl.String("channel", mv.Channel))
+			}
+		}
+		if mv.Rollout != nil {
+			if utilRolloutIsPercentage(*mv.Rollout) {
+				meta.Rollout = mv.Rollout
+			} else {
+				l.Debug("Ignore invalid rollout", esl.String("version", mv.Version), esl.Int("rollout", *mv.Rollout))
+			}
+		}
+		metas[ver.String()] = meta
+	}
+	return versions, assets, metas, nil
+}
+
+// ListRemoteVersions returns asset entries in JSON as version paths, then the download is verified with the entry of
+// the same manifest, without retrieving the manifest again.
+func (z binSrcHttpManifestImpl) ListRemoteVersions() (versions []es_version.Version, versionPaths map[string]string, metas map[string]BinRemoteVersionMeta, err error) {
+	versionPaths = make(map[string]string)
+	versions, assets, metas, err := z.assets()
+	if err != nil {
+		return versions, versionPaths, metas, err
+	}
+	for v, asset := range assets {
+		entry, err := json.Marshal(asset)
+		if err != nil {
+			return versions, versionPaths, metas, err
+		}
+		versionPaths[v] = string(entry)
+	}
+	return versions, versionPaths, metas, nil
+}
+
+// versionAsset returns the asset entry of the version path.
+// The version path is the asset url in the remote version cache of older releases.
+func (z binSrcHttpManifestImpl) versionAsset(versionPath string) (asset BinSrcHttpManifestAsset, err error) {
+	if !strings.HasPrefix(versionPath, "{") {
+		asset.Url = versionPath
+	} else if err := json.Unmarshal([]byte(versionPath), &asset); err != nil {
+		z.ctl.Log().Debug("Unable to parse the version path", esl.String("versionPath", versionPath), esl.Error(err))
+		return asset, err
+	}
+	if asset.Url == "" {
+		return asset, ErrorManifestVersionNotFound
+	}
+	return asset, nil
+}
+
+// displayPath returns the url of the asset.
+func (z binSrcHttpManifestImpl) displayPath(version es_version.Version, versionPath string) string {
+	asset, err := z.versionAsset(versionPath)
+	if err != nil {
+		return versionPath
+	}
+	return asset.Url
+}
+
+func (z binSrcHttpManifestImpl) Download(version es_version.Version, versionPath string) (downloadPath string, err error) {
+	l := z.ctl.Log().With(esl.String("version", version.String()), esl.String("versionPath", versionPath))
+	l.Debug("Download version")
+
+	asset, err := z.versionAsset(versionPath)
+	if err != nil {
+		return "", err
+	}
+
+	assetUrl, err := url.Parse(asset.Url)
+	if err != nil {
+		l.Debug("Unable to parse the asset url", esl.Error(err))
+		return "", err
+	}
+	name := path.Base(assetUrl.Path)
+	if name == "" || name == "/" || name == "." {
+		name = z.recipe.Prefix + "-" + version.String() + "-" + z.recipe.Suffix
+	}
+
+	downloadPath, err = utilHttpDownload(z.ctl, asset.Url, name, map[string]string{})
+	if err != nil {
+		l.Debug("Unable to download version", esl.Error(err))
+		return "", err
+	}
+
+	if asset.Size > 0 {
+		info, err := os.Stat(downloadPath)
+		if err != nil {
+			l.Debug("Unable to stat the downloaded file", esl.Error(err))
+			return "", err
+		}
+		if info.Size() != asset.Size {
+			l.Warn("Size mismatch", esl.Int64("expected", asset.Size), esl.Int64("actual", info.Size()))
+			_ = os.Remove(downloadPath)
+			return "", ErrorSizeMismatch
+		}
+	}
+
+	l.Info("Downloaded", esl.String("path", downloadPath))
+	return downloadPath, nil
+}
+
+func (z binSrcHttpManifestImpl) ExpectedSha256(version es_version.Version, versionPath string) (digest string, found bool, err error) {
+	asset, err := z.versionAsset(versionPath)
+	if err != nil {
+		return "", false, err
+	}
+	if asset.Sha256 != "" {
+		return strings.ToLower(asset.Sha256), true, nil
+	}
+	assetUrl, err := url.Parse(asset.Url)
+	if err != nil {
+		return "", false, err
+	}
+	return utilChecksumFromSidecars(path.Base(assetUrl.Path), func(name string) (content []byte, found bool, err error) {
+		return z.sidecar(asset.Url, name)
+	})
+}
+
+func (z binSrcHttpManifestImpl) AssetSidecar(version es_version.Version, versionPath, extension string) (content []byte, found bool, err error) {
+	asset, err := z.versionAsset(versionPath)
+	if err != nil {
+		return nil, false, err
+	}
+	assetUrl, err := url.Parse(asset.Url)
+	if err != nil {
+		return nil, false, err
+	}
+	return z.sidecar(asset.Url, path.Base(assetUrl.Path)+extension)
+}
+
+// sidecar retrieves the file next to the asset url.
+func (z binSrcHttpManifestImpl) sidecar(assetUrl, name string) (content []byte, found bool, err error) {
+	sidecarUrl, err := utilHttpSiblingUrl(assetUrl, name)
+	if err != nil {
+		return nil, false, err
+	}
+	return utilHttpGetIfExists(z.ctl, sidecarUrl, map[string]string{})
+}
+
+// RevocationList retrieves `revoked.json` next to the manifest.
+func (z binSrcHttpManifestImpl) RevocationList() (content []byte, found bool, err error) {
+	listUrl, err := utilHttpSiblingUrl(z.recipe.ManifestUrl, RevocationListName)
+	if err != nil {
+		return nil, false, err
+	}
+	return utilHttpGetIfExists(z.ctl, listUrl, map[string]string{})
+}
@@ -0,0 +1,134 @@
+package sb_deploy
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/watermint/toolbox/essentials/strings/es_version"
+	"github.com/watermint/toolbox/infra/control/app_control"
+	"github.com/watermint/toolbox/quality/recipe/qtr_endtoend"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBinSrcHttpManifestDstLocal(t *testing.T) {
+	qtr_endtoend.TestWithControl(t, func(ctl app_control.Control) {
+		archiveDir := t.TempDir()
+		archivePath := filepath.Join(archiveDir, "myapp-1.1.0-linux-amd64.zip")
+		testZipArchive(t, archivePath, map[string]string{
+			utilBinaryName("myapp"): "1.1.0",
+		})
+		archiveInfo, err := os.Stat(archivePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		archiveDigest, err := utilSha256File(archivePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		manifest := BinSrcHttpManifest{
+			Versions: []BinSrcHttpManifestVersion{
+				{
+					Version: "1.0.0",
+					Platforms: map[string]BinSrcHttpManifestAsset{
+						"linux-amd64": {Url: "myapp-1.0.0-linux-amd64.zip", Sha256: strings.Repeat("0", 64)},
+					},
+				},
+				{
+					Version: "1.1.0",
+					Platforms: map[string]BinSrcHttpManifestAsset{
+						"linux-amd64":  {Url: "myapp-1.1.0-linux-amd64.zip", Size: archiveInfo.Size(), Sha256: archiveDigest},
+						"darwin-arm64": {Url: "myapp-1.1.0-darwin-arm64.zip"},
+					},
+				},
+				{
+					Version: "2.0.0",
+					Platforms: map[string]BinSrcHttpManifestAsset{
+						"darwin-arm64": {Url: "myapp-2.0.0-darwin-arm64.zip"},
+					},
+				},
+			},
+		}
+
+		manifestRequests := 0
+		mux := http.NewServeMux()
+		mux.HandleFunc("/release/versions.json", func(w http.ResponseWriter, r *http.Request) {
+			manifestRequests++
+			_ = json.NewEncoder(w).Encode(manifest)
+		})
+		mux.HandleFunc("/release/myapp-1.0.0-linux-amd64.zip", func(w http.ResponseWriter, r *http.Request) {
+			http.ServeFile(w, r, archivePath)
+		})
+		mux.HandleFunc("/release/myapp-1.1.0-linux-amd64.zip", func(w http.ResponseWriter, r *http.Request) {
+			http.ServeFile(w, r, archivePath)
+		})
+		server := httptest.NewServer(mux)
+		defer server.Close()
+
+		cellarPath := filepath.Join(t.TempDir(), "cellar")
+		worker := NewBinSrcHttpManifestDstLocal(BinSrcHttpManifestDstLocalRecipe{
+			ManifestUrl: server.URL + "/release/versions.json",
+			BinDstLocalRecipe: BinDstLocalRecipe{
+				BinaryName: "myapp",
+				Prefix:     "myapp",
+				Suffix:     "linux-amd64",
+				CellarPath: cellarPath,
+			},
+		}, ctl)
+
+		versions, versionPaths, _, err := worker.ListRemoteVersions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if latest := es_version.Max(versions...); len(versions) != 2 || latest.String() != "1.1.0" {
+			t.Error(versions)
+		}
+		asset := BinSrcHttpManifestAsset{}
+		if err := json.Unmarshal([]byte(versionPaths["1.1.0"]), &asset); err != nil || asset.Url != server.URL+"/release/myapp-1.1.0-linux-amd64.zip" || asset.Sha256 != archiveDigest {
+			t.Error(asset, err)
+		}
+
+		// digest of 1.0.0 does not match
+		if _, err := worker.Download(es_version.MustParse("1.0.0"), versionPaths["1.0.0"]); !errors.Is(err, ErrorChecksumMismatch) {
+			t.Error(err)
+		}
+
+		if err := worker.UpdateIfRequired(); err != nil {
+			t.Fatal(err)
+		}
+		if content, err := os.ReadFile(worker.LocalLatestBinaryPath()); err != nil || string(content) != "1.1.0" {
+			t.Error(string(content), err)
+		}
+		// the manifest is retrieved once, then the download uses the entry of the same manifest
+		if manifestRequests != 1 {
+			t.Error(manifestRequests)
+		}
+
+		// the url of the asset is displayed instead of the entry
+		if status, err := worker.ListRemoteVersionStatus(); err != nil || len(status) != 2 ||
+			status[0].Version != "1.1.0" || status[0].Path != asset.Url || !status[0].Installed || !status[0].Latest ||
+			status[1].Path != server.URL+"/release/myapp-1.0.0-linux-amd64.zip" || status[1].Latest {
+			t.Error(status, err)
+		}
+
+		// the url in the remote version cache of older releases
+		source := NewBinSrcHttpManifestSource(BinSrcHttpManifestDstLocalRecipe{ManifestUrl: server.URL + "/release/versions.json"}, ctl)
+		if digest, found, err := source.ExpectedSha256(es_version.MustParse("1.1.0"), asset.Url); err != nil || found {
+			t.Error(digest, found, err)
+		}
+
+		// the malformed digest is rejected on parsing the manifest
+		manifest.Versions[0].Platforms["linux-amd64"] = BinSrcHttpManifestAsset{Url: "myapp-1.0.0-linux-amd64.zip", Sha256: "0000"}
+		source = NewBinSrcHttpManifestSource(BinSrcHttpManifestDstLocalRecipe{
+			ManifestUrl:       server.URL + "/release/versions.json",
+			BinDstLocalRecipe: BinDstLocalRecipe{Prefix: "myapp", Suffix: "linux-amd64"},
+		}, ctl)
+		if _, _, _, err := source.ListRemoteVersions(); !errors.Is(err, ErrorManifestInvalidDigest) || !strings.Contains(err.Error(), "1.0.0") {
+			t.Error(err)
+		}
+	})
+}
@@ -0,0 +1,160 @@
+package sb_deploy
+
+import (
+	"github.com/watermint/toolbox/essentials/io/es_file_copy"
+	"github.com/watermint/toolbox/essentials/log/esl"
+	"github.com/watermint/toolbox/essentials/strings/es_version"
+	"github.com/watermint/toolbox/infra/control/app_control"
+	"os"
+	"path/filepath"
+)
+
+// BinSrcLocalDirDstLocalRecipe Deploy binary from local directory (e.g. mounted network share) to local
+// This recipe expect folder structure same as the Dropbox source:
+// `PREFIX-VERSION/PREFIX-VERSION-SUFFIX.zip`.
+type BinSrcLocalDirDstLocalRecipe struct {
+	// SourcePath is the path to the directory that contains version folders
+	SourcePath string `json:"source_path"`
+
+	BinDstLocalRecipe
+}
+
+const (
+	SourceTypeLocalDir = "local_dir"
+)
+
+func init() {
+	RegisterBinSource(BinSourceRegistration{
+		SourceType: SourceTypeLocalDir,
+		Recipe: func() interface{} {
+			return &BinSrcLocalDirDstLocalRecipe{}
+		},
+		New: func(ctl app_control.Control, recipe interface{}, auth interface{}) (BinSource, error) {
+			return NewBinSrcLocalDirSource(*recipe.(*BinSrcLocalDirDstLocalRecipe), ctl), nil
+		},
+	})
+}
+
+func NewBinSrcLocalDirDstLocal(recipe BinSrcLocalDirDstLocalRecipe, ctl app_control.Control) BinDeploy {
+	return newBinDstLocal(recipe.BinDstLocalRecipe, ctl, NewBinSrcLocalDirSource(recipe, ctl))
+}
+
+func NewBinSrcLocalDirSource(recipe BinSrcLocalDirDstLocalRecipe, ctl app_control.Control) BinSource {
+	return &binSrcLocalDirImpl{
+		recipe: recipe,
+		ctl:    ctl,
+	}
+}
+
+type binSrcLocalDirImpl struct {
+	recipe BinSrcLocalDirDstLocalRecipe
+	ctl    app_control.Control
+}
+
+func (z binSrcLocalDirImpl) Id() string {
+	return z.recipe.SourcePath
+}
+
+func (z binSrcLocalDirImpl) ListRemoteVersions() (versions []es_version.Version, versionPaths map[string]string, metas map[string]BinRemoteVersionMeta, err error) {
+	l := z.ctl.Log().With(esl.String("sourcePath", z.recipe.SourcePath))
+	versions = make([]es_version.Version, 0)
+	versionPaths = make(map[string]string)
+	metas = make(map[string]BinRemoteVersionMeta)
+
+	folderEntries, err := os.ReadDir(z.recipe.SourcePath)
+	if err != nil {
+		l.Debug("Unable to read the source directory", esl.Error(err))
+		return versions, versionPaths, metas, err
+	}
+	for _, folderEntry := range folderEntries {
+		if !folderEntry.IsDir() {
+			l.Debug("Skip entry", esl.String("name", folderEntry.Name()))
+			continue
+		}
+		folderPath := filepath.Join(z.recipe.SourcePath, folderEntry.Name())
+		fileEntries, err := os.ReadDir(folderPath)
+		if err != nil {
+			l.Debug("Unable to read the version directory", esl.String("path", folderPath), esl.Error(err))
+			continue
+		}
+		for _, fileEntry := range fileEntries {
+			if !fileEntry.Type().IsRegular() {
+				l.Debug("Skip entry", esl.String("name", fileEntry.Name()))
+				continue
+			}
+			if utilSourceParseMarker(z.recipe.Prefix, folderEntry.Name(), fileEntry.Name(), metas) {
+				l.Debug("Found marker", esl.String("name", fileEntry.Name()))
+				continue
+			}
+			ver, ok := utilSourceParseVersion(z.recipe.Prefix, z.recipe.Suffix, folderEntry.Name(), fileEntry.Name())
+			if _, dup := versionPaths[ver.String()]; !ok || dup {
+				l.Debug("Skip entry", esl.String("name", fileEntry.Name()))
+				continue
+			}
+			filePath := filepath.Join(folderPath, fileEntry.Name())
+			l.Debug("Found version", esl.String("version", ver.String()), esl.String("path", filePath))
+			versions = append(versions, ver)
+			versionPaths[ver.String()] = filePath
+		}
+	}
+
+	return versions, versionPaths, metas, nil
+}
+
+func (z binSrcLocalDirImpl) Download(version es_version.Version, versionPath string) (downloadPath string, err error) {
+	l := z.ctl.Log().With(esl.String("version", version.String()), esl.String("versionPath", versionPath))
+	l.Debug("Download version")
+
+	downloadFolder, err := z.ctl.Workspace().Descendant("download")
+	if err != nil {
+		l.Debug("Unable to create download folder", esl.Error(err))
+		return "", err
+	}
+	// Copy the archive, because the downloaded file will be removed after extraction.
+	downloadPath = filepath.Join(downloadFolder, filepath.Base(versionPath))
+	if err := es_file_copy.Copy(versionPath, downloadPath); err != nil {
+		l.Debug("Unable to copy the archive", esl.Error(err))
+		return "", err
+	}
+	l.Info("Downloaded", esl.String("path", downloadPath))
+	return downloadPath, nil
+}
+
+// sidecar reads the file in the same folder as the asset.
+func (z binSrcLocalDirImpl) sidecar(versionPath, name string) (content []byte, found bool, err error) {
+	content, err = os.ReadFile(filepath.Join(filepath.Dir(versionPath), name))
+	switch {
+	case os.IsNotExist(err):
+		return nil, false, nil
+	case err != nil:
+		return nil, false, err
+	default:
+		return content, true, nil
+	}
+}
+
+func (z binSrcLocalDirImpl) ExpectedSha256(version es_version.Version, versionPath string) (digest string, found bool, err error) {
+	return utilChecksumFromSidecars(filepath.Base(versionPath), func(name string) (content []byte, found bool, err error) {
+		return z.sidecar(versionPath, name)
+	})
+}
+
+func (z binSrcLocalDirImpl) AssetSidecar(version es_version.Version, versionPath, extension string) (content []byte, found bool, err error) {
+	return z.sidecar(versionPath, filepath.Base(versionPath)+extension)
+}
+
+func (z binSrcLocalDirImpl) RevocationList() (content []byte, found bool, err error) {
+	content, err = os.ReadFile(filepath.Join(z.recipe.SourcePath, RevocationListName))
+	switch {
+	case os.IsNotExist(err):
+		// the source itself is not available, such as the network share is offline.
+		if _, err := os.Stat(z.recipe.SourcePath); err != nil {
+			return nil, false, err
+		}
+		return nil, false, nil
+	case err != nil:
+		return nil, false, err
+	default:
+		return content, true, nil
+	}
+}
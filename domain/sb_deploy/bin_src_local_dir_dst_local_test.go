@@ -0,0 +1,127 @@
+package sb_deploy
+
+import (
+	"archive/tar"
+	"github.com/watermint/toolbox/essentials/strings/es_version"
+	"github.com/watermint/toolbox/infra/control/app_control"
+	"github.com/watermint/toolbox/quality/recipe/qtr_endtoend"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// testLocalDirSource creates the source directory that follows `PREFIX-VERSION/PREFIX-VERSION-SUFFIX.zip`.
+// The archive contains the binary `myapp` and the content of the binary is the version string.
+func testLocalDirSource(t *testing.T, sourcePath string, suffix string, versions ...string) {
+	testLocalDirSourceOf(t, sourcePath, "myapp", suffix, versions...)
+}
+
+// testLocalDirSourceOf creates versions of the package, that the binary name is same as the prefix.
+func testLocalDirSourceOf(t *testing.T, sourcePath, prefix, suffix string, versions ...string) {
+	for _, v := range versions {
+		folderPath := filepath.Join(sourcePath, prefix+"-"+v)
+		if err := os.MkdirAll(folderPath, 0755); err != nil {
+			t.Fatal(err)
+		}
+		testZipArchive(t, filepath.Join(folderPath, prefix+"-"+v+"-"+suffix+".zip"), map[string]string{
+			utilBinaryName(prefix): v,
+		})
+	}
+}
+
+func TestBinSrcLocalDirDstLocal(t *testing.T) {
+	qtr_endtoend.TestWithControl(t, func(ctl app_control.Control) {
+		sourcePath := t.TempDir()
+		testLocalDirSource(t, sourcePath, "linux-amd64", "1.0.0", "1.2.0")
+		testLocalDirSource(t, sourcePath, "darwin-arm64", "1.3.0")
+		if err := os.MkdirAll(filepath.Join(sourcePath, "myapp-latest"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(sourcePath, "README.txt"), []byte("readme"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		cellarPath := filepath.Join(t.TempDir(), "cellar")
+		worker := NewBinSrcLocalDirDstLocal(BinSrcLocalDirDstLocalRecipe{
+			SourcePath: sourcePath,
+			BinDstLocalRecipe: BinDstLocalRecipe{
+				BinaryName: "myapp",
+				Prefix:     "myapp",
+				Suffix:     "linux-amd64",
+				CellarPath: cellarPath,
+			},
+		}, ctl)
+
+		versions, versionPaths, _, err := worker.ListRemoteVersions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if latest := es_version.Max(versions...); len(versions) != 2 || latest.String() != "1.2.0" {
+			t.Error(versions)
+		}
+		if p := versionPaths["1.2.0"]; p != filepath.Join(sourcePath, "myapp-1.2.0", "myapp-1.2.0-linux-amd64.zip") {
+			t.Error(p)
+		}
+
+		if err := worker.UpdateIfRequired(); err != nil {
+			t.Fatal(err)
+		}
+		if content, err := os.ReadFile(worker.LocalLatestBinaryPath()); err != nil || string(content) != "1.2.0" {
+			t.Error(string(content), err)
+		}
+		// the source archive must be kept
+		if _, err := os.Stat(versionPaths["1.2.0"]); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestBinSrcLocalDirDstLocal_ArchiveFormats(t *testing.T) {
+	qtr_endtoend.TestWithControl(t, func(ctl app_control.Control) {
+		sourcePath := t.TempDir()
+		for _, v := range []string{"1.0.0", "1.1.0"} {
+			if err := os.MkdirAll(filepath.Join(sourcePath, "myapp-"+v), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		testTarArchive(t, filepath.Join(sourcePath, "myapp-1.0.0", "myapp-1.0.0-linux-amd64.tar.gz"), ArchiveFormatTarGz, []testTarEntry{
+			{Name: utilBinaryName("myapp"), Content: "1.0.0", Mode: 0755, Type: tar.TypeReg},
+		})
+		rawPath := filepath.Join(sourcePath, "myapp-1.1.0", "myapp-1.1.0-linux-amd64")
+		if err := os.WriteFile(rawPath, []byte("1.1.0"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		worker := NewBinSrcLocalDirDstLocal(BinSrcLocalDirDstLocalRecipe{
+			SourcePath: sourcePath,
+			BinDstLocalRecipe: BinDstLocalRecipe{
+				BinaryName: "myapp",
+				Prefix:     "myapp",
+				Suffix:     "linux-amd64",
+				CellarPath: filepath.Join(t.TempDir(), "cellar"),
+			},
+		}, ctl)
+		_, versionPaths, _, err := worker.ListRemoteVersions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range []string{"1.0.0", "1.1.0"} {
+			dlPath, err := worker.Download(es_version.MustParse(v), versionPaths[v])
+			if err != nil {
+				t.Fatal(err)
+			}
+			cellarPath, err := worker.Extract(es_version.MustParse(v), dlPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			binPath := filepath.Join(cellarPath, utilBinaryName("myapp"))
+			if content, err := os.ReadFile(binPath); err != nil || string(content) != v {
+				t.Error(v, string(content), err)
+			}
+			if info, err := os.Stat(binPath); err != nil || (runtime.GOOS != "windows" && info.Mode().Perm()&0100 == 0) {
+				t.Error(v, info, err)
+			}
+		}
+	})
+}
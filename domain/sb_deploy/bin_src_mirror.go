@@ -0,0 +1,233 @@
+package sb_deploy
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/watermint/toolbox/essentials/log/esl"
+	"github.com/watermint/toolbox/essentials/strings/es_version"
+	"github.com/watermint/toolbox/infra/control/app_control"
+	"strings"
+)
+
+var (
+	ErrorMirrorVersionNotFound = errors.New("version not found in mirrors")
+)
+
+// BinSrcMirrorCandidate is the location of the version in the mirror.
+// The version path of the mirror is the JSON array of candidates in priority order, then
+// the version path in the remote version cache is still available after the restart.
+type BinSrcMirrorCandidate struct {
+	SourceId string `json:"source_id"`
+	Path     string `json:"path"`
+}
+
+// newBinSrcMirror creates the source that merges versions of sources.
+// Sources are in priority order. The download falls back to the next source that has the same version.
+func newBinSrcMirror(ctl app_control.Control, sources []BinSource) BinSource {
+	return &binSrcMirrorImpl{
+		ctl:     ctl,
+		sources: sources,
+	}
+}
+
+type binSrcMirrorImpl struct {
+	ctl     app_control.Control
+	sources []BinSource
+}
+
+func (z binSrcMirrorImpl) Id() string {
+	ids := make([]string, 0, len(z.sources))
+	for _, source := range z.sources {
+		ids = append(ids, source.Id())
+	}
+	return "mirror:" + strings.Join(ids, ",")
+}
+
+func (z binSrcMirrorImpl) ListRemoteVersions() (versions []es_version.Version, versionPaths map[string]string, metas map[string]BinRemoteVersionMeta, err error) {
+	l := z.ctl.Log()
+	versions = make([]es_version.Version, 0)
+	versionPaths = make(map[string]string)
+	metas = make(map[string]BinRemoteVersionMeta)
+
+	candidates := make(map[string][]BinSrcMirrorCandidate)
+	available := 0
+	var lastErr error
+	for _, source := range z.sources {
+		ll := l.With(esl.String("source", source.Id()))
+		sourceVersions, sourceVersionPaths, sourceMetas, err := source.ListRemoteVersions()
+		if err != nil {
+			ll.Warn("Unable to list remote versions, try next source", esl.Error(err))
+			lastErr = err
+			continue
+		}
+		available++
+		for _, ver := range sourceVersions {
+			if _, ok := candidates[ver.String()]; !ok {
+				versions = append(versions, ver)
+			}
+			candidates[ver.String()] = append(candidates[ver.String()], BinSrcMirrorCandidate{
+				SourceId: source.Id(),
+				Path:     sourceVersionPaths[ver.String()],
+			})
+			// the metadata of the prior source is used
+			if meta, ok := sourceMetas[ver.String()]; ok {
+				if _, exists := metas[ver.String()]; !exists {
+					metas[ver.String()] = meta
+				}
+			}
+		}
+	}
+	if available < 1 {
+		l.Debug("No source available", esl.Error(lastErr))
+		return versions, versionPaths, metas, lastErr
+	}
+
+	for ver, c := range candidates {
+		path, err := json.Marshal(c)
+		if err != nil {
+			l.Debug("Unable to marshal candidates", esl.Error(err))
+			return versions, versionPaths, metas, err
+		}
+		versionPaths[ver] = string(path)
+	}
+	return versions, versionPaths, metas, nil
+}
+
+// binSrcCandidate is the source and the version path of the candidate of the version.
+type binSrcCandidate struct {
+	source BinSource
+	path   string
+}
+
+// binSrcCandidates is the source that has multiple candidates of the version, such as the mirror.
+// The downloaded file is verified with the checksum and the signature published by the same candidate,
+// and the next candidate is tried if the download or the verification failed.
+type binSrcCandidates interface {
+	candidates(version es_version.Version, versionPath string) (candidates []binSrcCandidate, err error)
+}
+
+func (z binSrcMirrorImpl) candidates(version es_version.Version, versionPath string) (candidates []binSrcCandidate, err error) {
+	l := z.ctl.Log().With(esl.String("version", version.String()))
+	mirrorCandidates := make([]BinSrcMirrorCandidate, 0)
+	if err := json.Unmarshal([]byte(versionPath), &mirrorCandidates); err != nil {
+		l.Debug("Unable to parse the version path", esl.String("versionPath", versionPath), esl.Error(err))
+		return nil, err
+	}
+	candidates = make([]binSrcCandidate, 0, len(mirrorCandidates))
+	for _, candidate := range mirrorCandidates {
+		for _, source := range z.sources {
+			if source.Id() == candidate.SourceId {
+				candidates = append(candidates, binSrcCandidate{source: source, path: candidate.Path})
+			}
+		}
+	}
+	return candidates, nil
+}
+
+// displayPath returns paths of candidates in priority order, separated by comma.
+func (z binSrcMirrorImpl) displayPath(version es_version.Version, versionPath string) string {
+	candidates, err := z.candidates(version, versionPath)
+	if err != nil {
+		return versionPath
+	}
+	paths := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		path := candidate.path
+		if d, ok := candidate.source.(binSrcDisplayPath); ok {
+			path = d.displayPath(version, path)
+		}
+		paths = append(paths, path)
+	}
+	return strings.Join(paths, ", ")
+}
+
+func (z binSrcMirrorImpl) Download(version es_version.Version, versionPath string) (downloadPath string, err error) {
+	l := z.ctl.Log().With(esl.String("version", version.String()))
+	candidates, err := z.candidates(version, versionPath)
+	if err != nil {
+		return "", err
+	}
+	lastErr := ErrorMirrorVersionNotFound
+	for _, candidate := range candidates {
+		downloadPath, err = candidate.source.Download(version, candidate.path)
+		if err == nil {
+			return downloadPath, nil
+		}
+		l.Warn("Unable to download, try next source", esl.String("source", candidate.source.Id()), esl.Error(err))
+		lastErr = err
+	}
+	return "", lastErr
+}
+
+// ExpectedSha256 returns the checksum of the first candidate.
+// The worker downloads and verifies candidates one by one through binSrcCandidates instead.
+func (z binSrcMirrorImpl) ExpectedSha256(version es_version.Version, versionPath string) (digest string, found bool, err error) {
+	candidates, err := z.candidates(version, versionPath)
+	if err != nil {
+		return "", false, err
+	}
+	if len(candidates) < 1 {
+		return "", false, ErrorMirrorVersionNotFound
+	}
+	return candidates[0].source.ExpectedSha256(version, candidates[0].path)
+}
+
+// AssetSidecar returns the sidecar of the first candidate.
+// The worker downloads and verifies candidates one by one through binSrcCandidates instead.
+func (z binSrcMirrorImpl) AssetSidecar(version es_version.Version, versionPath, extension string) (content []byte, found bool, err error) {
+	candidates, err := z.candidates(version, versionPath)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(candidates) < 1 {
+		return nil, false, ErrorMirrorVersionNotFound
+	}
+	return candidates[0].source.AssetSidecar(version, candidates[0].path, extension)
+}
+
+// RevocationList merges revocation lists of sources. The version revoked by any of sources is revoked.
+// Returns the merged list of available sources with ErrorRevocationListPartial, if lists of some sources are
+// not available, then the caller can merge the last known list, to not reinstate versions revoked by those sources.
+func (z binSrcMirrorImpl) RevocationList() (content []byte, found bool, err error) {
+	l := z.ctl.Log()
+	merged := &BinDeployRevocationList{Revoked: make([]BinDeployRevokedVersion, 0)}
+	available := 0
+	var lastErr error
+	for _, source := range z.sources {
+		ll := l.With(esl.String("source", source.Id()))
+		sourceContent, sourceFound, err := source.RevocationList()
+		if err != nil {
+			ll.Warn("Unable to retrieve the revocation list, try next source", esl.Error(err))
+			lastErr = err
+			continue
+		}
+		if !sourceFound {
+			available++
+			continue
+		}
+		revoked, err := utilRevocationParse(sourceContent)
+		if err != nil {
+			ll.Warn("Unable to parse the revocation list", esl.Error(err))
+			lastErr = err
+			continue
+		}
+		available++
+		found = true
+		merged.Revoked = append(merged.Revoked, revoked...)
+	}
+	if available < 1 {
+		l.Debug("No source available", esl.Error(lastErr))
+		return nil, false, lastErr
+	}
+	if lastErr != nil {
+		err = ErrorRevocationListPartial
+	}
+	if !found {
+		return nil, false, err
+	}
+	content, marshalErr := json.Marshal(merged)
+	if marshalErr != nil {
+		return nil, false, marshalErr
+	}
+	return content, true, err
+}
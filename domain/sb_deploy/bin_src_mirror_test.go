@@ -0,0 +1,152 @@
+package sb_deploy
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/watermint/toolbox/essentials/strings/es_version"
+	"github.com/watermint/toolbox/infra/control/app_control"
+	"github.com/watermint/toolbox/quality/recipe/qtr_endtoend"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBinSrcMirror(t *testing.T) {
+	qtr_endtoend.TestWithControl(t, func(ctl app_control.Control) {
+		unreachablePath := filepath.Join(t.TempDir(), "unreachable")
+		primaryPath := t.TempDir()
+		secondaryPath := t.TempDir()
+		testLocalDirSource(t, primaryPath, "linux-amd64", "1.0.0", "1.2.0")
+		testLocalDirSource(t, secondaryPath, "linux-amd64", "1.1.0", "1.2.0")
+
+		content, err := json.Marshal(map[string]interface{}{
+			"binary_name": "myapp",
+			"prefix":      "myapp",
+			"suffix":      "linux-amd64",
+			"cellar_path": filepath.Join(t.TempDir(), "cellar"),
+			"sources": []map[string]string{
+				{"source_type": SourceTypeLocalDir, "source_path": unreachablePath},
+				{"source_type": SourceTypeLocalDir, "source_path": primaryPath},
+				{"source_type": SourceTypeLocalDir, "source_path": secondaryPath},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		worker, err := NewBinDeployFromJson(ctl, content, "default")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		versions, _, _, err := worker.ListRemoteVersions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if latest := es_version.Max(versions...); len(versions) != 3 || latest.String() != "1.2.0" {
+			t.Error(versions)
+		}
+
+		// paths of candidates are displayed instead of the JSON
+		status, err := worker.ListRemoteVersionStatus()
+		if err != nil || len(status) != 3 {
+			t.Fatal(status, err)
+		}
+		if s := status[0]; s.Version != "1.2.0" || !s.Latest || strings.HasPrefix(s.Path, "[") ||
+			!strings.Contains(s.Path, primaryPath) || !strings.Contains(s.Path, secondaryPath) {
+			t.Error(s)
+		}
+
+		// the primary source lost the archive after the listing, then fall back to the secondary
+		if err := os.RemoveAll(filepath.Join(primaryPath, "myapp-1.2.0")); err != nil {
+			t.Fatal(err)
+		}
+		if err := worker.UpdateIfRequired(); err != nil {
+			t.Fatal(err)
+		}
+		if content, err := os.ReadFile(worker.LocalLatestBinaryPath()); err != nil || string(content) != "1.2.0" {
+			t.Error(string(content), err)
+		}
+	})
+}
+
+func TestBinSrcMirror_Verify(t *testing.T) {
+	qtr_endtoend.TestWithControl(t, func(ctl app_control.Control) {
+		primaryPath := t.TempDir()
+		secondaryPath := t.TempDir()
+		testLocalDirSource(t, primaryPath, "linux-amd64", "1.0.0", "1.1.0")
+		testLocalDirSource(t, secondaryPath, "linux-amd64", "1.0.0", "1.1.0")
+		assetPath := func(sourcePath, v string) string {
+			return filepath.Join(sourcePath, "myapp-"+v, "myapp-"+v+"-linux-amd64.zip")
+		}
+		// 1.0.0: no checksum in the primary, and the checksum of the other asset in the secondary
+		if err := os.WriteFile(assetPath(secondaryPath, "1.0.0")+ChecksumSidecarExtension, []byte(strings.Repeat("0", 64)+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		// 1.1.0: the broken checksum in the primary, and the valid checksum in the secondary
+		if err := os.WriteFile(assetPath(primaryPath, "1.1.0")+ChecksumSidecarExtension, []byte(strings.Repeat("0", 64)+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		digest, err := utilSha256File(assetPath(secondaryPath, "1.1.0"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(assetPath(secondaryPath, "1.1.0")+ChecksumSidecarExtension, []byte(digest+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		content, err := json.Marshal(map[string]interface{}{
+			"binary_name": "myapp",
+			"prefix":      "myapp",
+			"suffix":      "linux-amd64",
+			"cellar_path": filepath.Join(t.TempDir(), "cellar"),
+			"sources": []map[string]string{
+				{"source_type": SourceTypeLocalDir, "source_path": primaryPath},
+				{"source_type": SourceTypeLocalDir, "source_path": secondaryPath},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		worker, err := NewBinDeployFromJson(ctl, content, "default")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, versionPaths, _, err := worker.ListRemoteVersions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range []string{"1.0.0", "1.1.0"} {
+			if _, err := worker.Download(es_version.MustParse(v), versionPaths[v]); err != nil {
+				t.Error(v, err)
+			}
+		}
+
+		// no candidate is verified
+		if err := os.WriteFile(assetPath(secondaryPath, "1.1.0")+ChecksumSidecarExtension, []byte(strings.Repeat("0", 64)+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := worker.Download(es_version.MustParse("1.1.0"), versionPaths["1.1.0"]); !errors.Is(err, ErrorChecksumMismatch) {
+			t.Error(err)
+		}
+	})
+}
+
+func TestBinSrcMirror_NoSourceAvailable(t *testing.T) {
+	qtr_endtoend.TestWithControl(t, func(ctl app_control.Control) {
+		source := newBinSrcMirror(ctl, []BinSource{
+			NewBinSrcLocalDirSource(BinSrcLocalDirDstLocalRecipe{
+				SourcePath: filepath.Join(t.TempDir(), "unreachable1"),
+			}, ctl),
+			NewBinSrcLocalDirSource(BinSrcLocalDirDstLocalRecipe{
+				SourcePath: filepath.Join(t.TempDir(), "unreachable2"),
+			}, ctl),
+		})
+		if _, _, _, err := source.ListRemoteVersions(); err == nil {
+			t.Error("should fail")
+		}
+		if _, err := source.Download(es_version.MustParse("1.0.0"), "[]"); err != ErrorMirrorVersionNotFound {
+			t.Error(err)
+		}
+	})
+}
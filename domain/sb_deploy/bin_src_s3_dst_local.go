@@ -0,0 +1,322 @@
+package sb_deploy
+
+import (
+	"encoding/xml"
+	"github.com/watermint/toolbox/essentials/api/api_auth"
+	"github.com/watermint/toolbox/essentials/api/api_auth_basic"
+	"github.com/watermint/toolbox/essentials/api/api_conn_impl"
+	"github.com/watermint/toolbox/essentials/log/esl"
+	"github.com/watermint/toolbox/essentials/strings/es_version"
+	"github.com/watermint/toolbox/infra/control/app_control"
+	"github.com/watermint/toolbox/infra/ui/app_msg"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	SourceTypeS3          = "s3"
+	BinSrcS3AppKeyName    = "switchbox_s3"
+	BinSrcS3DefaultRegion = "us-east-1"
+)
+
+type MsgBinSrcS3 struct {
+	AskAccessKeyId     app_msg.Message
+	AskSecretAccessKey app_msg.Message
+}
+
+var (
+	MBinSrcS3 = app_msg.Apply(&MsgBinSrcS3{}).(*MsgBinSrcS3)
+)
+
+// BinSrcS3DstLocalRecipe Deploy binary from S3 compatible object storage to local
+// This recipe expect object keys like this:
+// `SOURCE_PREFIX/PREFIX-VERSION/PREFIX-VERSION-SUFFIX.zip`.
+// The bucket is accessed by the path-style url like `ENDPOINT/BUCKET/KEY`.
+// Credentials are stored in the secrets store of the toolbox, and not in the deploy JSON.
+type BinSrcS3DstLocalRecipe struct {
+	// Endpoint is the url of the storage, e.g. `https://s3.us-west-2.amazonaws.com` or `http://minio.example.com:9000`.
+	Endpoint string `json:"endpoint"`
+
+	// Region is the region of the bucket. If empty, `us-east-1` is used.
+	Region string `json:"region,omitempty"`
+
+	// Bucket is the name of the bucket
+	Bucket string `json:"bucket"`
+
+	// SourcePrefix is the key prefix of version folders, e.g. `releases/`. The trailing slash is optional.
+	SourcePrefix string `json:"source_prefix,omitempty"`
+
+	// Anonymous is true to access the public bucket without credentials.
+	Anonymous bool `json:"anonymous,omitempty"`
+
+	BinDstLocalRecipe
+}
+
+// BinSrcS3ListBucketResult is the subset of the response of ListObjectsV2.
+type BinSrcS3ListBucketResult struct {
+	XMLName               xml.Name                 `xml:"ListBucketResult"`
+	IsTruncated           bool                     `xml:"IsTruncated"`
+	NextContinuationToken string                   `xml:"NextContinuationToken"`
+	Contents              []BinSrcS3ListBucketItem `xml:"Contents"`
+}
+
+type BinSrcS3ListBucketItem struct {
+	Key  string `xml:"Key"`
+	Size int64  `xml:"Size"`
+}
+
+func init() {
+	RegisterBinSource(BinSourceRegistration{
+		SourceType: SourceTypeS3,
+		Recipe: func() interface{} {
+			return &BinSrcS3DstLocalRecipe{}
+		},
+		Auth: func(ctl app_control.Control, recipe interface{}, peerName string) (auth interface{}, err error) {
+			if recipe.(*BinSrcS3DstLocalRecipe).Anonymous {
+				return utilS3Credential{}, nil
+			}
+			return NewBinSrcS3Credential(ctl, peerName)
+		},
+		New: func(ctl app_control.Control, recipe interface{}, auth interface{}) (BinSource, error) {
+			return NewBinSrcS3Source(*recipe.(*BinSrcS3DstLocalRecipe), ctl, auth.(utilS3Credential)), nil
+		},
+	})
+}
+
+// NewBinSrcS3Credential retrieves the credential from the secrets store of the toolbox.
+// Asks the access key id and the secret access key if the credential of the peer is not found.
+func NewBinSrcS3Credential(ctl app_control.Control, peerName string) (cred utilS3Credential, err error) {
+	l := ctl.Log().With(esl.String("peerName", peerName))
+	session := api_auth.BasicSessionData{
+		AppData: api_auth.BasicAppData{
+			AppKeyName: BinSrcS3AppKeyName,
+		},
+		PeerName: peerName,
+	}
+	entity, mock, err := api_conn_impl.BasicConnect(
+		session,
+		ctl,
+		api_auth_basic.CustomAskUserName(MBinSrcS3.AskAccessKeyId),
+		api_auth_basic.CustomAskPassword(MBinSrcS3.AskSecretAccessKey),
+	)
+	if mock {
+		l.Debug("Test mode, use anonymous access")
+		return utilS3Credential{}, nil
+	}
+	if err != nil {
+		l.Debug("Unable to acquire the credential", esl.Error(err))
+		return utilS3Credential{}, err
+	}
+	return utilS3Credential{
+		AccessKeyId:     entity.Credential.Username,
+		SecretAccessKey: entity.Credential.Password,
+	}, nil
+}
+
+func NewBinSrcS3DstLocal(recipe BinSrcS3DstLocalRecipe, ctl app_control.Control, cred utilS3Credential) BinDeploy {
+	return newBinDstLocal(recipe.BinDstLocalRecipe, ctl, NewBinSrcS3Source(recipe, ctl, cred))
+}
+
+func NewBinSrcS3Source(recipe BinSrcS3DstLocalRecipe, ctl app_control.Control, cred utilS3Credential) BinSource {
+	// the source prefix is the folder of versions, regardless of the trailing slash.
+	if recipe.SourcePrefix != "" {
+		recipe.SourcePrefix = strings.TrimSuffix(recipe.SourcePrefix, "/") + "/"
+	}
+	return &binSrcS3Impl{
+		recipe: recipe,
+		ctl:    ctl,
+		cred:   cred,
+	}
+}
+
+type binSrcS3Impl struct {
+	recipe BinSrcS3DstLocalRecipe
+	ctl    app_control.Control
+	cred   utilS3Credential
+}
+
+func (z binSrcS3Impl) region() string {
+	if z.recipe.Region == "" {
+		return BinSrcS3DefaultRegion
+	}
+	return z.recipe.Region
+}
+
+func (z binSrcS3Impl) Id() string {
+	return strings.TrimSuffix(z.recipe.Endpoint, "/") + "/" + z.recipe.Bucket + "/" + z.recipe.SourcePrefix
+}
+
+// objectUrl returns the url of the object, or the bucket if the key is empty.
+func (z binSrcS3Impl) objectUrl(key string, query url.Values) (*url.URL, error) {
+	u := strings.TrimSuffix(z.recipe.Endpoint, "/") + "/" + utilS3UriEncode(z.recipe.Bucket, true)
+	if key != "" {
+		u += "/" + utilS3UriEncode(key, false)
+	}
+	if len(query) > 0 {
+		u += "?" + utilS3CanonicalQuery(query)
+	}
+	return url.Parse(u)
+}
+
+func (z binSrcS3Impl) listObjects(prefix string) (items []BinSrcS3ListBucketItem, err error) {
+	l := z.ctl.Log().With(esl.String("source", z.Id()), esl.String("prefix", prefix))
+	items = make([]BinSrcS3ListBucketItem, 0)
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", prefix)
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		u, err := z.objectUrl("", query)
+		if err != nil {
+			l.Debug("Unable to compose the url", esl.Error(err))
+			return nil, err
+		}
+		content, err := utilHttpGet(z.ctl, u.String(), utilS3SignHeaders(z.cred, z.region(), u, time.Now()))
+		if err != nil {
+			l.Debug("Unable to list objects", esl.Error(err))
+			return nil, err
+		}
+		result := &BinSrcS3ListBucketResult{}
+		if err := xml.Unmarshal(content, result); err != nil {
+			l.Debug("Unable to parse the result", esl.Error(err))
+			return nil, err
+		}
+		items = append(items, result.Contents...)
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return items, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (z binSrcS3Impl) ListRemoteVersions() (versions []es_version.Version, versionPaths map[string]string, metas map[string]BinRemoteVersionMeta, err error) {
+	l := z.ctl.Log().With(esl.String("source", z.Id()))
+	versions = make([]es_version.Version, 0)
+	versionPaths = make(map[string]string)
+	metas = make(map[string]BinRemoteVersionMeta)
+
+	items, err := z.listObjects(z.recipe.SourcePrefix + z.recipe.Prefix + "-")
+	if err != nil {
+		return versions, versionPaths, metas, err
+	}
+	for _, item := range items {
+		rel := strings.TrimPrefix(item.Key, z.recipe.SourcePrefix)
+		folderName, fileName := path.Split(rel)
+		folderName = strings.TrimSuffix(folderName, "/")
+		if folderName == "" || strings.Contains(folderName, "/") {
+			l.Debug("Skip object", esl.String("key", item.Key))
+			continue
+		}
+		if utilSourceParseMarker(z.recipe.Prefix, folderName, fileName, metas) {
+			l.Debug("Found marker", esl.String("key", item.Key))
+			continue
+		}
+		ver, ok := utilSourceParseVersion(z.recipe.Prefix, z.recipe.Suffix, folderName, fileName)
+		if _, dup := versionPaths[ver.String()]; !ok || dup {
+			l.Debug("Skip object", esl.String("key", item.Key))
+			continue
+		}
+		l.Debug("Found version", esl.String("version", ver.String()), esl.String("key", item.Key))
+		versions = append(versions, ver)
+		versionPaths[ver.String()] = item.Key
+	}
+	return versions, versionPaths, metas, nil
+}
+
+func (z binSrcS3Impl) Download(version es_version.Version, versionPath string) (downloadPath string, err error) {
+	l := z.ctl.Log().With(esl.String("version", version.String()), esl.String("versionPath", versionPath))
+	l.Debug("Download version")
+
+	u, err := z.objectUrl(versionPath, url.Values{})
+	if err != nil {
+		l.Debug("Unable to compose the url", esl.Error(err))
+		return "", err
+	}
+	downloadPath, err = utilHttpDownload(z.ctl, u.String(), path.Base(versionPath), utilS3SignHeaders(z.cred, z.region(), u, time.Now()))
+	if err != nil {
+		l.Debug("Unable to download version", esl.Error(err))
+		return "", err
+	}
+	l.Info("Downloaded", esl.String("path", downloadPath))
+	return downloadPath, nil
+}
+
+// sidecars lists objects in the version folder, then returns the function that retrieves the object in the folder.
+func (z binSrcS3Impl) sidecars(versionPath string) (fetch func(name string) (content []byte, found bool, err error), err error) {
+	l := z.ctl.Log().With(esl.String("versionPath", versionPath))
+	folderKey := path.Dir(versionPath) + "/"
+	// list objects instead of GET, because the bucket may respond 403 instead of 404 for the missing object.
+	items, err := z.listObjects(folderKey)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]bool)
+	for _, item := range items {
+		keys[item.Key] = true
+	}
+	return func(name string) (content []byte, found bool, err error) {
+		key := folderKey + name
+		if !keys[key] {
+			return nil, false, nil
+		}
+		u, err := z.objectUrl(key, url.Values{})
+		if err != nil {
+			l.Debug("Unable to compose the url", esl.Error(err))
+			return nil, false, err
+		}
+		content, err = utilHttpGet(z.ctl, u.String(), utilS3SignHeaders(z.cred, z.region(), u, time.Now()))
+		if err != nil {
+			l.Debug("Unable to retrieve the sidecar", esl.String("key", key), esl.Error(err))
+			return nil, false, err
+		}
+		return content, true, nil
+	}, nil
+}
+
+func (z binSrcS3Impl) ExpectedSha256(version es_version.Version, versionPath string) (digest string, found bool, err error) {
+	fetch, err := z.sidecars(versionPath)
+	if err != nil {
+		return "", false, err
+	}
+	return utilChecksumFromSidecars(path.Base(versionPath), fetch)
+}
+
+func (z binSrcS3Impl) AssetSidecar(version es_version.Version, versionPath, extension string) (content []byte, found bool, err error) {
+	fetch, err := z.sidecars(versionPath)
+	if err != nil {
+		return nil, false, err
+	}
+	return fetch(path.Base(versionPath) + extension)
+}
+
+func (z binSrcS3Impl) RevocationList() (content []byte, found bool, err error) {
+	l := z.ctl.Log().With(esl.String("source", z.Id()))
+	key := z.recipe.SourcePrefix + RevocationListName
+	// list objects instead of GET, because the bucket may respond 403 instead of 404 for the missing object.
+	items, err := z.listObjects(key)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, item := range items {
+		if item.Key != key {
+			continue
+		}
+		u, err := z.objectUrl(key, url.Values{})
+		if err != nil {
+			l.Debug("Unable to compose the url", esl.Error(err))
+			return nil, false, err
+		}
+		content, err = utilHttpGet(z.ctl, u.String(), utilS3SignHeaders(z.cred, z.region(), u, time.Now()))
+		if err != nil {
+			l.Debug("Unable to retrieve the revocation list", esl.Error(err))
+			return nil, false, err
+		}
+		return content, true, nil
+	}
+	return nil, false, nil
+}
@@ -0,0 +1,117 @@
+package sb_deploy
+
+import (
+	"encoding/xml"
+	"github.com/watermint/toolbox/essentials/strings/es_version"
+	"github.com/watermint/toolbox/infra/control/app_control"
+	"github.com/watermint/toolbox/quality/recipe/qtr_endtoend"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestBinSrcS3DstLocal(t *testing.T) {
+	qtr_endtoend.TestWithControl(t, func(ctl app_control.Control) {
+		archiveDir := t.TempDir()
+		objects := map[string]string{}
+		for _, v := range []string{"1.0.0", "1.2.0"} {
+			key := "releases/myapp-" + v + "/myapp-" + v + "-linux-amd64.zip"
+			archivePath := filepath.Join(archiveDir, filepath.Base(key))
+			testZipArchive(t, archivePath, map[string]string{
+				utilBinaryName("myapp"): v,
+			})
+			objects[key] = archivePath
+		}
+		objects["releases/myapp-2.0.0/myapp-2.0.0-darwin-arm64.zip"] = ""
+		objects["releases/myapp-2.0.0/nested/myapp-2.0.0-linux-amd64.zip"] = ""
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("x-amz-date") == "" || !strings.HasPrefix(r.Header.Get("Authorization"), utilS3Algorithm) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			if r.URL.Path == "/bucket" && r.URL.Query().Get("list-type") == "2" {
+				prefix := r.URL.Query().Get("prefix")
+				// return one object per page to exercise the pagination
+				token := r.URL.Query().Get("continuation-token")
+				keys := make([]string, 0)
+				for k := range objects {
+					if strings.HasPrefix(k, prefix) {
+						keys = append(keys, k)
+					}
+				}
+				sort.Strings(keys)
+				result := BinSrcS3ListBucketResult{}
+				for i, k := range keys {
+					if token != "" && k != token {
+						continue
+					}
+					result.Contents = append(result.Contents, BinSrcS3ListBucketItem{Key: k})
+					if i+1 < len(keys) {
+						result.IsTruncated = true
+						result.NextContinuationToken = keys[i+1]
+					}
+					break
+				}
+				_ = xml.NewEncoder(w).Encode(result)
+				return
+			}
+			key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+			if p, ok := objects[key]; ok && p != "" {
+				http.ServeFile(w, r, p)
+				return
+			}
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer server.Close()
+
+		cellarPath := filepath.Join(t.TempDir(), "cellar")
+		worker := NewBinSrcS3DstLocal(BinSrcS3DstLocalRecipe{
+			Endpoint:     server.URL,
+			Bucket:       "bucket",
+			SourcePrefix: "releases/",
+			BinDstLocalRecipe: BinDstLocalRecipe{
+				BinaryName: "myapp",
+				Prefix:     "myapp",
+				Suffix:     "linux-amd64",
+				CellarPath: cellarPath,
+			},
+		}, ctl, utilS3Credential{AccessKeyId: "AKIDEXAMPLE", SecretAccessKey: "SECRET"})
+
+		versions, versionPaths, _, err := worker.ListRemoteVersions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if latest := es_version.Max(versions...); len(versions) != 2 || latest.String() != "1.2.0" {
+			t.Error(versions)
+		}
+		if p := versionPaths["1.2.0"]; p != "releases/myapp-1.2.0/myapp-1.2.0-linux-amd64.zip" {
+			t.Error(p)
+		}
+
+		// the source prefix without the trailing slash
+		source := NewBinSrcS3Source(BinSrcS3DstLocalRecipe{
+			Endpoint:     server.URL,
+			Bucket:       "bucket",
+			SourcePrefix: "releases",
+			BinDstLocalRecipe: BinDstLocalRecipe{
+				Prefix: "myapp",
+				Suffix: "linux-amd64",
+			},
+		}, ctl, utilS3Credential{AccessKeyId: "AKIDEXAMPLE", SecretAccessKey: "SECRET"})
+		if versions, versionPaths, _, err := source.ListRemoteVersions(); err != nil || len(versions) != 2 || versionPaths["1.2.0"] != "releases/myapp-1.2.0/myapp-1.2.0-linux-amd64.zip" {
+			t.Error(versions, versionPaths, err)
+		}
+
+		if err := worker.UpdateIfRequired(); err != nil {
+			t.Fatal(err)
+		}
+		if content, err := os.ReadFile(worker.LocalLatestBinaryPath()); err != nil || string(content) != "1.2.0" {
+			t.Error(string(content), err)
+		}
+	})
+}
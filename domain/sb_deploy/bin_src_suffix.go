@@ -0,0 +1,18 @@
+package sb_deploy
+
+// newBinSrcSuffix wraps the source of the suffix, to distinguish sources of fallback suffixes in the mirror.
+func newBinSrcSuffix(source BinSource, suffix string) BinSource {
+	return &binSrcSuffixImpl{
+		BinSource: source,
+		suffix:    suffix,
+	}
+}
+
+type binSrcSuffixImpl struct {
+	BinSource
+	suffix string
+}
+
+func (z binSrcSuffixImpl) Id() string {
+	return z.BinSource.Id() + "#" + z.suffix
+}
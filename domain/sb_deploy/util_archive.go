@@ -0,0 +1,383 @@
+package sb_deploy
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"github.com/watermint/toolbox/essentials/log/esl"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// ArchiveFormatAuto detects the format by the extension, then by magic bytes.
+	// The file is treated as the raw binary if no archive format is detected.
+	ArchiveFormatAuto   = ""
+	ArchiveFormatZip    = "zip"
+	ArchiveFormatTarGz  = "tar.gz"
+	ArchiveFormatTarXz  = "tar.xz"
+	ArchiveFormatTarZst = "tar.zst"
+	ArchiveFormatRaw    = "raw"
+)
+
+var (
+	ErrorUnknownArchiveFormat = errors.New("unknown archive format")
+	ErrorUnsafeArchiveEntry   = errors.New("archive entry points outside of the destination")
+)
+
+var (
+	// utilArchiveExtensions is the list of extensions of supported archives.
+	// Longer extensions first, to match `.tar.gz` before `.gz`.
+	utilArchiveExtensions = []struct {
+		Extension string
+		Format    string
+	}{
+		{".tar.gz", ArchiveFormatTarGz},
+		{".tar.xz", ArchiveFormatTarXz},
+		{".tar.zst", ArchiveFormatTarZst},
+		{".tgz", ArchiveFormatTarGz},
+		{".txz", ArchiveFormatTarXz},
+		{".tzst", ArchiveFormatTarZst},
+		{".zip", ArchiveFormatZip},
+	}
+
+	utilArchiveMagics = []struct {
+		Magic  []byte
+		Format string
+	}{
+		{[]byte("PK\x03\x04"), ArchiveFormatZip},
+		{[]byte{0x1f, 0x8b}, ArchiveFormatTarGz},
+		{[]byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, ArchiveFormatTarXz},
+		{[]byte{0x28, 0xb5, 0x2f, 0xfd}, ArchiveFormatTarZst},
+	}
+)
+
+// utilArchiveFormatByName returns the format of the archive by the extension of the name.
+func utilArchiveFormatByName(name string) (format string, found bool) {
+	lowerName := strings.ToLower(name)
+	for _, ext := range utilArchiveExtensions {
+		if strings.HasSuffix(lowerName, ext.Extension) {
+			return ext.Format, true
+		}
+	}
+	return "", false
+}
+
+// utilArchiveFormatByMagic returns the format of the archive by magic bytes of the file.
+func utilArchiveFormatByMagic(path string) (format string, found bool, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", false, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	header := make([]byte, 8)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", false, err
+	}
+	for _, m := range utilArchiveMagics {
+		if bytes.HasPrefix(header[:n], m.Magic) {
+			return m.Format, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// utilArchiveDetect returns the format of the file. Returns the format as is, if the format is not auto.
+func utilArchiveDetect(path string, format string) (string, error) {
+	switch format {
+	case ArchiveFormatZip, ArchiveFormatTarGz, ArchiveFormatTarXz, ArchiveFormatTarZst, ArchiveFormatRaw:
+		return format, nil
+	case ArchiveFormatAuto:
+		if f, found := utilArchiveFormatByName(filepath.Base(path)); found {
+			return f, nil
+		}
+		f, found, err := utilArchiveFormatByMagic(path)
+		if err != nil {
+			return "", err
+		}
+		if found {
+			return f, nil
+		}
+		return ArchiveFormatRaw, nil
+	default:
+		return "", ErrorUnknownArchiveFormat
+	}
+}
+
+// utilArchiveExtract extracts the archive into the destination directory.
+// The raw binary is placed into the destination as the binary name.
+// Entries are validated by utilArchiveGuard, and the extraction stops at the first rejected entry with ArchiveEntryError.
+func utilArchiveExtract(l esl.Logger, archivePath, format, destPath, binName string, limits ArchiveLimits) error {
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		l.Debug("Unable to stat the archive", esl.Error(err))
+		return err
+	}
+	guard := newUtilArchiveGuard(destPath, info.Size(), limits)
+	switch format {
+	case ArchiveFormatZip:
+		return utilArchiveExtractZip(l, archivePath, guard)
+	case ArchiveFormatRaw:
+		return utilArchivePlaceRaw(archivePath, filepath.Join(destPath, binName))
+	case ArchiveFormatTarGz, ArchiveFormatTarXz, ArchiveFormatTarZst:
+		return utilArchiveExtractTar(l, archivePath, format, guard)
+	default:
+		return ErrorUnknownArchiveFormat
+	}
+}
+
+func utilArchivePlaceRaw(archivePath, binPath string) error {
+	src, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = src.Close()
+	}()
+	dst, err := os.OpenFile(binPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+func utilArchiveDecompress(r io.Reader, format string) (io.ReadCloser, error) {
+	switch format {
+	case ArchiveFormatTarGz:
+		return gzip.NewReader(r)
+	case ArchiveFormatTarXz:
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xr), nil
+	case ArchiveFormatTarZst:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return nil, ErrorUnknownArchiveFormat
+	}
+}
+
+// utilArchiveEntryPath returns the path of the entry in the destination.
+// Returns ErrorUnsafeArchiveEntry if the entry points outside of the destination.
+func utilArchiveEntryPath(destPath, name string) (string, error) {
+	entryPath := filepath.Join(destPath, filepath.FromSlash(name))
+	rel, err := filepath.Rel(destPath, entryPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", ErrorUnsafeArchiveEntry
+	}
+	return entryPath, nil
+}
+
+// utilArchiveWriteFile writes the content of the entry with the mode.
+func utilArchiveWriteFile(name, entryPath string, mode os.FileMode, r io.Reader, guard *utilArchiveGuard) error {
+	if err := os.MkdirAll(filepath.Dir(entryPath), 0755); err != nil {
+		return err
+	}
+	// remove the existing file or symlink, to not write through the symlink.
+	if err := os.Remove(entryPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	ef, err := os.OpenFile(entryPath, os.O_CREATE|os.O_WRONLY|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	if err := guard.copy(name, ef, r); err != nil {
+		_ = ef.Close()
+		return err
+	}
+	if err := ef.Close(); err != nil {
+		return err
+	}
+	// the mode is masked by umask on creation
+	return os.Chmod(entryPath, mode)
+}
+
+// utilArchiveWriteSymlink creates the symlink after validating the target.
+func utilArchiveWriteSymlink(name, entryPath, target string, guard *utilArchiveGuard) error {
+	if err := guard.symlink(name, entryPath, target); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(entryPath), 0755); err != nil {
+		return err
+	}
+	_ = os.Remove(entryPath)
+	return os.Symlink(target, entryPath)
+}
+
+// utilArchiveExtractZip extracts the zip archive with file modes and symbolic links.
+func utilArchiveExtractZip(l esl.Logger, archivePath string, guard *utilArchiveGuard) error {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		l.Debug("Unable to open the archive", esl.Error(err))
+		return err
+	}
+	defer func() {
+		_ = zr.Close()
+	}()
+
+	for _, zf := range zr.File {
+		ll := l.With(esl.String("name", zf.Name))
+		entryPath, err := guard.entry(zf.Name)
+		if err != nil {
+			ll.Debug("Rejected entry", esl.Error(err))
+			return err
+		}
+		mode := zf.Mode()
+
+		switch {
+		case mode.IsDir():
+			if err := os.MkdirAll(entryPath, mode.Perm()|0700); err != nil {
+				ll.Debug("Unable to create the directory", esl.Error(err))
+				return err
+			}
+
+		case mode&os.ModeSymlink != 0:
+			target, err := utilArchiveZipContent(zf, archiveMaxSymlinkTargetSize)
+			if err != nil {
+				ll.Debug("Unable to read the symlink target", esl.Error(err))
+				return err
+			}
+			if err := utilArchiveWriteSymlink(zf.Name, entryPath, string(target), guard); err != nil {
+				ll.Debug("Unable to create the symlink", esl.Error(err))
+				return err
+			}
+
+		case mode.IsRegular():
+			perm := mode.Perm()
+			if perm == 0 {
+				// the archive created without unix attributes
+				perm = 0644
+			}
+			rc, err := zf.Open()
+			if err != nil {
+				ll.Debug("Unable to open the entry", esl.Error(err))
+				return err
+			}
+			err = utilArchiveWriteFile(zf.Name, entryPath, perm, rc, guard)
+			_ = rc.Close()
+			if err != nil {
+				ll.Debug("Unable to extract the file", esl.Error(err))
+				return err
+			}
+
+		default:
+			ll.Debug("Special file", esl.String("mode", mode.String()))
+			return guard.special(zf.Name)
+		}
+	}
+	return nil
+}
+
+func utilArchiveZipContent(zf *zip.File, maxSize int64) ([]byte, error) {
+	rc, err := zf.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+	content, err := io.ReadAll(io.LimitReader(rc, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > maxSize {
+		return nil, &ArchiveEntryError{Name: zf.Name, Err: ErrorArchiveUnsafeSymlink}
+	}
+	return content, nil
+}
+
+// utilArchiveExtractTar extracts the tar archive with file modes and symbolic links.
+func utilArchiveExtractTar(l esl.Logger, archivePath, format string, guard *utilArchiveGuard) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		l.Debug("Unable to open the archive", esl.Error(err))
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	r, err := utilArchiveDecompress(f, format)
+	if err != nil {
+		l.Debug("Unable to decompress the archive", esl.Error(err))
+		return err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			l.Debug("Unable to read the archive", esl.Error(err))
+			return err
+		}
+		ll := l.With(esl.String("name", header.Name))
+		entryPath, err := guard.entry(header.Name)
+		if err != nil {
+			ll.Debug("Rejected entry", esl.Error(err))
+			return err
+		}
+		mode := header.FileInfo().Mode().Perm()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(entryPath, mode|0700); err != nil {
+				ll.Debug("Unable to create the directory", esl.Error(err))
+				return err
+			}
+
+		case tar.TypeReg:
+			if err := utilArchiveWriteFile(header.Name, entryPath, mode, tr, guard); err != nil {
+				ll.Debug("Unable to extract the file", esl.Error(err))
+				return err
+			}
+
+		case tar.TypeSymlink:
+			if err := utilArchiveWriteSymlink(header.Name, entryPath, header.Linkname, guard); err != nil {
+				ll.Debug("Unable to create the symlink", esl.Error(err))
+				return err
+			}
+
+		case tar.TypeLink:
+			linkPath, err := guard.hardlink(header.Name, header.Linkname)
+			if err != nil {
+				ll.Debug("Unsafe link", esl.Error(err))
+				return err
+			}
+			_ = os.Remove(entryPath)
+			if err := os.Link(linkPath, entryPath); err != nil {
+				ll.Debug("Unable to create the hard link", esl.Error(err))
+				return err
+			}
+
+		case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+			ll.Debug("Special file", esl.Int("type", int(header.Typeflag)))
+			return guard.special(header.Name)
+
+		default:
+			ll.Debug("Skip unsupported entry", esl.Int("type", int(header.Typeflag)))
+		}
+	}
+}
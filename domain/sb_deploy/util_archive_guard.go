@@ -0,0 +1,225 @@
+package sb_deploy
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ArchiveDefaultMaxTotalSize        = 4 * 1024 * 1024 * 1024
+	ArchiveDefaultMaxEntries          = 100000
+	ArchiveDefaultMaxCompressionRatio = 200
+
+	// archiveCompressionRatioFloor is the extracted size that is always allowed regardless of the compression ratio,
+	// for small archives that contain highly compressible files.
+	archiveCompressionRatioFloor = 16 * 1024 * 1024
+
+	// archiveMaxSymlinkTargetSize is the maximum size of the symlink target in zip archives.
+	archiveMaxSymlinkTargetSize = 4096
+)
+
+var (
+	ErrorArchiveAbsolutePath     = errors.New("archive entry has the absolute path")
+	ErrorArchiveSpecialFile      = errors.New("archive entry is the device or special file")
+	ErrorArchiveUnsafeSymlink    = errors.New("archive entry is the symlink that points outside of the destination")
+	ErrorArchiveThroughSymlink   = errors.New("archive entry is placed through the symlink")
+	ErrorArchiveTooManyEntries   = errors.New("archive exceeds the limit of the number of entries")
+	ErrorArchiveTooLarge         = errors.New("archive exceeds the limit of the total extracted size")
+	ErrorArchiveCompressionRatio = errors.New("archive exceeds the limit of the compression ratio")
+)
+
+// ArchiveEntryError is the error of the archive entry rejected by the extraction.
+type ArchiveEntryError struct {
+	// Name is the name of the entry in the archive.
+	Name string
+
+	// Err is the reason, such as ErrorUnsafeArchiveEntry or ErrorArchiveTooLarge.
+	Err error
+}
+
+func (z *ArchiveEntryError) Error() string {
+	return z.Err.Error() + ": " + z.Name
+}
+
+func (z *ArchiveEntryError) Unwrap() error {
+	return z.Err
+}
+
+// ArchiveLimits is limits of the extraction to protect from archive bombs.
+// Zero value of the field means the default limit.
+type ArchiveLimits struct {
+	// MaxTotalSize is the maximum total size of extracted files in bytes.
+	MaxTotalSize int64 `json:"max_total_size,omitempty"`
+
+	// MaxEntries is the maximum number of entries in the archive.
+	MaxEntries int `json:"max_entries,omitempty"`
+
+	// MaxCompressionRatio is the maximum ratio of the total extracted size to the archive size.
+	MaxCompressionRatio int64 `json:"max_compression_ratio,omitempty"`
+}
+
+// withDefaults returns limits that zero fields are replaced with defaults.
+func (z ArchiveLimits) withDefaults() ArchiveLimits {
+	if z.MaxTotalSize <= 0 {
+		z.MaxTotalSize = ArchiveDefaultMaxTotalSize
+	}
+	if z.MaxEntries <= 0 {
+		z.MaxEntries = ArchiveDefaultMaxEntries
+	}
+	if z.MaxCompressionRatio <= 0 {
+		z.MaxCompressionRatio = ArchiveDefaultMaxCompressionRatio
+	}
+	return z
+}
+
+func newUtilArchiveGuard(destPath string, archiveSize int64, limits ArchiveLimits) *utilArchiveGuard {
+	return &utilArchiveGuard{
+		destPath:    destPath,
+		archiveSize: archiveSize,
+		limits:      limits.withDefaults(),
+	}
+}
+
+// utilArchiveGuard validates entries of the archive, and counts the number of entries and the extracted size.
+type utilArchiveGuard struct {
+	destPath    string
+	archiveSize int64
+	limits      ArchiveLimits
+	entries     int
+	total       int64
+}
+
+// entry validates the name of the entry, then returns the path of the entry in the destination.
+func (z *utilArchiveGuard) entry(name string) (entryPath string, err error) {
+	z.entries++
+	if z.entries > z.limits.MaxEntries {
+		return "", &ArchiveEntryError{Name: name, Err: ErrorArchiveTooManyEntries}
+	}
+	if utilArchiveIsAbsolute(name) {
+		return "", &ArchiveEntryError{Name: name, Err: ErrorArchiveAbsolutePath}
+	}
+	entryPath, err = utilArchiveEntryPath(z.destPath, name)
+	if err != nil {
+		return "", &ArchiveEntryError{Name: name, Err: err}
+	}
+	// the path string is not enough, a chain of symlinks extracted earlier may point outside of the destination.
+	if through, err := z.throughSymlink(filepath.Dir(entryPath)); err != nil {
+		return "", err
+	} else if through {
+		return "", &ArchiveEntryError{Name: name, Err: ErrorArchiveThroughSymlink}
+	}
+	return entryPath, nil
+}
+
+// throughSymlink returns true if the path in the destination contains the existing symlink, including the path itself.
+func (z *utilArchiveGuard) throughSymlink(path string) (bool, error) {
+	rel, err := filepath.Rel(z.destPath, path)
+	if err != nil {
+		return false, err
+	}
+	current := z.destPath
+	for _, component := range strings.Split(rel, string(filepath.Separator)) {
+		if component == "." || component == "" {
+			continue
+		}
+		current = filepath.Join(current, component)
+		info, err := os.Lstat(current)
+		switch {
+		case os.IsNotExist(err):
+			return false, nil
+		case err != nil:
+			return false, err
+		case info.Mode()&os.ModeSymlink != 0:
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// symlink validates the target of the symlink. The target must be relative and stay in the destination.
+// `..` is allowed only at the beginning of the target, and the target must not go through other symlinks,
+// to not escape from the destination by the chain of symlinks.
+func (z *utilArchiveGuard) symlink(name, entryPath, target string) error {
+	if target == "" || utilArchiveIsAbsolute(target) {
+		return &ArchiveEntryError{Name: name, Err: ErrorArchiveUnsafeSymlink}
+	}
+	named := false
+	for _, component := range strings.Split(filepath.ToSlash(target), "/") {
+		switch component {
+		case "", ".":
+		case "..":
+			if named {
+				return &ArchiveEntryError{Name: name, Err: ErrorArchiveUnsafeSymlink}
+			}
+		default:
+			named = true
+		}
+	}
+	targetPath := filepath.Join(filepath.Dir(entryPath), filepath.FromSlash(target))
+	rel, err := filepath.Rel(z.destPath, targetPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return &ArchiveEntryError{Name: name, Err: ErrorArchiveUnsafeSymlink}
+	}
+	if through, err := z.throughSymlink(targetPath); err != nil {
+		return err
+	} else if through {
+		return &ArchiveEntryError{Name: name, Err: ErrorArchiveUnsafeSymlink}
+	}
+	return nil
+}
+
+// hardlink validates the target of the hard link, then returns the path of the target in the destination.
+func (z *utilArchiveGuard) hardlink(name, target string) (targetPath string, err error) {
+	if utilArchiveIsAbsolute(target) {
+		return "", &ArchiveEntryError{Name: name, Err: ErrorArchiveAbsolutePath}
+	}
+	targetPath, err = utilArchiveEntryPath(z.destPath, target)
+	if err != nil {
+		return "", &ArchiveEntryError{Name: name, Err: err}
+	}
+	if through, err := z.throughSymlink(targetPath); err != nil {
+		return "", err
+	} else if through {
+		return "", &ArchiveEntryError{Name: name, Err: ErrorArchiveThroughSymlink}
+	}
+	return targetPath, nil
+}
+
+// special returns the error for device files, named pipes or other special files.
+func (z *utilArchiveGuard) special(name string) error {
+	return &ArchiveEntryError{Name: name, Err: ErrorArchiveSpecialFile}
+}
+
+// copy copies the content of the entry, and stops when the total extracted size exceeds limits.
+func (z *utilArchiveGuard) copy(name string, dst io.Writer, src io.Reader) error {
+	limit, reason := z.limits.MaxTotalSize, ErrorArchiveTooLarge
+	ratioLimit := z.archiveSize * z.limits.MaxCompressionRatio
+	if ratioLimit < archiveCompressionRatioFloor {
+		ratioLimit = archiveCompressionRatioFloor
+	}
+	if ratioLimit < limit {
+		limit, reason = ratioLimit, ErrorArchiveCompressionRatio
+	}
+	remaining := limit - z.total
+	n, err := io.CopyN(dst, src, remaining+1)
+	z.total += n
+	if n > remaining {
+		return &ArchiveEntryError{Name: name, Err: reason}
+	}
+	if err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// utilArchiveIsAbsolute returns true if the name is the absolute path on any platform.
+func utilArchiveIsAbsolute(name string) bool {
+	if strings.HasPrefix(name, "/") || strings.HasPrefix(name, "\\") || filepath.IsAbs(name) {
+		return true
+	}
+	// drive letter, like `C:` or `C:\`
+	return len(name) >= 2 && name[1] == ':' && ((name[0] >= 'a' && name[0] <= 'z') || (name[0] >= 'A' && name[0] <= 'Z'))
+}
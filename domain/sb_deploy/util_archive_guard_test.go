@@ -0,0 +1,203 @@
+package sb_deploy
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"github.com/watermint/toolbox/essentials/log/esl"
+	"github.com/watermint/toolbox/essentials/strings/es_version"
+	"github.com/watermint/toolbox/infra/control/app_control"
+	"github.com/watermint/toolbox/quality/recipe/qtr_endtoend"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestUtilArchiveExtract_Guard(t *testing.T) {
+	cases := []struct {
+		entries  []testTarEntry
+		limits   ArchiveLimits
+		expected error
+	}{
+		{[]testTarEntry{{Name: "/etc/myapp", Content: "abs", Mode: 0644, Type: tar.TypeReg}}, ArchiveLimits{}, ErrorArchiveAbsolutePath},
+		{[]testTarEntry{{Name: "lib/../../escape", Content: "escape", Mode: 0644, Type: tar.TypeReg}}, ArchiveLimits{}, ErrorUnsafeArchiveEntry},
+		{[]testTarEntry{{Name: "myapp", Type: tar.TypeSymlink, Linkname: "/usr/bin/myapp"}}, ArchiveLimits{}, ErrorArchiveUnsafeSymlink},
+		{[]testTarEntry{{Name: "lib/myapp", Type: tar.TypeSymlink, Linkname: "../../myapp"}}, ArchiveLimits{}, ErrorArchiveUnsafeSymlink},
+		{[]testTarEntry{{Name: "fifo", Mode: 0644, Type: tar.TypeFifo}}, ArchiveLimits{}, ErrorArchiveSpecialFile},
+		{[]testTarEntry{{Name: "tty", Mode: 0644, Type: tar.TypeChar}}, ArchiveLimits{}, ErrorArchiveSpecialFile},
+		{[]testTarEntry{
+			{Name: "a", Content: "a", Mode: 0644, Type: tar.TypeReg},
+			{Name: "b", Content: "b", Mode: 0644, Type: tar.TypeReg},
+		}, ArchiveLimits{MaxEntries: 1}, ErrorArchiveTooManyEntries},
+		{[]testTarEntry{{Name: "myapp", Content: strings.Repeat("x", 1024), Mode: 0755, Type: tar.TypeReg}}, ArchiveLimits{MaxTotalSize: 1000}, ErrorArchiveTooLarge},
+		{[]testTarEntry{{Name: "myapp", Content: strings.Repeat("\x00", archiveCompressionRatioFloor+1), Mode: 0755, Type: tar.TypeReg}}, ArchiveLimits{}, ErrorArchiveCompressionRatio},
+	}
+	for i, c := range cases {
+		dir := t.TempDir()
+		archivePath := filepath.Join(dir, "archive.tar.gz")
+		testTarArchive(t, archivePath, ArchiveFormatTarGz, c.entries)
+		destPath := filepath.Join(dir, "dest")
+		if err := os.MkdirAll(destPath, 0755); err != nil {
+			t.Fatal(err)
+		}
+		err := utilArchiveExtract(esl.Default(), archivePath, ArchiveFormatTarGz, destPath, "myapp", c.limits)
+		var entryErr *ArchiveEntryError
+		if !errors.Is(err, c.expected) || !errors.As(err, &entryErr) || entryErr.Name != c.entries[len(c.entries)-1].Name {
+			t.Error(i, err)
+		}
+	}
+}
+
+func TestUtilArchiveExtract_SymlinkChain(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlink is not supported")
+	}
+	cases := []struct {
+		entries  []testTarEntry
+		expected error
+	}{
+		// `d/..` is the destination as the path string, but the parent of the destination on the disk
+		{[]testTarEntry{
+			{Name: "d", Type: tar.TypeSymlink, Linkname: "."},
+			{Name: "d/s", Type: tar.TypeSymlink, Linkname: ".."},
+		}, ErrorArchiveThroughSymlink},
+		{[]testTarEntry{
+			{Name: "d", Type: tar.TypeSymlink, Linkname: "."},
+			{Name: "d/evil", Content: "evil", Mode: 0644, Type: tar.TypeReg},
+		}, ErrorArchiveThroughSymlink},
+		{[]testTarEntry{
+			{Name: "d", Type: tar.TypeSymlink, Linkname: "."},
+			{Name: "s", Type: tar.TypeSymlink, Linkname: "d/.."},
+		}, ErrorArchiveUnsafeSymlink},
+		{[]testTarEntry{
+			{Name: "lib/myapp.real", Content: "real", Mode: 0755, Type: tar.TypeReg},
+			{Name: "lib/myapp", Type: tar.TypeSymlink, Linkname: "myapp.real"},
+			{Name: "myapp", Type: tar.TypeSymlink, Linkname: "lib/myapp"},
+		}, ErrorArchiveUnsafeSymlink},
+		{[]testTarEntry{
+			{Name: "d", Type: tar.TypeSymlink, Linkname: "."},
+			{Name: "h", Type: tar.TypeLink, Linkname: "d/myapp"},
+		}, ErrorArchiveThroughSymlink},
+	}
+	for i, c := range cases {
+		dir := t.TempDir()
+		archivePath := filepath.Join(dir, "archive.tar.gz")
+		testTarArchive(t, archivePath, ArchiveFormatTarGz, c.entries)
+		destPath := filepath.Join(dir, "dest")
+		if err := os.MkdirAll(destPath, 0755); err != nil {
+			t.Fatal(err)
+		}
+		err := utilArchiveExtract(esl.Default(), archivePath, ArchiveFormatTarGz, destPath, "myapp", ArchiveLimits{})
+		var entryErr *ArchiveEntryError
+		if !errors.Is(err, c.expected) || !errors.As(err, &entryErr) || entryErr.Name != c.entries[len(c.entries)-1].Name {
+			t.Error(i, err)
+		}
+	}
+
+	// the whole chain, nothing is written outside of the destination
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "archive.tar.gz")
+	testTarArchive(t, archivePath, ArchiveFormatTarGz, []testTarEntry{
+		{Name: "d", Type: tar.TypeSymlink, Linkname: "."},
+		{Name: "d/s", Type: tar.TypeSymlink, Linkname: ".."},
+		{Name: "s/evil", Content: "evil", Mode: 0644, Type: tar.TypeReg},
+	})
+	destPath := filepath.Join(dir, "dest")
+	if err := os.MkdirAll(destPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := utilArchiveExtract(esl.Default(), archivePath, ArchiveFormatTarGz, destPath, "myapp", ArchiveLimits{}); !errors.Is(err, ErrorArchiveThroughSymlink) {
+		t.Error(err)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "evil")); !os.IsNotExist(err) {
+		t.Error(err)
+	}
+	if _, err := os.Lstat(filepath.Join(destPath, "s")); !os.IsNotExist(err) {
+		t.Error(err)
+	}
+}
+
+func TestUtilArchiveExtractZip(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlink and file mode are not supported")
+	}
+	testZip := func(path string, entries []testTarEntry) {
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zw := zip.NewWriter(f)
+		for _, e := range entries {
+			header := &zip.FileHeader{Name: e.Name, Method: zip.Deflate}
+			mode := os.FileMode(e.Mode)
+			if e.Type == tar.TypeSymlink {
+				mode = os.ModeSymlink | 0777
+				e.Content = e.Linkname
+			}
+			header.SetMode(mode)
+			w, err := zw.CreateHeader(header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write([]byte(e.Content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "archive.zip")
+	testZip(archivePath, []testTarEntry{
+		{Name: "lib/myapp.real", Content: "zip", Mode: 0750, Type: tar.TypeReg},
+		{Name: "myapp", Type: tar.TypeSymlink, Linkname: "lib/myapp.real"},
+	})
+	destPath := filepath.Join(dir, "dest")
+	if err := os.MkdirAll(destPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := utilArchiveExtract(esl.Default(), archivePath, ArchiveFormatZip, destPath, "myapp", ArchiveLimits{}); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := os.ReadFile(filepath.Join(destPath, "myapp")); err != nil || string(content) != "zip" {
+		t.Error(string(content), err)
+	}
+	if info, err := os.Stat(filepath.Join(destPath, "lib", "myapp.real")); err != nil || info.Mode().Perm() != 0750 {
+		t.Error(info, err)
+	}
+
+	unsafePath := filepath.Join(dir, "unsafe.zip")
+	testZip(unsafePath, []testTarEntry{
+		{Name: "myapp", Type: tar.TypeSymlink, Linkname: "../../bin/myapp"},
+	})
+	if err := utilArchiveExtract(esl.Default(), unsafePath, ArchiveFormatZip, t.TempDir(), "myapp", ArchiveLimits{}); !errors.Is(err, ErrorArchiveUnsafeSymlink) {
+		t.Error(err)
+	}
+}
+
+func TestUtilLocalExtract_Rejected(t *testing.T) {
+	qtr_endtoend.TestWithControl(t, func(ctl app_control.Control) {
+		dir := t.TempDir()
+		archivePath := filepath.Join(dir, "myapp-1.0.0-linux-amd64.tar.gz")
+		testTarArchive(t, archivePath, ArchiveFormatTarGz, []testTarEntry{
+			{Name: "myapp", Content: "1.0.0", Mode: 0755, Type: tar.TypeReg},
+			{Name: "../escape", Content: "escape", Mode: 0644, Type: tar.TypeReg},
+		})
+		cellarPath := filepath.Join(dir, "cellar")
+		_, err := utilLocalExtract(ctl, cellarPath, "myapp", "myapp", nil, ArchiveFormatAuto, ArchiveLimits{}, es_version.MustParse("1.0.0"), ChannelStable, archivePath)
+		if !errors.Is(err, ErrorUnsafeArchiveEntry) {
+			t.Error(err)
+		}
+		// the partially extracted version must not be listed
+		if versions, _, err := utilLocalListLocalVersions(ctl, cellarPath, "myapp", "myapp"); err != nil || len(versions) != 0 {
+			t.Error(versions, err)
+		}
+	})
+}
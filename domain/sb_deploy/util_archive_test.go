@@ -0,0 +1,194 @@
+package sb_deploy
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"github.com/watermint/toolbox/essentials/log/esl"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+type testTarEntry struct {
+	Name     string
+	Content  string
+	Mode     int64
+	Type     byte
+	Linkname string
+}
+
+func testTarArchive(t *testing.T, path, format string, entries []testTarEntry) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var w io.WriteCloser
+	switch format {
+	case ArchiveFormatTarGz:
+		w = gzip.NewWriter(f)
+	case ArchiveFormatTarXz:
+		w, err = xz.NewWriter(f)
+	case ArchiveFormatTarZst:
+		w, err = zstd.NewWriter(f)
+	default:
+		t.Fatal(format)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tw := tar.NewWriter(w)
+	for _, e := range entries {
+		header := &tar.Header{
+			Name:     e.Name,
+			Mode:     e.Mode,
+			Typeflag: e.Type,
+			Linkname: e.Linkname,
+			Size:     int64(len(e.Content)),
+		}
+		if e.Type != tar.TypeReg {
+			header.Size = 0
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if e.Type == tar.TypeReg {
+			if _, err := tw.Write([]byte(e.Content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUtilArchiveExtractTar(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlink and file mode are not supported")
+	}
+	l := esl.Default()
+	for _, format := range []string{ArchiveFormatTarGz, ArchiveFormatTarXz, ArchiveFormatTarZst} {
+		dir := t.TempDir()
+		// no extension, then the format is detected by magic bytes
+		archivePath := filepath.Join(dir, "archive")
+		testTarArchive(t, archivePath, format, []testTarEntry{
+			{Name: "lib/", Mode: 0755, Type: tar.TypeDir},
+			{Name: "lib/myapp.real", Content: format, Mode: 0750, Type: tar.TypeReg},
+			{Name: "README", Content: "readme", Mode: 0644, Type: tar.TypeReg},
+			{Name: "myapp", Type: tar.TypeSymlink, Linkname: "lib/myapp.real"},
+		})
+		detected, err := utilArchiveDetect(archivePath, ArchiveFormatAuto)
+		if err != nil || detected != format {
+			t.Error(format, detected, err)
+		}
+
+		destPath := filepath.Join(dir, "dest")
+		if err := os.MkdirAll(destPath, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := utilArchiveExtract(l, archivePath, detected, destPath, "myapp", ArchiveLimits{}); err != nil {
+			t.Fatal(format, err)
+		}
+		if content, err := os.ReadFile(filepath.Join(destPath, "myapp")); err != nil || string(content) != format {
+			t.Error(format, string(content), err)
+		}
+		if link, err := os.Readlink(filepath.Join(destPath, "myapp")); err != nil || link != "lib/myapp.real" {
+			t.Error(format, link, err)
+		}
+		if info, err := os.Stat(filepath.Join(destPath, "lib", "myapp.real")); err != nil || info.Mode().Perm() != 0750 {
+			t.Error(format, info, err)
+		}
+	}
+}
+
+func TestUtilArchiveExtractTar_Unsafe(t *testing.T) {
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "archive.tar.gz")
+	testTarArchive(t, archivePath, ArchiveFormatTarGz, []testTarEntry{
+		{Name: "../escape", Content: "escape", Mode: 0644, Type: tar.TypeReg},
+	})
+	destPath := filepath.Join(dir, "dest")
+	if err := os.MkdirAll(destPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	err := utilArchiveExtract(esl.Default(), archivePath, ArchiveFormatTarGz, destPath, "myapp", ArchiveLimits{})
+	if !errors.Is(err, ErrorUnsafeArchiveEntry) {
+		t.Error(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escape")); !os.IsNotExist(err) {
+		t.Error(err)
+	}
+}
+
+func TestUtilArchiveDetect(t *testing.T) {
+	dir := t.TempDir()
+	rawPath := filepath.Join(dir, "myapp-1.0.0-linux-amd64")
+	if err := os.WriteFile(rawPath, []byte("\x7fELF binary"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if f, err := utilArchiveDetect(rawPath, ArchiveFormatAuto); err != nil || f != ArchiveFormatRaw {
+		t.Error(f, err)
+	}
+	zipPath := filepath.Join(dir, "myapp-1.0.0-linux-amd64.zip")
+	testZipArchive(t, zipPath, map[string]string{"myapp": "1.0.0"})
+	if f, err := utilArchiveDetect(zipPath, ArchiveFormatAuto); err != nil || f != ArchiveFormatZip {
+		t.Error(f, err)
+	}
+	if f, err := utilArchiveDetect(zipPath, ArchiveFormatRaw); err != nil || f != ArchiveFormatRaw {
+		t.Error(f, err)
+	}
+	if _, err := utilArchiveDetect(zipPath, "rar"); !errors.Is(err, ErrorUnknownArchiveFormat) {
+		t.Error(err)
+	}
+
+	destPath := filepath.Join(dir, "dest")
+	if err := os.MkdirAll(destPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := utilArchiveExtract(esl.Default(), rawPath, ArchiveFormatRaw, destPath, "myapp", ArchiveLimits{}); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := os.ReadFile(filepath.Join(destPath, "myapp")); err != nil || string(content) != "\x7fELF binary" {
+		t.Error(string(content), err)
+	}
+}
+
+func TestUtilSourceIsAsset(t *testing.T) {
+	assets := []string{
+		"myapp-1.0.0-linux-amd64.zip",
+		"myapp-1.0.0-linux-amd64.tar.gz",
+		"myapp-1.0.0-linux-amd64.tgz",
+		"myapp-1.0.0-linux-amd64.tar.xz",
+		"myapp-1.0.0-linux-amd64.tar.zst",
+		"myapp-1.0.0-linux-amd64",
+		"myapp-1.0.0-linux-amd64.exe",
+	}
+	for _, a := range assets {
+		if !utilSourceIsAsset("myapp", "linux-amd64", a) {
+			t.Error(a)
+		}
+	}
+	others := []string{
+		"myapp-1.0.0-linux-amd64.sha256",
+		"myapp-1.0.0-linux-arm64.zip",
+		"other-1.0.0-linux-amd64.zip",
+	}
+	for _, o := range others {
+		if utilSourceIsAsset("myapp", "linux-amd64", o) {
+			t.Error(o)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package sb_deploy
+
+import (
+	"errors"
+	"github.com/watermint/toolbox/essentials/strings/es_version"
+	"strings"
+)
+
+const (
+	ChannelStable  = "stable"
+	ChannelBeta    = "beta"
+	ChannelNightly = "nightly"
+
+	// ChannelMarkerPrefix is the prefix of the channel marker in the version folder of the source,
+	// like `myapp-1.5.0/CHANNEL.beta`. The content of the marker is not used.
+	ChannelMarkerPrefix = "CHANNEL."
+)
+
+var (
+	ErrorInvalidChannel = errors.New("invalid channel")
+)
+
+var (
+	// channelRanks is the order of channels, from the most stable.
+	// The subscriber of the channel receives versions of the channel and more stable channels.
+	channelRanks = map[string]int{
+		ChannelStable:  0,
+		ChannelBeta:    1,
+		ChannelNightly: 2,
+	}
+
+	// channelNightlyPreReleases are pre-release identifiers of nightly builds. Other pre-releases are beta.
+	channelNightlyPreReleases = []string{"nightly", "dev", "snapshot"}
+)
+
+// utilChannelNormalize returns the channel name in lower case. Returns false if the channel is unknown.
+func utilChannelNormalize(channel string) (normalized string, ok bool) {
+	normalized = strings.ToLower(strings.TrimSpace(channel))
+	_, ok = channelRanks[normalized]
+	return normalized, ok
+}
+
+// utilChannelOfVersion returns the channel derived from the pre-release of the version.
+// The version without the pre-release is stable, pre-releases like `nightly.20240501`, `dev` or `snapshot`
+// are nightly, and others like `beta.1` or `rc.1` are beta.
+func utilChannelOfVersion(version es_version.Version) string {
+	if version.PreRelease == "" {
+		return ChannelStable
+	}
+	pre := strings.ToLower(version.PreRelease)
+	for _, n := range channelNightlyPreReleases {
+		if strings.HasPrefix(pre, n) {
+			return ChannelNightly
+		}
+	}
+	return ChannelBeta
+}
+
+// utilChannelIncludes returns true if the subscriber of the channel receives the version of the versionChannel.
+func utilChannelIncludes(channel, versionChannel string) bool {
+	c, ok := channelRanks[channel]
+	if !ok {
+		return false
+	}
+	v, ok := channelRanks[versionChannel]
+	if !ok {
+		return false
+	}
+	return v <= c
+}
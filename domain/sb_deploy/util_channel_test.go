@@ -0,0 +1,104 @@
+package sb_deploy
+
+import (
+	"github.com/watermint/toolbox/essentials/strings/es_version"
+	"github.com/watermint/toolbox/infra/control/app_control"
+	"github.com/watermint/toolbox/quality/recipe/qtr_endtoend"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUtilChannelOfVersion(t *testing.T) {
+	cases := map[string]string{
+		"1.0.0":                ChannelStable,
+		"1.0.0+build.1":        ChannelStable,
+		"1.0.0-beta.1":         ChannelBeta,
+		"1.0.0-rc.2":           ChannelBeta,
+		"1.0.0-nightly.202405": ChannelNightly,
+		"1.0.0-dev":            ChannelNightly,
+	}
+	for v, expected := range cases {
+		if c := utilChannelOfVersion(es_version.MustParse(v)); c != expected {
+			t.Error(v, c)
+		}
+	}
+	if !utilChannelIncludes(ChannelBeta, ChannelStable) || utilChannelIncludes(ChannelBeta, ChannelNightly) {
+		t.Error("beta")
+	}
+	metas := make(map[string]BinRemoteVersionMeta)
+	if ok := utilSourceParseMarker("myapp", "myapp-1.5.0", "CHANNEL.Beta", metas); !ok || metas["1.5.0"].Channel != ChannelBeta {
+		t.Error(metas, ok)
+	}
+	if ok := utilSourceParseMarker("myapp", "myapp-1.5.0", "CHANNEL.canary", metas); ok {
+		t.Error(ok)
+	}
+}
+
+func TestBinDstLocal_Channel(t *testing.T) {
+	qtr_endtoend.TestWithControl(t, func(ctl app_control.Control) {
+		sourcePath := t.TempDir()
+		testLocalDirSource(t, sourcePath, "linux-amd64", "1.4.0", "1.5.0", "1.6.0-beta.1", "1.7.0-nightly.1")
+		// 1.5.0 is published to the beta channel by the marker
+		if err := os.WriteFile(filepath.Join(sourcePath, "myapp-1.5.0", "CHANNEL.beta"), []byte{}, 0644); err != nil {
+			t.Fatal(err)
+		}
+		newWorker := func(channel string) BinDeploy {
+			return NewBinSrcLocalDirDstLocal(BinSrcLocalDirDstLocalRecipe{
+				SourcePath: sourcePath,
+				BinDstLocalRecipe: BinDstLocalRecipe{
+					BinaryName: "myapp",
+					Prefix:     "myapp",
+					Suffix:     "linux-amd64",
+					CellarPath: filepath.Join(t.TempDir(), "cellar"),
+					Channel:    channel,
+				},
+			}, ctl)
+		}
+
+		expected := map[string]string{
+			"":        "1.4.0",
+			"stable":  "1.4.0",
+			"beta":    "1.6.0-beta.1",
+			"Nightly": "1.7.0-nightly.1",
+		}
+		for channel, latest := range expected {
+			worker := newWorker(channel)
+			if _, v, err := worker.GetLocalLatest(); err != nil || v.String() != latest {
+				t.Error(channel, v, err)
+			}
+		}
+
+		versions, _, metas, err := newWorker("beta").ListRemoteVersions()
+		if err != nil || len(versions) != 3 {
+			t.Error(versions, err)
+		}
+		if metas["1.4.0"].Channel != ChannelStable || metas["1.5.0"].Channel != ChannelBeta || metas["1.6.0-beta.1"].Channel != ChannelBeta {
+			t.Error(metas)
+		}
+
+		// the channel of the installed version is recorded in the cellar
+		cellarPath := filepath.Join(t.TempDir(), "cellar")
+		for _, c := range [][]string{{"beta", "1.5.0"}, {"stable", "1.4.0"}} {
+			channel, latest := c[0], c[1]
+			worker := NewBinSrcLocalDirDstLocal(BinSrcLocalDirDstLocalRecipe{
+				SourcePath: sourcePath,
+				BinDstLocalRecipe: BinDstLocalRecipe{
+					BinaryName:        "myapp",
+					Prefix:            "myapp",
+					Suffix:            "linux-amd64",
+					CellarPath:        cellarPath,
+					Channel:           channel,
+					VersionConstraint: "<1.6",
+				},
+			}, ctl)
+			if _, v, err := worker.GetLocalLatest(); err != nil || v.String() != latest {
+				t.Error(channel, v, err)
+			}
+		}
+
+		if _, _, _, err := newWorker("canary").ListRemoteVersions(); err != ErrorInvalidChannel {
+			t.Error(err)
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package sb_deploy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	// ChecksumSidecarExtension is the extension of the checksum file of the asset, like `ASSET.sha256`.
+	ChecksumSidecarExtension = ".sha256"
+
+	// ChecksumSumsName is the name of the checksum file of all assets in the version folder or the release.
+	ChecksumSumsName = "SHA256SUMS"
+)
+
+var (
+	ErrorChecksumMismatch = errors.New("checksum mismatch")
+	ErrorChecksumNotFound = errors.New("checksum not found")
+)
+
+// utilSha256File computes SHA-256 digest of the file in lower case hex string.
+func utilSha256File(path string) (digest string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func utilChecksumIsDigest(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// utilChecksumParse finds the digest of the asset in the content of the checksum file.
+// Supports the format of `sha256sum` (`DIGEST  NAME` or `DIGEST *NAME`), BSD style (`SHA256 (NAME) = DIGEST`),
+// and the digest only for the sidecar of the asset.
+func utilChecksumParse(assetName, checksumName string, content []byte) (digest string, found bool) {
+	sidecar := checksumName == assetName+ChecksumSidecarExtension
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "SHA256 (") {
+			nameAndDigest := strings.SplitN(strings.TrimPrefix(line, "SHA256 ("), ") = ", 2)
+			if len(nameAndDigest) == 2 && (sidecar || nameAndDigest[0] == assetName) && utilChecksumIsDigest(nameAndDigest[1]) {
+				return strings.ToLower(nameAndDigest[1]), true
+			}
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if !utilChecksumIsDigest(fields[0]) {
+			continue
+		}
+		if sidecar {
+			return strings.ToLower(fields[0]), true
+		}
+		if len(fields) < 2 {
+			continue
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(fields[1], "*"), "./")
+		if name == assetName {
+			return strings.ToLower(fields[0]), true
+		}
+	}
+	return "", false
+}
+
+// utilChecksumFromSidecars retrieves the digest of the asset from `ASSET.sha256` or `SHA256SUMS`.
+// The fetch function returns the content of the file in the same folder as the asset, or found=false if no such file.
+// Returns ErrorChecksumNotFound if the checksum file exists but has no digest of the asset, since the malformed
+// or truncated checksum file must not be treated as the asset without checksum.
+func utilChecksumFromSidecars(assetName string, fetch func(name string) (content []byte, found bool, err error)) (digest string, found bool, err error) {
+	for _, name := range []string{assetName + ChecksumSidecarExtension, ChecksumSumsName} {
+		content, found, err := fetch(name)
+		if err != nil {
+			return "", false, err
+		}
+		if !found {
+			continue
+		}
+		digest, found := utilChecksumParse(assetName, name, content)
+		if !found {
+			return "", false, ErrorChecksumNotFound
+		}
+		return digest, true, nil
+	}
+	return "", false, nil
+}
@@ -0,0 +1,141 @@
+package sb_deploy
+
+import (
+	"errors"
+	"github.com/watermint/toolbox/essentials/strings/es_version"
+	"github.com/watermint/toolbox/infra/control/app_control"
+	"github.com/watermint/toolbox/quality/recipe/qtr_endtoend"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUtilChecksumParse(t *testing.T) {
+	digest := strings.Repeat("ab", 32)
+	other := strings.Repeat("cd", 32)
+	asset := "myapp-1.0.0-linux-amd64.zip"
+
+	cases := []struct {
+		checksumName string
+		content      string
+		found        bool
+	}{
+		{asset + ".sha256", digest + "\n", true},
+		{asset + ".sha256", strings.ToUpper(digest) + "  " + asset + "\n", true},
+		{ChecksumSumsName, other + "  myapp-1.0.0-darwin-arm64.zip\n" + digest + " *" + asset + "\n", true},
+		{ChecksumSumsName, "# comment\n" + digest + "  ./" + asset + "\n", true},
+		{ChecksumSumsName, "SHA256 (" + asset + ") = " + digest + "\n", true},
+		{ChecksumSumsName, other + "  myapp-1.0.0-darwin-arm64.zip\n", false},
+		{ChecksumSumsName, digest + "\n", false},
+		{asset + ".sha256", "not a digest\n", false},
+	}
+	for _, c := range cases {
+		d, found := utilChecksumParse(asset, c.checksumName, []byte(c.content))
+		if found != c.found || (found && d != digest) {
+			t.Error(c.checksumName, c.content, d, found)
+		}
+	}
+}
+
+func TestUtilChecksumFromSidecars(t *testing.T) {
+	digest := strings.Repeat("ab", 32)
+	asset := "myapp-1.0.0-linux-amd64.zip"
+
+	cases := []struct {
+		files    map[string]string
+		found    bool
+		expected error
+	}{
+		{map[string]string{}, false, nil},
+		{map[string]string{asset + ".sha256": digest + "\n"}, true, nil},
+		{map[string]string{ChecksumSumsName: digest + "  " + asset + "\n"}, true, nil},
+		// malformed sidecar
+		{map[string]string{asset + ".sha256": "not a digest\n", ChecksumSumsName: digest + "  " + asset + "\n"}, false, ErrorChecksumNotFound},
+		// no entry of the asset
+		{map[string]string{ChecksumSumsName: digest + "  myapp-1.0.0-darwin-arm64.zip\n"}, false, ErrorChecksumNotFound},
+		{map[string]string{ChecksumSumsName: ""}, false, ErrorChecksumNotFound},
+	}
+	for i, c := range cases {
+		d, found, err := utilChecksumFromSidecars(asset, func(name string) ([]byte, bool, error) {
+			content, found := c.files[name]
+			return []byte(content), found, nil
+		})
+		if found != c.found || !errors.Is(err, c.expected) || (found && d != digest) {
+			t.Error(i, d, found, err)
+		}
+	}
+}
+
+func TestBinDstLocal_Verify(t *testing.T) {
+	qtr_endtoend.TestWithControl(t, func(ctl app_control.Control) {
+		sourcePath := t.TempDir()
+		testLocalDirSource(t, sourcePath, "linux-amd64", "1.0.0", "1.1.0", "1.2.0")
+		assetName := func(v string) string {
+			return "myapp-" + v + "-linux-amd64.zip"
+		}
+		assetPath := func(v string) string {
+			return filepath.Join(sourcePath, "myapp-"+v, assetName(v))
+		}
+		digest110, err := utilSha256File(assetPath("1.1.0"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 1.0.0: no checksum, 1.1.0: valid SHA256SUMS, 1.2.0: broken sidecar
+		if err := os.WriteFile(filepath.Join(sourcePath, "myapp-1.1.0", ChecksumSumsName), []byte(digest110+"  "+assetName("1.1.0")+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(assetPath("1.2.0")+ChecksumSidecarExtension, []byte(strings.Repeat("0", 64)+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		recipe := BinSrcLocalDirDstLocalRecipe{
+			SourcePath: sourcePath,
+			BinDstLocalRecipe: BinDstLocalRecipe{
+				BinaryName: "myapp",
+				Prefix:     "myapp",
+				Suffix:     "linux-amd64",
+				CellarPath: filepath.Join(t.TempDir(), "cellar"),
+			},
+		}
+		worker := NewBinSrcLocalDirDstLocal(recipe, ctl)
+		_, versionPaths, _, err := worker.ListRemoteVersions()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// mismatch: refuse, and the update must not install anything
+		if _, err := worker.Download(es_version.MustParse("1.2.0"), versionPaths["1.2.0"]); !errors.Is(err, ErrorChecksumMismatch) {
+			t.Error(err)
+		}
+		if err := worker.UpdateIfRequired(); !errors.Is(err, ErrorChecksumMismatch) {
+			t.Error(err)
+		}
+		if p := worker.LocalLatestBinaryPath(); p != "" {
+			t.Error(p)
+		}
+
+		// verified, then the digest is recorded in the cellar
+		dlPath, err := worker.Download(es_version.MustParse("1.1.0"), versionPaths["1.1.0"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		cellarPath, err := worker.Extract(es_version.MustParse("1.1.0"), dlPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if recorded, err := os.ReadFile(filepath.Join(cellarPath, BinDstLocalDigestName)); err != nil || strings.TrimSpace(string(recorded)) != digest110 {
+			t.Error(string(recorded), err)
+		}
+
+		// no checksum: allowed unless required
+		if _, err := worker.Download(es_version.MustParse("1.0.0"), versionPaths["1.0.0"]); err != nil {
+			t.Error(err)
+		}
+		recipe.RequireChecksum = true
+		strictWorker := NewBinSrcLocalDirDstLocal(recipe, ctl)
+		if _, err := strictWorker.Download(es_version.MustParse("1.0.0"), versionPaths["1.0.0"]); !errors.Is(err, ErrorChecksumNotFound) {
+			t.Error(err)
+		}
+	})
+}
@@ -0,0 +1,172 @@
+package sb_deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/watermint/toolbox/essentials/log/esl"
+	"github.com/watermint/toolbox/essentials/network/nw_bandwidth"
+	"github.com/watermint/toolbox/infra/control/app_control"
+	"github.com/watermint/toolbox/infra/control/app_definitions"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// HttpConnectTimeout is the timeout in seconds to connect to the server, including the TLS handshake.
+	HttpConnectTimeout = 30
+
+	// HttpResponseHeaderTimeout is the timeout in seconds to wait for the response header after the request is sent.
+	HttpResponseHeaderTimeout = 60
+
+	// HttpRequestTimeout is the timeout in seconds of the request, including the transfer of the response body.
+	HttpRequestTimeout = 1800
+)
+
+var (
+	ErrorHttpUnexpectedStatus = errors.New("unexpected http status")
+	ErrorHttpNotFound         = errors.New("not found")
+)
+
+var (
+	// utilHttpClient is the client of sources, with timeouts to not hang the launcher by the stalled server.
+	utilHttpClient = utilHttpNewClient()
+
+	// utilHttpRequestTimeout is the deadline of the request.
+	utilHttpRequestTimeout = HttpRequestTimeout * time.Second
+)
+
+func utilHttpNewClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   HttpConnectTimeout * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = HttpConnectTimeout * time.Second
+	transport.ResponseHeaderTimeout = HttpResponseHeaderTimeout * time.Second
+	return &http.Client{Transport: transport}
+}
+
+// utilHttpBody is the response body that cancels the context of the request on close.
+type utilHttpBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (z utilHttpBody) Close() error {
+	defer z.cancel()
+	return z.ReadCloser.Close()
+}
+
+// utilHttpRequest sends the request with the deadline. The caller must close the body of the response.
+func utilHttpRequest(c app_control.Control, url string, header map[string]string) (res *http.Response, err error) {
+	l := c.Log().With(esl.String("url", url))
+	ctx, cancel := context.WithTimeout(context.Background(), utilHttpRequestTimeout)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		cancel()
+		l.Debug("Unable to create the request", esl.Error(err))
+		return nil, err
+	}
+	req.Header.Set("User-Agent", app_definitions.UserAgent())
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	res, err = utilHttpClient.Do(req)
+	if err != nil {
+		cancel()
+		l.Debug("Unable to send the request", esl.Error(err))
+		return nil, err
+	}
+	res.Body = utilHttpBody{ReadCloser: res.Body, cancel: cancel}
+	if res.StatusCode == http.StatusNotFound {
+		_ = res.Body.Close()
+		l.Debug("Not found")
+		return nil, fmt.Errorf("%w: %s", ErrorHttpNotFound, url)
+	}
+	if res.StatusCode != http.StatusOK {
+		_ = res.Body.Close()
+		l.Debug("Unexpected status", esl.Int("status", res.StatusCode))
+		return nil, fmt.Errorf("%w: %d %s", ErrorHttpUnexpectedStatus, res.StatusCode, url)
+	}
+	return res, nil
+}
+
+// utilHttpGet retrieves the content of the url.
+func utilHttpGet(c app_control.Control, url string, header map[string]string) (content []byte, err error) {
+	res, err := utilHttpRequest(c, url, header)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	return io.ReadAll(res.Body)
+}
+
+// utilHttpGetIfExists retrieves the content of the url. Returns found=false if the server responds 404.
+func utilHttpGetIfExists(c app_control.Control, url string, header map[string]string) (content []byte, found bool, err error) {
+	content, err = utilHttpGet(c, url, header)
+	switch {
+	case errors.Is(err, ErrorHttpNotFound):
+		return nil, false, nil
+	case err != nil:
+		return nil, false, err
+	default:
+		return content, true, nil
+	}
+}
+
+// utilHttpSiblingUrl returns the url of the file in the same folder as the url.
+func utilHttpSiblingUrl(fileUrl string, name string) (string, error) {
+	u, err := url.Parse(fileUrl)
+	if err != nil {
+		return "", err
+	}
+	s, err := u.Parse(url.PathEscape(name))
+	if err != nil {
+		return "", err
+	}
+	return s.String(), nil
+}
+
+// utilHttpDownload downloads the content of the url into the job download folder.
+// Returns the path to the downloaded file.
+func utilHttpDownload(c app_control.Control, url string, name string, header map[string]string) (downloadPath string, err error) {
+	l := c.Log().With(esl.String("url", url))
+	res, err := utilHttpRequest(c, url, header)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	downloadFolder, err := c.Workspace().Descendant("download")
+	if err != nil {
+		l.Debug("Unable to create download folder", esl.Error(err))
+		return "", err
+	}
+	downloadPath = filepath.Join(downloadFolder, filepath.Base(name))
+	f, err := os.Create(downloadPath)
+	if err != nil {
+		l.Debug("Unable to create the file", esl.Error(err))
+		return "", err
+	}
+	size, err := io.Copy(f, nw_bandwidth.WrapReader(res.Body))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		l.Debug("Unable to download", esl.Error(err))
+		_ = os.Remove(downloadPath)
+		return "", err
+	}
+	l.Debug("Downloaded", esl.String("path", downloadPath), esl.Int64("size", size))
+	return downloadPath, nil
+}
@@ -0,0 +1,43 @@
+package sb_deploy
+
+import (
+	"context"
+	"errors"
+	"github.com/watermint/toolbox/infra/control/app_control"
+	"github.com/watermint/toolbox/quality/recipe/qtr_endtoend"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestUtilHttpRequest_Timeout(t *testing.T) {
+	qtr_endtoend.TestWithControl(t, func(ctl app_control.Control) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/body" {
+				w.WriteHeader(http.StatusOK)
+				_, _ = w.Write([]byte("partial"))
+				w.(http.Flusher).Flush()
+			}
+			// the stalled server that never completes the response
+			<-r.Context().Done()
+		}))
+		defer server.Close()
+
+		timeout := utilHttpRequestTimeout
+		utilHttpRequestTimeout = 200 * time.Millisecond
+		defer func() {
+			utilHttpRequestTimeout = timeout
+		}()
+
+		for _, p := range []string{"/header", "/body"} {
+			started := time.Now()
+			if _, err := utilHttpGet(ctl, server.URL+p, nil); !errors.Is(err, context.DeadlineExceeded) {
+				t.Error(p, err)
+			}
+			if d := time.Since(started); d > 10*time.Second {
+				t.Error(p, d)
+			}
+		}
+	})
+}
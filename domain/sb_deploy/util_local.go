@@ -1,7 +1,8 @@
 package sb_deploy
 
 import (
-	"github.com/watermint/toolbox/essentials/io/es_zip"
+	"encoding/json"
+	"errors"
 	"github.com/watermint/toolbox/essentials/log/esl"
 	"github.com/watermint/toolbox/essentials/strings/es_version"
 	"github.com/watermint/toolbox/infra/control/app_control"
@@ -9,58 +10,233 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
+)
+
+var (
+	ErrorBinaryNotFound      = errors.New("binary not found in the archive")
+	ErrorBinaryNotExecutable = errors.New("binary is not executable")
 )
 
 func utilBinaryName(binName string) string {
-	if app_definitions.IsWindows() {
+	// the name may have the extension by the template `{{.Ext}}`
+	if app_definitions.IsWindows() && !strings.HasSuffix(strings.ToLower(binName), ".exe") {
 		return binName + ".exe"
 	} else {
 		return binName
 	}
 }
 
-func utilLocalExtract(c app_control.Control, cellarPath, prefix, binName string, version es_version.Version, downloadPath string) (versionCellarPath string, err error) {
+// utilLocalExtract extracts the archive into the staging directory in the cellar, validates the binary and extra binaries,
+// writes the completion marker, then renames the staging directory to `PREFIX-VERSION`.
+// The downloaded file is removed regardless of the result.
+func utilLocalExtract(c app_control.Control, cellarPath, prefix, binName string, extraBinNames []string, archiveFormat string, limits ArchiveLimits, version es_version.Version, channel, downloadPath string) (versionCellarPath string, err error) {
 	l := c.Log().With(esl.String("downloadPath", downloadPath))
 	l.Debug("Extract version")
+	defer func() {
+		if removeErr := os.Remove(downloadPath); removeErr != nil {
+			l.Warn("Unable to remove downloaded file", esl.Error(removeErr))
+		}
+	}()
 
-	versionCellarPath = filepath.Join(cellarPath, prefix+"-"+version.String())
-	if err := os.MkdirAll(versionCellarPath, 0755); err != nil {
-		l.Debug("Unable to create destination directory", esl.Error(err))
+	format, err := utilArchiveDetect(downloadPath, archiveFormat)
+	if err != nil {
+		l.Debug("Unable to detect the archive format", esl.Error(err))
+		return "", err
+	}
+	archiveDigest, err := utilSha256File(downloadPath)
+	if err != nil {
+		l.Debug("Unable to compute the digest", esl.Error(err))
+		return "", err
+	}
+
+	if err := os.MkdirAll(cellarPath, 0755); err != nil {
+		l.Debug("Unable to create cellar directory", esl.Error(err))
+		return "", err
+	}
+	// the staging directory is in the cellar, then the rename is atomic in the same file system.
+	stagingPath, err := os.MkdirTemp(cellarPath, BinDstLocalStagingPrefix+prefix+"-"+version.String()+"-")
+	if err != nil {
+		l.Debug("Unable to create staging directory", esl.Error(err))
+		return "", err
+	}
+	defer func() {
+		if removeErr := os.RemoveAll(stagingPath); removeErr != nil {
+			l.Warn("Unable to remove staging directory", esl.Error(removeErr))
+		}
+	}()
+	if err := os.Chmod(stagingPath, 0755); err != nil {
+		l.Debug("Unable to change permission of staging directory", esl.Error(err))
 		return "", err
 	}
-	l.Info("Extracting into cellar directory", esl.String("cellarPath", versionCellarPath))
-	err = es_zip.Extract(l, downloadPath, versionCellarPath)
-	l.Debug("Extracted", esl.Error(err), esl.String("cellarPath", versionCellarPath))
 
-	binCellarPath := filepath.Join(versionCellarPath, utilBinaryName(binName))
+	l.Info("Extracting into staging directory", esl.String("stagingPath", stagingPath), esl.String("format", format))
+	if err := utilArchiveExtract(l, downloadPath, format, stagingPath, utilBinaryName(binName), limits); err != nil {
+		l.Warn("Unable to extract the archive", esl.Error(err))
+		return "", err
+	}
 
-	if err := os.Chmod(binCellarPath, 0755); err != nil {
+	binStagingPath := filepath.Join(stagingPath, utilBinaryName(binName))
+	if err := os.Chmod(binStagingPath, 0755); err != nil && !os.IsNotExist(err) {
 		l.Warn("Unable to change permission", esl.Error(err))
-		return versionCellarPath, err
+		return "", err
+	}
+	binaryDigest, err := utilLocalValidate(binStagingPath)
+	if err != nil {
+		l.Warn("The extracted binary is not valid", esl.Error(err))
+		return "", err
+	}
+	for _, extraBinName := range extraBinNames {
+		extraBinStagingPath := filepath.Join(stagingPath, utilBinaryName(extraBinName))
+		if err := os.Chmod(extraBinStagingPath, 0755); err != nil && !os.IsNotExist(err) {
+			l.Warn("Unable to change permission", esl.Error(err))
+			return "", err
+		}
+		if _, err := utilLocalValidate(extraBinStagingPath); err != nil {
+			l.Warn("The extracted binary is not valid", esl.String("binary", extraBinName), esl.Error(err))
+			return "", err
+		}
 	}
 
-	if err := os.Remove(downloadPath); err != nil {
-		l.Warn("Unable to remove downloaded file", esl.Error(err))
+	if err := os.WriteFile(filepath.Join(stagingPath, BinDstLocalDigestName), []byte(archiveDigest+"\n"), 0644); err != nil {
+		l.Warn("Unable to record the digest", esl.Error(err))
+		return "", err
+	}
+	marker, err := json.Marshal(&BinDstLocalCompletion{
+		Version:       version.String(),
+		ArchiveSha256: archiveDigest,
+		BinarySha256:  binaryDigest,
+		CompletedAt:   time.Now().UTC().Format(time.RFC3339),
+		Channel:       channel,
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(stagingPath, BinDstLocalCompletionMarkerName), marker, 0644); err != nil {
+		l.Warn("Unable to write the completion marker", esl.Error(err))
+		return "", err
 	}
 
-	return versionCellarPath, err
+	versionCellarPath = filepath.Join(cellarPath, prefix+"-"+version.String())
+	if err := utilLocalReplaceDir(stagingPath, versionCellarPath); err != nil {
+		l.Warn("Unable to move the staging directory into place", esl.Error(err))
+		return "", err
+	}
+	l.Info("Extracted", esl.String("cellarPath", versionCellarPath))
+	return versionCellarPath, nil
+}
+
+// utilLocalValidate validates the binary in the staging directory, then returns the SHA-256 digest of the binary.
+func utilLocalValidate(binPath string) (digest string, err error) {
+	info, err := os.Stat(binPath)
+	switch {
+	case os.IsNotExist(err):
+		return "", ErrorBinaryNotFound
+	case err != nil:
+		return "", err
+	case !info.Mode().IsRegular():
+		return "", ErrorBinaryNotFound
+	case !app_definitions.IsWindows() && info.Mode().Perm()&0111 == 0:
+		return "", ErrorBinaryNotExecutable
+	}
+	return utilSha256File(binPath)
+}
+
+// utilLocalAsidePrefix returns the prefix of the folder to move the existing directory aside,
+// like `.sb_deploy.staging-PREFIX-VERSION-old-`.
+func utilLocalAsidePrefix(dstPath string) string {
+	return BinDstLocalStagingPrefix + filepath.Base(dstPath) + "-old-"
 }
 
-func utilLocalLatestBinaryPath(c app_control.Control, cellarPath, prefix, binName string) string {
-	l := c.Log()
-	localVersions, localVersionPaths, err := utilLocalListLocalVersions(c, cellarPath, prefix)
+// utilLocalReplaceDir renames the directory to the destination.
+// The existing destination is moved aside first, because the rename does not replace the non-empty directory.
+func utilLocalReplaceDir(srcPath, dstPath string) error {
+	_, err := os.Lstat(dstPath)
+	if os.IsNotExist(err) {
+		return os.Rename(srcPath, dstPath)
+	}
+	if err != nil {
+		return err
+	}
+	asideFolder, err := os.MkdirTemp(filepath.Dir(dstPath), utilLocalAsidePrefix(dstPath))
 	if err != nil {
-		l.Debug("Unable to list local versions", esl.Error(err))
-		return ""
+		return err
 	}
-	localVersionLatest := es_version.Max(localVersions...)
-	if len(localVersions) < 1 || localVersionLatest.Equals(es_version.Zero()) {
-		return ""
+	defer func() {
+		// the folder may not be removed while the binary is running on Windows. The folder is named
+		// as the staging directory of the package, then removed by prune or uninstall later.
+		_ = os.RemoveAll(asideFolder)
+	}()
+	asidePath := filepath.Join(asideFolder, filepath.Base(dstPath))
+	if err := os.Rename(dstPath, asidePath); err != nil {
+		return err
 	}
-	return filepath.Join(localVersionPaths[localVersionLatest.String()], utilBinaryName(binName))
+	if err := os.Rename(srcPath, dstPath); err != nil {
+		// restore the existing directory
+		_ = os.Rename(asidePath, dstPath)
+		return err
+	}
+	return nil
 }
 
-func utilLocalListLocalVersions(c app_control.Control, cellarPath, prefix string) (versions []es_version.Version, versionPaths map[string]string, err error) {
+// utilLocalWriteFile writes the file into the temporary file then renames, to not expose the partially written file
+// to other processes, or leave the truncated file on the crash.
+func utilLocalWriteFile(path string, data []byte, perm os.FileMode) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmpFile.Name())
+	}()
+	if _, err := tmpFile.Write(data); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpFile.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), path)
+}
+
+// utilLocalIsComplete returns true if the version directory has the completion marker.
+func utilLocalIsComplete(versionPath string) bool {
+	info, err := os.Stat(filepath.Join(versionPath, BinDstLocalCompletionMarkerName))
+	return err == nil && info.Mode().IsRegular()
+}
+
+// utilLocalMigrate writes the completion marker for the version installed by the release before the marker,
+// that extracted the archive into `PREFIX-VERSION` directly. The version is migrated only if the binary is valid.
+func utilLocalMigrate(c app_control.Control, versionPath, binName string, version es_version.Version) (migrated bool) {
+	l := c.Log().With(esl.String("versionPath", versionPath))
+	binaryDigest, err := utilLocalValidate(filepath.Join(versionPath, utilBinaryName(binName)))
+	if err != nil {
+		l.Debug("The binary is not valid, skip migration", esl.Error(err))
+		return false
+	}
+	// the digest of the archive is unknown, because the archive is already removed.
+	marker, err := json.Marshal(&BinDstLocalCompletion{
+		Version:      version.String(),
+		BinarySha256: binaryDigest,
+		CompletedAt:  time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return false
+	}
+	if err := utilLocalWriteFile(filepath.Join(versionPath, BinDstLocalCompletionMarkerName), marker, 0644); err != nil {
+		l.Warn("Unable to write the completion marker", esl.Error(err))
+		return false
+	}
+	l.Info("Migrated the version installed without the completion marker", esl.String("version", version.String()))
+	return true
+}
+
+// utilLocalListLocalVersions lists versions in the cellar. The version without the completion marker is ignored,
+// unless the binary is valid and migrated by utilLocalMigrate.
+func utilLocalListLocalVersions(c app_control.Control, cellarPath, prefix, binName string) (versions []es_version.Version, versionPaths map[string]string, err error) {
 	versions = make([]es_version.Version, 0)
 	versionPaths = make(map[string]string)
 	l := c.Log().With(esl.String("cellarPath", cellarPath))
@@ -83,6 +259,10 @@ func utilLocalListLocalVersions(c app_control.Control, cellarPath, prefix string
 				l.Debug("Unable to parse version", esl.Error(err))
 				continue
 			}
+			if !utilLocalIsComplete(filepath.Join(cellarPath, entry.Name())) && !utilLocalMigrate(c, filepath.Join(cellarPath, entry.Name()), binName, ver) {
+				l.Debug("Skip incomplete version", esl.String("name", entry.Name()))
+				continue
+			}
 			versions = append(versions, ver)
 			versionPaths[ver.String()] = filepath.Join(cellarPath, entry.Name())
 		}
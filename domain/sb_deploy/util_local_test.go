@@ -0,0 +1,121 @@
+package sb_deploy
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/watermint/toolbox/essentials/strings/es_version"
+	"github.com/watermint/toolbox/infra/control/app_control"
+	"github.com/watermint/toolbox/quality/recipe/qtr_endtoend"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestUtilLocalExtract_Staged(t *testing.T) {
+	qtr_endtoend.TestWithControl(t, func(ctl app_control.Control) {
+		dir := t.TempDir()
+		cellarPath := filepath.Join(dir, "cellar")
+		archive := func(v string, files map[string]string) string {
+			p := filepath.Join(dir, "myapp-"+v+"-linux-amd64.zip")
+			testZipArchive(t, p, files)
+			return p
+		}
+
+		// interrupted installation: no completion marker, and the binary is not executable yet
+		if err := os.MkdirAll(filepath.Join(cellarPath, "myapp-9.9.9"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(cellarPath, "myapp-9.9.9", utilBinaryName("myapp")), []byte("partial"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		// no binary in the archive
+		_, err := utilLocalExtract(ctl, cellarPath, "myapp", "myapp", nil, ArchiveFormatAuto, ArchiveLimits{}, es_version.MustParse("1.0.0"), ChannelStable, archive("1.0.0", map[string]string{"README": "readme"}))
+		if !errors.Is(err, ErrorBinaryNotFound) {
+			t.Error(err)
+		}
+
+		// installed, then installed again over the existing version
+		for _, content := range []string{"1.1.0", "1.1.0-again"} {
+			versionPath, err := utilLocalExtract(ctl, cellarPath, "myapp", "myapp", nil, ArchiveFormatAuto, ArchiveLimits{}, es_version.MustParse("1.1.0"), ChannelStable, archive("1.1.0", map[string]string{utilBinaryName("myapp"): content}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c, err := os.ReadFile(filepath.Join(versionPath, utilBinaryName("myapp"))); err != nil || string(c) != content {
+				t.Error(string(c), err)
+			}
+			markerData, err := os.ReadFile(filepath.Join(versionPath, BinDstLocalCompletionMarkerName))
+			if err != nil {
+				t.Fatal(err)
+			}
+			marker := &BinDstLocalCompletion{}
+			if err := json.Unmarshal(markerData, marker); err != nil || marker.Version != "1.1.0" || !utilChecksumIsDigest(marker.BinarySha256) {
+				t.Error(marker, err)
+			}
+		}
+
+		versions, _, err := utilLocalListLocalVersions(ctl, cellarPath, "myapp", "myapp")
+		if err != nil || len(versions) != 1 || versions[0].String() != "1.1.0" {
+			t.Error(versions, err)
+		}
+		// no staging directory left
+		entries, err := os.ReadDir(cellarPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 2 {
+			t.Error(entries)
+		}
+	})
+}
+
+func TestBinDstLocal_MigrateCellar(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlink is not supported")
+	}
+	qtr_endtoend.TestWithControl(t, func(ctl app_control.Control) {
+		// the cellar of the previous release: versions are extracted into `PREFIX-VERSION` without the marker.
+		cellarPath := filepath.Join(t.TempDir(), "cellar")
+		for v, mode := range map[string]os.FileMode{"1.0.0": 0755, "1.1.0": 0755, "1.2.0": 0644} {
+			if err := os.MkdirAll(filepath.Join(cellarPath, "myapp-"+v), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(cellarPath, "myapp-"+v, "myapp"), []byte(v), mode); err != nil {
+				t.Fatal(err)
+			}
+		}
+		deployPath := filepath.Join(t.TempDir(), "bin")
+		// the source is not reachable
+		worker := NewBinSrcLocalDirDstLocal(BinSrcLocalDirDstLocalRecipe{
+			SourcePath: filepath.Join(t.TempDir(), "offline"),
+			BinDstLocalRecipe: BinDstLocalRecipe{
+				BinaryName: "myapp",
+				Prefix:     "myapp",
+				Suffix:     "linux-amd64",
+				CellarPath: cellarPath,
+				DeployPath: deployPath,
+			},
+		}, ctl)
+
+		// the version with the invalid binary is not migrated
+		binaryPath, v, err := worker.GetLocalLatest()
+		if err != nil || v.String() != "1.1.0" {
+			t.Fatal(v, err)
+		}
+		if c, err := os.ReadFile(filepath.Join(binaryPath, "myapp")); err != nil || string(c) != "1.1.0" {
+			t.Error(string(c), err)
+		}
+		for v, complete := range map[string]bool{"1.0.0": true, "1.1.0": true, "1.2.0": false} {
+			if utilLocalIsComplete(filepath.Join(cellarPath, "myapp-"+v)) != complete {
+				t.Error(v, complete)
+			}
+		}
+		if err := worker.DeploySymlink(); err != nil {
+			t.Error(err)
+		}
+		if target, err := os.Readlink(filepath.Join(deployPath, "myapp")); err != nil || target != filepath.Join(cellarPath, "myapp-1.1.0", "myapp") {
+			t.Error(target, err)
+		}
+	})
+}
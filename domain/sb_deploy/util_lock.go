@@ -0,0 +1,61 @@
+package sb_deploy
+
+import (
+	"context"
+	"errors"
+	"github.com/gofrs/flock"
+	"github.com/watermint/toolbox/essentials/log/esl"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// LockName is the name of the lock file in the cellar and the deploy path.
+	LockName = ".sb_deploy.lock"
+
+	// LockDefaultTimeout is the default timeout in seconds to wait for the lock.
+	LockDefaultTimeout = 300
+
+	lockRetryDelay = 200 * time.Millisecond
+)
+
+var (
+	ErrorLockTimeout = errors.New("timed out waiting for another switchbox that is updating")
+)
+
+// utilLockAcquire acquires the cross-process lock of the file, and waits for the lock until the timeout.
+// Returns the function to release the lock.
+func utilLockAcquire(l esl.Logger, lockPath string, timeout time.Duration) (unlock func(), err error) {
+	ll := l.With(esl.String("lockPath", lockPath))
+	if err := os.MkdirAll(filepath.Dir(lockPath), 0755); err != nil {
+		ll.Debug("Unable to create the directory of the lock", esl.Error(err))
+		return nil, err
+	}
+	fl := flock.New(lockPath)
+	locked, err := fl.TryLock()
+	if err != nil {
+		ll.Debug("Unable to lock", esl.Error(err))
+		return nil, err
+	}
+	if !locked {
+		ll.Info("Another switchbox is updating, waiting for the lock", esl.String("timeout", timeout.String()))
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		locked, err = fl.TryLockContext(ctx, lockRetryDelay)
+		if !locked {
+			if err == nil || errors.Is(err, context.DeadlineExceeded) {
+				ll.Warn("Another switchbox is still updating, give up waiting")
+				return nil, ErrorLockTimeout
+			}
+			ll.Debug("Unable to lock", esl.Error(err))
+			return nil, err
+		}
+	}
+	ll.Debug("Locked")
+	return func() {
+		if err := fl.Unlock(); err != nil {
+			ll.Debug("Unable to unlock", esl.Error(err))
+		}
+	}, nil
+}
@@ -0,0 +1,74 @@
+package sb_deploy
+
+import (
+	"errors"
+	"github.com/watermint/toolbox/essentials/log/esl"
+	"github.com/watermint/toolbox/infra/control/app_control"
+	"github.com/watermint/toolbox/quality/recipe/qtr_endtoend"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUtilLockAcquire(t *testing.T) {
+	l := esl.Default()
+	lockPath := filepath.Join(t.TempDir(), "cellar", LockName)
+	unlock, err := utilLockAcquire(l, lockPath, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := utilLockAcquire(l, lockPath, 300*time.Millisecond); !errors.Is(err, ErrorLockTimeout) {
+		t.Error(err)
+	}
+
+	// released while waiting
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		unlock()
+	}()
+	unlock2, err := utilLockAcquire(l, lockPath, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock2()
+}
+
+func TestBinDstLocal_ConcurrentUpdate(t *testing.T) {
+	qtr_endtoend.TestWithControl(t, func(ctl app_control.Control) {
+		sourcePath := t.TempDir()
+		testLocalDirSource(t, sourcePath, "linux-amd64", "1.0.0", "1.1.0")
+		recipe := BinSrcLocalDirDstLocalRecipe{
+			SourcePath: sourcePath,
+			BinDstLocalRecipe: BinDstLocalRecipe{
+				BinaryName: "myapp",
+				Prefix:     "myapp",
+				Suffix:     "linux-amd64",
+				CellarPath: filepath.Join(t.TempDir(), "cellar"),
+				DeployPath: filepath.Join(t.TempDir(), "bin"),
+			},
+		}
+
+		wg := sync.WaitGroup{}
+		errs := make(chan error, 4)
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- NewBinSrcLocalDirDstLocal(recipe, ctl).DeploySymlink()
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Error(err)
+			}
+		}
+
+		versions, _, err := NewBinSrcLocalDirDstLocal(recipe, ctl).ListLocalVersions()
+		if err != nil || len(versions) != 1 || versions[0].String() != "1.1.0" {
+			t.Error(versions, err)
+		}
+	})
+}
@@ -0,0 +1,126 @@
+package sb_deploy
+
+import (
+	"encoding/json"
+	"errors"
+	"runtime"
+	"strings"
+	"text/template"
+)
+
+var (
+	ErrorInvalidTemplate = errors.New("invalid template in the recipe")
+)
+
+var (
+	// platformTemplateFields are fields of the deploy JSON that may contain templates like `{{.OS}}-{{.Arch}}`.
+	platformTemplateFields = []string{"suffix", "binary_name", "deploy_path"}
+
+	// platformTemplateListFields are fields of the deploy JSON that are lists of templates.
+	platformTemplateListFields = []string{"suffix_fallbacks"}
+)
+
+// BinDeployPlatform is the platform that resolves templates in the deploy JSON.
+type BinDeployPlatform struct {
+	// OS is the operating system like `linux`, `darwin` or `windows` (GOOS).
+	OS string
+
+	// Arch is the architecture like `amd64` or `arm64` (GOARCH).
+	Arch string
+
+	// Ext is the extension of executables, `.exe` on Windows and empty on others.
+	Ext string
+}
+
+// utilPlatformCurrent returns the platform of the running switchbox.
+func utilPlatformCurrent() BinDeployPlatform {
+	p := BinDeployPlatform{
+		OS:   runtime.GOOS,
+		Arch: runtime.GOARCH,
+	}
+	if p.OS == "windows" {
+		p.Ext = ".exe"
+	}
+	return p
+}
+
+// utilPlatformResolve resolves the template like `{{.OS}}-{{.Arch}}` by the platform.
+func utilPlatformResolve(s string, platform BinDeployPlatform) (resolved string, err error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+	t, err := template.New("recipe").Option("missingkey=error").Parse(s)
+	if err != nil {
+		return "", ErrorInvalidTemplate
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, platform); err != nil {
+		return "", ErrorInvalidTemplate
+	}
+	return b.String(), nil
+}
+
+// utilPlatformResolveFields resolves templates of fields in the JSON object.
+func utilPlatformResolveFields(fields map[string]json.RawMessage, platform BinDeployPlatform) (err error) {
+	for _, name := range platformTemplateFields {
+		raw, ok := fields[name]
+		if !ok {
+			continue
+		}
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return err
+		}
+		if s, err = utilPlatformResolve(s, platform); err != nil {
+			return err
+		}
+		if fields[name], err = json.Marshal(s); err != nil {
+			return err
+		}
+	}
+	for _, name := range platformTemplateListFields {
+		raw, ok := fields[name]
+		if !ok {
+			continue
+		}
+		var list []string
+		if err := json.Unmarshal(raw, &list); err != nil {
+			return err
+		}
+		for i := range list {
+			if list[i], err = utilPlatformResolve(list[i], platform); err != nil {
+				return err
+			}
+		}
+		if fields[name], err = json.Marshal(list); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// utilPlatformResolveRecipe resolves templates of the deploy JSON, including entries of `sources`.
+func utilPlatformResolveRecipe(content []byte, platform BinDeployPlatform) (resolved []byte, err error) {
+	top := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(content, &top); err != nil {
+		return nil, err
+	}
+	if err := utilPlatformResolveFields(top, platform); err != nil {
+		return nil, err
+	}
+	if raw, ok := top["sources"]; ok {
+		entries := make([]map[string]json.RawMessage, 0)
+		if err := json.Unmarshal(raw, &entries); err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if err := utilPlatformResolveFields(entry, platform); err != nil {
+				return nil, err
+			}
+		}
+		if top["sources"], err = json.Marshal(entries); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(top)
+}
@@ -0,0 +1,80 @@
+package sb_deploy
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/shirou/gopsutil/v3/host"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const (
+	// RolloutMarkerPrefix is the prefix of the rollout marker in the version folder of the source,
+	// like `myapp-1.5.0/ROLLOUT.10` to roll out the version to 10% of machines.
+	RolloutMarkerPrefix = "ROLLOUT."
+
+	// RolloutMachineIdName is the name of the file in the cellar, that records the machine ID
+	// generated for the machine without the machine ID of the OS.
+	RolloutMachineIdName = ".sb_deploy.machine_id"
+)
+
+var (
+	// rolloutLinuxMachineIdPaths are paths of the machine ID on Linux, that is readable by any user.
+	rolloutLinuxMachineIdPaths = []string{"/etc/machine-id", "/var/lib/dbus/machine-id"}
+)
+
+func utilRolloutIsPercentage(rollout int) bool {
+	return 0 <= rollout && rollout <= 100
+}
+
+// utilRolloutBucket returns the bucket (0-99) of the machine for the package.
+// The bucket is stable for the machine and the package, and independent between packages.
+func utilRolloutBucket(machineId, packageName string) int {
+	h := sha256.Sum256([]byte(machineId + "\x00" + packageName))
+	return int(binary.BigEndian.Uint64(h[:8]) % 100)
+}
+
+// utilRolloutIncludes returns true if the machine of the bucket receives the version of the rollout.
+func utilRolloutIncludes(bucket int, rollout *int) bool {
+	if rollout == nil {
+		return true
+	}
+	return bucket < *rollout
+}
+
+// utilRolloutMachineId returns the stable machine ID. The machine ID of the OS is used if available,
+// otherwise the random ID is generated and recorded in the cellar.
+func utilRolloutMachineId(cellarPath string) (machineId string, err error) {
+	if runtime.GOOS == "linux" {
+		// the host ID of gopsutil prefers the product UUID that is readable only by root,
+		// then the result differs between users.
+		for _, p := range rolloutLinuxMachineIdPaths {
+			if content, err := os.ReadFile(p); err == nil && strings.TrimSpace(string(content)) != "" {
+				return strings.TrimSpace(string(content)), nil
+			}
+		}
+	} else if id, err := host.HostID(); err == nil && id != "" {
+		return id, nil
+	}
+
+	idPath := filepath.Join(cellarPath, RolloutMachineIdName)
+	if content, err := os.ReadFile(idPath); err == nil && strings.TrimSpace(string(content)) != "" {
+		return strings.TrimSpace(string(content)), nil
+	}
+	seed := make([]byte, 16)
+	if _, err := rand.Read(seed); err != nil {
+		return "", err
+	}
+	machineId = hex.EncodeToString(seed)
+	if err := os.MkdirAll(cellarPath, 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(idPath, []byte(machineId+"\n"), 0644); err != nil {
+		return "", err
+	}
+	return machineId, nil
+}
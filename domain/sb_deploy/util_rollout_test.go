@@ -0,0 +1,96 @@
+package sb_deploy
+
+import (
+	"github.com/watermint/toolbox/infra/control/app_control"
+	"github.com/watermint/toolbox/quality/recipe/qtr_endtoend"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestUtilRolloutBucket(t *testing.T) {
+	if utilRolloutBucket("machine-a", "myapp") != utilRolloutBucket("machine-a", "myapp") {
+		t.Error("not deterministic")
+	}
+	for i := 0; i < 100; i++ {
+		if b := utilRolloutBucket(strconv.Itoa(i), "myapp"); b < 0 || 100 <= b {
+			t.Error(i, b)
+		}
+	}
+	zero, full := 0, 100
+	if utilRolloutIncludes(0, &zero) || !utilRolloutIncludes(99, &full) || !utilRolloutIncludes(99, nil) {
+		t.Error("includes")
+	}
+
+	cellarPath := t.TempDir()
+	id1, err := utilRolloutMachineId(cellarPath)
+	if err != nil || id1 == "" {
+		t.Error(id1, err)
+	}
+	if id2, err := utilRolloutMachineId(cellarPath); err != nil || id1 != id2 {
+		t.Error(id1, id2, err)
+	}
+}
+
+func TestBinDstLocal_Rollout(t *testing.T) {
+	qtr_endtoend.TestWithControl(t, func(ctl app_control.Control) {
+		sourcePath := t.TempDir()
+		testLocalDirSource(t, sourcePath, "linux-amd64", "1.0.0", "1.1.0")
+		cellarPath := filepath.Join(t.TempDir(), "cellar")
+		worker := NewBinSrcLocalDirDstLocal(BinSrcLocalDirDstLocalRecipe{
+			SourcePath: sourcePath,
+			BinDstLocalRecipe: BinDstLocalRecipe{
+				BinaryName: "myapp",
+				Prefix:     "myapp",
+				Suffix:     "linux-amd64",
+				CellarPath: cellarPath,
+			},
+		}, ctl)
+		machineId, err := utilRolloutMachineId(cellarPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bucket := utilRolloutBucket(machineId, "myapp")
+
+		// the machine is out of the cohort
+		marker := filepath.Join(sourcePath, "myapp-1.1.0", RolloutMarkerPrefix+strconv.Itoa(bucket))
+		if err := os.WriteFile(marker, []byte{}, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, v, err := worker.GetLocalLatest(); err != nil || v.String() != "1.0.0" {
+			t.Error(v, err)
+		}
+		if required, err := worker.IsUpdateRequired(); err != nil || required {
+			t.Error(required, err)
+		}
+
+		// the rollout is expanded to the machine
+		if err := os.Remove(marker); err != nil {
+			t.Fatal(err)
+		}
+		marker = filepath.Join(sourcePath, "myapp-1.1.0", RolloutMarkerPrefix+strconv.Itoa(bucket+1))
+		if err := os.WriteFile(marker, []byte{}, 0644); err != nil {
+			t.Fatal(err)
+		}
+		caches, _ := filepath.Glob(filepath.Join(ctl.Workspace().Cache(), BinDstLocalVersionCacheName+"*"))
+		for _, c := range caches {
+			if err := os.Remove(c); err != nil {
+				t.Fatal(err)
+			}
+		}
+		_, _, metas, err := worker.ListRemoteVersions()
+		if err != nil || metas["1.1.0"].Rollout == nil || *metas["1.1.0"].Rollout != bucket+1 {
+			t.Error(metas, err)
+		}
+		if required, err := worker.IsUpdateRequired(); err != nil || !required {
+			t.Error(required, err)
+		}
+		if err := worker.UpdateIfRequired(); err != nil {
+			t.Error(err)
+		}
+		if _, v, err := worker.GetLocalLatest(); err != nil || v.String() != "1.1.0" {
+			t.Error(v, err)
+		}
+	})
+}
@@ -0,0 +1,114 @@
+package sb_deploy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	utilS3Algorithm        = "AWS4-HMAC-SHA256"
+	utilS3Service          = "s3"
+	utilS3EmptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// utilS3Credential is the credential for S3 compatible storage.
+type utilS3Credential struct {
+	AccessKeyId     string
+	SecretAccessKey string
+}
+
+func (z utilS3Credential) IsAnonymous() bool {
+	return z.AccessKeyId == "" || z.SecretAccessKey == ""
+}
+
+// utilS3UriEncode encodes the string as defined in the AWS Signature Version 4.
+func utilS3UriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteString("%")
+			b.WriteString(strings.ToUpper(hex.EncodeToString([]byte{c})))
+		}
+	}
+	return b.String()
+}
+
+func utilS3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0)
+	for _, k := range keys {
+		values := append([]string{}, query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, utilS3UriEncode(k, true)+"="+utilS3UriEncode(v, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func utilS3Hmac(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// utilS3SignHeaders returns headers of the GET request signed by AWS Signature Version 4.
+// Returns headers without the authorization header if the credential is anonymous.
+func utilS3SignHeaders(cred utilS3Credential, region string, u *url.URL, now time.Time) map[string]string {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	header := map[string]string{
+		"x-amz-content-sha256": utilS3EmptyPayloadHash,
+		"x-amz-date":           amzDate,
+	}
+	if cred.IsAnonymous() {
+		return header
+	}
+
+	date := amzDate[:8]
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		"GET",
+		path,
+		utilS3CanonicalQuery(u.Query()),
+		"host:" + u.Host + "\n" +
+			"x-amz-content-sha256:" + utilS3EmptyPayloadHash + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		utilS3EmptyPayloadHash,
+	}, "\n")
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	scope := date + "/" + region + "/" + utilS3Service + "/aws4_request"
+	stringToSign := utilS3Algorithm + "\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalRequestHash[:])
+
+	signingKey := utilS3Hmac([]byte("AWS4"+cred.SecretAccessKey), date)
+	signingKey = utilS3Hmac(signingKey, region)
+	signingKey = utilS3Hmac(signingKey, utilS3Service)
+	signingKey = utilS3Hmac(signingKey, "aws4_request")
+	signature := hex.EncodeToString(utilS3Hmac(signingKey, stringToSign))
+
+	header["Authorization"] = utilS3Algorithm +
+		" Credential=" + cred.AccessKeyId + "/" + scope +
+		",SignedHeaders=" + signedHeaders +
+		",Signature=" + signature
+	return header
+}
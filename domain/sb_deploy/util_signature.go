@@ -0,0 +1,200 @@
+package sb_deploy
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"golang.org/x/crypto/blake2b"
+	"strings"
+)
+
+const (
+	// SignatureMinisignExtension is the extension of the minisign signature of the asset, like `ASSET.minisig`.
+	SignatureMinisignExtension = ".minisig"
+
+	// SignatureEd25519Extension is the extension of the raw ed25519 signature of the asset, like `ASSET.sig`.
+	// The content is the 64 bytes signature, or the signature in base64 or hex string.
+	SignatureEd25519Extension = ".sig"
+)
+
+const (
+	signatureMinisignAlgPure      = "Ed"
+	signatureMinisignAlgPrehashed = "ED"
+	signatureMinisignKeyIdLen     = 8
+	signatureMinisignCommentTag   = "trusted comment: "
+)
+
+var (
+	ErrorInvalidPublicKey  = errors.New("invalid public key")
+	ErrorSignatureNotFound = errors.New("signature not found")
+	ErrorSignatureInvalid  = errors.New("signature invalid")
+)
+
+// SignaturePublicKey is the pinned public key.
+type SignaturePublicKey struct {
+	// Minisign is true if the key is the minisign public key, otherwise the raw ed25519 public key.
+	Minisign bool
+
+	// KeyId is the key id of the minisign public key.
+	KeyId []byte
+
+	// Key is the ed25519 public key.
+	Key ed25519.PublicKey
+}
+
+// utilSignatureParsePublicKey parses the public key in the recipe.
+// Supports the minisign public key (base64 string, or the content of the `.pub` file with the untrusted comment),
+// and the raw ed25519 public key in base64 or hex string.
+func utilSignatureParsePublicKey(s string) (key SignaturePublicKey, err error) {
+	line := ""
+	for _, l := range strings.Split(strings.TrimSpace(s), "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" || strings.HasPrefix(l, "untrusted comment:") {
+			continue
+		}
+		line = l
+	}
+
+	if data, err := base64.StdEncoding.DecodeString(line); err == nil {
+		switch {
+		case len(data) == 2+signatureMinisignKeyIdLen+ed25519.PublicKeySize && string(data[:2]) == signatureMinisignAlgPure:
+			return SignaturePublicKey{
+				Minisign: true,
+				KeyId:    data[2 : 2+signatureMinisignKeyIdLen],
+				Key:      data[2+signatureMinisignKeyIdLen:],
+			}, nil
+		case len(data) == ed25519.PublicKeySize:
+			return SignaturePublicKey{Key: data}, nil
+		}
+	}
+	if data, err := hex.DecodeString(line); err == nil && len(data) == ed25519.PublicKeySize {
+		return SignaturePublicKey{Key: data}, nil
+	}
+	return SignaturePublicKey{}, ErrorInvalidPublicKey
+}
+
+// utilSignatureParsePublicKeys parses all public keys in the recipe.
+func utilSignatureParsePublicKeys(keys []string) (parsed []SignaturePublicKey, err error) {
+	parsed = make([]SignaturePublicKey, 0, len(keys))
+	for _, k := range keys {
+		key, err := utilSignatureParsePublicKey(k)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, key)
+	}
+	return parsed, nil
+}
+
+// utilSignatureVerifyMinisign verifies the minisign signature of the content.
+// Both the signature of the content and the global signature of the trusted comment must be valid.
+func utilSignatureVerifyMinisign(key SignaturePublicKey, content, signature []byte) error {
+	lines := make([]string, 0, 4)
+	for _, l := range strings.Split(string(signature), "\n") {
+		if l = strings.TrimRight(l, "\r"); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	if len(lines) < 4 || !strings.HasPrefix(lines[2], signatureMinisignCommentTag) {
+		return ErrorSignatureInvalid
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(sig) != 2+signatureMinisignKeyIdLen+ed25519.SignatureSize {
+		return ErrorSignatureInvalid
+	}
+	globalSig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || len(globalSig) != ed25519.SignatureSize {
+		return ErrorSignatureInvalid
+	}
+
+	alg, keyId, sigBody := string(sig[:2]), sig[2:2+signatureMinisignKeyIdLen], sig[2+signatureMinisignKeyIdLen:]
+	if !bytes.Equal(keyId, key.KeyId) {
+		return ErrorSignatureInvalid
+	}
+	message := content
+	switch alg {
+	case signatureMinisignAlgPure:
+	case signatureMinisignAlgPrehashed:
+		h := blake2b.Sum512(content)
+		message = h[:]
+	default:
+		return ErrorSignatureInvalid
+	}
+	if !ed25519.Verify(key.Key, message, sigBody) {
+		return ErrorSignatureInvalid
+	}
+
+	trustedComment := strings.TrimPrefix(lines[2], signatureMinisignCommentTag)
+	if !ed25519.Verify(key.Key, append(append([]byte{}, sigBody...), trustedComment...), globalSig) {
+		return ErrorSignatureInvalid
+	}
+	return nil
+}
+
+// utilSignatureVerifyEd25519 verifies the raw ed25519 signature of the content.
+func utilSignatureVerifyEd25519(key SignaturePublicKey, content, signature []byte) error {
+	sig := signature
+	if len(sig) != ed25519.SignatureSize {
+		text := strings.TrimSpace(string(signature))
+		if decoded, err := base64.StdEncoding.DecodeString(text); err == nil && len(decoded) == ed25519.SignatureSize {
+			sig = decoded
+		} else if decoded, err := hex.DecodeString(text); err == nil && len(decoded) == ed25519.SignatureSize {
+			sig = decoded
+		}
+	}
+	if len(sig) != ed25519.SignatureSize || !ed25519.Verify(key.Key, content, sig) {
+		return ErrorSignatureInvalid
+	}
+	return nil
+}
+
+// utilSignatureVerify verifies the content with signatures retrieved by the fetch function.
+// The fetch function returns the content of the sidecar of the asset with the extension, or found=false if no such file.
+// The content is valid if any of pinned keys verifies the signature.
+func utilSignatureVerify(keys []SignaturePublicKey, content []byte, fetch func(extension string) (signature []byte, found bool, err error)) error {
+	signatures := make(map[string][]byte)
+	for _, key := range keys {
+		extension := SignatureEd25519Extension
+		if key.Minisign {
+			extension = SignatureMinisignExtension
+		}
+		if _, ok := signatures[extension]; ok {
+			continue
+		}
+		signature, found, err := fetch(extension)
+		if err != nil {
+			return err
+		}
+		if found {
+			signatures[extension] = signature
+		} else {
+			signatures[extension] = nil
+		}
+	}
+
+	found := false
+	for _, key := range keys {
+		var err error
+		if key.Minisign {
+			if signatures[SignatureMinisignExtension] == nil {
+				continue
+			}
+			err = utilSignatureVerifyMinisign(key, content, signatures[SignatureMinisignExtension])
+		} else {
+			if signatures[SignatureEd25519Extension] == nil {
+				continue
+			}
+			err = utilSignatureVerifyEd25519(key, content, signatures[SignatureEd25519Extension])
+		}
+		found = true
+		if err == nil {
+			return nil
+		}
+	}
+	if !found {
+		return ErrorSignatureNotFound
+	}
+	return ErrorSignatureInvalid
+}
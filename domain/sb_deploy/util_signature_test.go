@@ -0,0 +1,227 @@
+package sb_deploy
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/watermint/toolbox/essentials/strings/es_version"
+	"github.com/watermint/toolbox/infra/control/app_control"
+	"github.com/watermint/toolbox/quality/recipe/qtr_endtoend"
+	"golang.org/x/crypto/blake2b"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testSignatureKey struct {
+	pub   ed25519.PublicKey
+	priv  ed25519.PrivateKey
+	keyId []byte
+}
+
+func testSignatureNewKey(t *testing.T) testSignatureKey {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyId := make([]byte, signatureMinisignKeyIdLen)
+	if _, err := rand.Read(keyId); err != nil {
+		t.Fatal(err)
+	}
+	return testSignatureKey{pub: pub, priv: priv, keyId: keyId}
+}
+
+// minisignPublicKey returns the content of the minisign `.pub` file.
+func (z testSignatureKey) minisignPublicKey() string {
+	data := append(append([]byte(signatureMinisignAlgPure), z.keyId...), z.pub...)
+	return "untrusted comment: minisign public key\n" + base64.StdEncoding.EncodeToString(data) + "\n"
+}
+
+// minisign returns the content of the `.minisig` file.
+func (z testSignatureKey) minisign(content []byte, prehashed bool) []byte {
+	alg, message := signatureMinisignAlgPure, content
+	if prehashed {
+		h := blake2b.Sum512(content)
+		alg, message = signatureMinisignAlgPrehashed, h[:]
+	}
+	sig := ed25519.Sign(z.priv, message)
+	trustedComment := "timestamp:1700000000\tfile:asset"
+	globalSig := ed25519.Sign(z.priv, append(append([]byte{}, sig...), trustedComment...))
+	return []byte("untrusted comment: signature from minisign secret key\n" +
+		base64.StdEncoding.EncodeToString(append(append([]byte(alg), z.keyId...), sig...)) + "\n" +
+		signatureMinisignCommentTag + trustedComment + "\n" +
+		base64.StdEncoding.EncodeToString(globalSig) + "\n")
+}
+
+func TestUtilSignatureParsePublicKey(t *testing.T) {
+	key := testSignatureNewKey(t)
+	if k, err := utilSignatureParsePublicKey(key.minisignPublicKey()); err != nil || !k.Minisign || !key.pub.Equal(k.Key) {
+		t.Error(k, err)
+	}
+	if k, err := utilSignatureParsePublicKey(base64.StdEncoding.EncodeToString(key.pub)); err != nil || k.Minisign || !key.pub.Equal(k.Key) {
+		t.Error(k, err)
+	}
+	if k, err := utilSignatureParsePublicKey(hex.EncodeToString(key.pub)); err != nil || k.Minisign || !key.pub.Equal(k.Key) {
+		t.Error(k, err)
+	}
+	if _, err := utilSignatureParsePublicKey("not a key"); !errors.Is(err, ErrorInvalidPublicKey) {
+		t.Error(err)
+	}
+}
+
+func TestUtilSignatureVerify(t *testing.T) {
+	key := testSignatureNewKey(t)
+	other := testSignatureNewKey(t)
+	minisignKey, err := utilSignatureParsePublicKey(key.minisignPublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawKey, err := utilSignatureParsePublicKey(base64.StdEncoding.EncodeToString(key.pub))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("asset content")
+	rawSig := ed25519.Sign(key.priv, content)
+
+	cases := []struct {
+		keys       []SignaturePublicKey
+		signatures map[string][]byte
+		expected   error
+	}{
+		{[]SignaturePublicKey{minisignKey}, map[string][]byte{SignatureMinisignExtension: key.minisign(content, false)}, nil},
+		{[]SignaturePublicKey{minisignKey}, map[string][]byte{SignatureMinisignExtension: key.minisign(content, true)}, nil},
+		{[]SignaturePublicKey{minisignKey}, map[string][]byte{SignatureMinisignExtension: key.minisign([]byte("tampered"), true)}, ErrorSignatureInvalid},
+		{[]SignaturePublicKey{minisignKey}, map[string][]byte{SignatureMinisignExtension: other.minisign(content, false)}, ErrorSignatureInvalid},
+		{[]SignaturePublicKey{minisignKey}, map[string][]byte{SignatureEd25519Extension: rawSig}, ErrorSignatureNotFound},
+		{[]SignaturePublicKey{rawKey}, map[string][]byte{SignatureEd25519Extension: rawSig}, nil},
+		{[]SignaturePublicKey{rawKey}, map[string][]byte{SignatureEd25519Extension: []byte(base64.StdEncoding.EncodeToString(rawSig) + "\n")}, nil},
+		{[]SignaturePublicKey{rawKey}, map[string][]byte{SignatureEd25519Extension: []byte(hex.EncodeToString(rawSig))}, nil},
+		{[]SignaturePublicKey{rawKey}, map[string][]byte{SignatureEd25519Extension: ed25519.Sign(other.priv, content)}, ErrorSignatureInvalid},
+		{[]SignaturePublicKey{minisignKey, rawKey}, map[string][]byte{SignatureEd25519Extension: rawSig}, nil},
+	}
+	for i, c := range cases {
+		err := utilSignatureVerify(c.keys, content, func(extension string) (signature []byte, found bool, err error) {
+			signature, found = c.signatures[extension]
+			return signature, found, nil
+		})
+		if !errors.Is(err, c.expected) || (c.expected == nil && err != nil) {
+			t.Error(i, err)
+		}
+	}
+}
+
+func TestBinDstLocal_Signature(t *testing.T) {
+	qtr_endtoend.TestWithControl(t, func(ctl app_control.Control) {
+		key := testSignatureNewKey(t)
+		other := testSignatureNewKey(t)
+		sourcePath := t.TempDir()
+		testLocalDirSource(t, sourcePath, "linux-amd64", "1.0.0", "1.1.0", "1.2.0", "1.3.0")
+		assetPath := func(v string) string {
+			return filepath.Join(sourcePath, "myapp-"+v, "myapp-"+v+"-linux-amd64.zip")
+		}
+		sign := func(v string, k testSignatureKey) {
+			content, err := os.ReadFile(assetPath(v))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(assetPath(v)+SignatureMinisignExtension, k.minisign(content, true), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// 1.0.0, 1.1.0: signed, 1.2.0: signed by other key, 1.3.0: unsigned
+		sign("1.0.0", key)
+		sign("1.1.0", key)
+		sign("1.2.0", other)
+
+		cellarPath := filepath.Join(t.TempDir(), "cellar")
+		worker := NewBinSrcLocalDirDstLocal(BinSrcLocalDirDstLocalRecipe{
+			SourcePath: sourcePath,
+			BinDstLocalRecipe: BinDstLocalRecipe{
+				BinaryName: "myapp",
+				Prefix:     "myapp",
+				Suffix:     "linux-amd64",
+				CellarPath: cellarPath,
+				PublicKeys: []string{key.minisignPublicKey()},
+			},
+		}, ctl)
+		rejectedPath := filepath.Join(cellarPath, BinDstLocalRejectedPrefix+"myapp.json")
+		rejectedRecord := func() map[string]BinDstLocalRejected {
+			records := make(map[string]BinDstLocalRejected)
+			data, err := os.ReadFile(rejectedPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal(data, &records); err != nil {
+				t.Fatal(err)
+			}
+			return records
+		}
+
+		// fall back to 1.1.0, and record rejected versions
+		if err := worker.UpdateIfRequired(); err != nil {
+			t.Error(err)
+		}
+		localVersions, _, err := worker.ListLocalVersions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if latest := es_version.Max(localVersions...); latest.String() != "1.1.0" {
+			t.Error(latest)
+		}
+		records := rejectedRecord()
+		for _, v := range []string{"1.2.0", "1.3.0"} {
+			if r, found := records[v]; !found || len(r.Sha256) != 64 || r.Path == "" {
+				t.Error(v, r, found)
+			}
+		}
+
+		// rejected versions are not retried
+		if required, err := worker.IsUpdateRequired(); err != nil || required {
+			t.Error(required, err)
+		}
+		if status, err := worker.ListRemoteVersionStatus(); err != nil || len(status) != 4 || status[0].Latest || status[1].Latest || !status[2].Latest {
+			t.Error(status, err)
+		}
+
+		// no version newer than 1.1.0 is valid, then never downgrade
+		if err := worker.UpdateForce(); !errors.Is(err, ErrorVersionRejected) || !errors.Is(err, ErrorSignatureNotFound) || !errors.Is(err, ErrorSignatureInvalid) {
+			t.Error(err)
+		}
+		if binPath, version, err := worker.GetLocalLatest(); err != nil || version.String() != "1.1.0" {
+			t.Error(binPath, version, err)
+		}
+
+		// 1.3.0 is signed, then retried after the rejection is expired
+		sign("1.3.0", key)
+		if required, err := worker.IsUpdateRequired(); err != nil || required {
+			t.Error(required, err)
+		}
+		records = rejectedRecord()
+		expired := records["1.3.0"]
+		expired.RejectedAt = time.Now().Add(-BinDstLocalVersionCacheLifecycle * time.Second).UTC().Format(time.RFC3339)
+		records["1.3.0"] = expired
+		data, err := json.Marshal(records)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(rejectedPath, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if required, err := worker.IsUpdateRequired(); err != nil || !required {
+			t.Error(required, err)
+		}
+		if err := worker.UpdateIfRequired(); err != nil {
+			t.Error(err)
+		}
+		if binPath, version, err := worker.GetLocalLatest(); err != nil || version.String() != "1.3.0" {
+			t.Error(binPath, version, err)
+		}
+		if records := rejectedRecord(); len(records) != 1 {
+			t.Error(records)
+		}
+	})
+}
@@ -0,0 +1,85 @@
+package sb_deploy
+
+import (
+	"github.com/watermint/toolbox/essentials/strings/es_version"
+	"strconv"
+	"strings"
+)
+
+// utilSourceIsAsset returns true if the file name is the asset of the platform.
+// The asset is named like `PREFIX-VERSION-SUFFIX.zip`, `PREFIX-VERSION-SUFFIX.tar.gz` (or other supported archives),
+// or `PREFIX-VERSION-SUFFIX` (`.exe`) for the raw binary.
+func utilSourceIsAsset(prefix, suffix, fileName string) bool {
+	fullPrefix := prefix + "-"
+	fullFileSuffix := "-" + suffix
+	if !strings.HasPrefix(fileName, fullPrefix) {
+		return false
+	}
+	if strings.HasSuffix(fileName, fullFileSuffix) || strings.HasSuffix(fileName, fullFileSuffix+".exe") {
+		return true
+	}
+	for _, ext := range utilArchiveExtensions {
+		if strings.HasSuffix(fileName, fullFileSuffix+ext.Extension) {
+			return true
+		}
+	}
+	return false
+}
+
+// utilSourceParseVersion parses the version of the entry that follows the folder structure
+// `PREFIX-VERSION/PREFIX-VERSION-SUFFIX.zip`. Returns false if the entry does not follow the structure.
+// See utilSourceIsAsset for supported names of the asset.
+func utilSourceParseVersion(prefix, suffix, folderName, fileName string) (version es_version.Version, ok bool) {
+	fullPrefix := prefix + "-"
+	if !utilSourceIsAsset(prefix, suffix, fileName) {
+		return es_version.Zero(), false
+	}
+	if !strings.HasPrefix(folderName, fullPrefix) {
+		return es_version.Zero(), false
+	}
+	ver, err := es_version.Parse(strings.TrimPrefix(folderName, fullPrefix))
+	if err != nil {
+		return es_version.Zero(), false
+	}
+	return ver, true
+}
+
+// utilSourceParseMarker parses the marker in the version folder `PREFIX-VERSION/`, such as `CHANNEL.beta` or `ROLLOUT.10`,
+// then updates the metadata of the version. Returns false if the entry is not a marker.
+func utilSourceParseMarker(prefix, folderName, fileName string, metas map[string]BinRemoteVersionMeta) bool {
+	verStr, found := strings.CutPrefix(folderName, prefix+"-")
+	if !found {
+		return false
+	}
+	ver, err := es_version.Parse(verStr)
+	if err != nil {
+		return false
+	}
+	meta := metas[ver.String()]
+	if !utilSourceApplyMarker(fileName, &meta) {
+		return false
+	}
+	metas[ver.String()] = meta
+	return true
+}
+
+// utilSourceApplyMarker applies the marker to the metadata. Returns false if the name is not a valid marker.
+func utilSourceApplyMarker(name string, meta *BinRemoteVersionMeta) bool {
+	if c, found := strings.CutPrefix(name, ChannelMarkerPrefix); found {
+		channel, ok := utilChannelNormalize(c)
+		if !ok {
+			return false
+		}
+		meta.Channel = channel
+		return true
+	}
+	if r, found := strings.CutPrefix(name, RolloutMarkerPrefix); found {
+		rollout, err := strconv.Atoi(r)
+		if err != nil || !utilRolloutIsPercentage(rollout) {
+			return false
+		}
+		meta.Rollout = &rollout
+		return true
+	}
+	return false
+}
@@ -0,0 +1,234 @@
+package sb_deploy
+
+import (
+	"errors"
+	"github.com/watermint/toolbox/essentials/strings/es_version"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrorInvalidVersionConstraint = errors.New("invalid version constraint")
+)
+
+const (
+	versionComparatorEq = "="
+	versionComparatorGt = ">"
+	versionComparatorGe = ">="
+	versionComparatorLt = "<"
+	versionComparatorLe = "<="
+)
+
+type versionComparator struct {
+	op      string
+	version es_version.Version
+}
+
+func (z versionComparator) match(v es_version.Version) bool {
+	c := es_version.Compare(v, z.version)
+	switch z.op {
+	case versionComparatorEq:
+		return c == 0
+	case versionComparatorGt:
+		return c > 0
+	case versionComparatorGe:
+		return c >= 0
+	case versionComparatorLt:
+		// pre-releases of the upper bound are not lower than the bound, e.g. `<2.0.0` excludes `2.0.0-beta`.
+		if z.version.PreRelease == "" && v.PreRelease != "" &&
+			v.Major == z.version.Major && v.Minor == z.version.Minor && v.Patch == z.version.Patch {
+			return false
+		}
+		return c < 0
+	case versionComparatorLe:
+		return c <= 0
+	default:
+		return false
+	}
+}
+
+// VersionConstraint is the parsed version constraint.
+// The version satisfies the constraint if the version satisfies all comparators of any of sets.
+type VersionConstraint struct {
+	sets [][]versionComparator
+}
+
+// Match returns true if the version satisfies the constraint. The empty constraint matches any version.
+func (z VersionConstraint) Match(v es_version.Version) bool {
+	if len(z.sets) < 1 {
+		return true
+	}
+	for _, set := range z.sets {
+		matched := true
+		for _, c := range set {
+			if !c.match(v) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// partialVersion is the version that may omit minor or patch, like `1.4` or `1.x`.
+type partialVersion struct {
+	// numbers is the count of specified numbers (0-3).
+	numbers    int
+	major      uint64
+	minor      uint64
+	patch      uint64
+	preRelease string
+}
+
+func (z partialVersion) version() es_version.Version {
+	return es_version.Version{Major: z.major, Minor: z.minor, Patch: z.patch, PreRelease: z.preRelease}
+}
+
+// next returns the lowest version that is not covered by the partial version, e.g. `1.5.0` for `1.4`.
+func (z partialVersion) next() es_version.Version {
+	switch z.numbers {
+	case 1:
+		return es_version.Version{Major: z.major + 1}
+	case 2:
+		return es_version.Version{Major: z.major, Minor: z.minor + 1}
+	default:
+		return es_version.Version{Major: z.major, Minor: z.minor, Patch: z.patch + 1}
+	}
+}
+
+func utilVersionConstraintParsePartial(s string) (p partialVersion, err error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "v"), "V")
+	if v, err := es_version.Parse(s); err == nil {
+		return partialVersion{numbers: 3, major: v.Major, minor: v.Minor, patch: v.Patch, preRelease: v.PreRelease}, nil
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return p, ErrorInvalidVersionConstraint
+	}
+	wildcard := false
+	for i, part := range parts {
+		if part == "x" || part == "X" || part == "*" {
+			wildcard = true
+			continue
+		}
+		if wildcard {
+			// numbers after the wildcard, like `1.x.3`
+			return p, ErrorInvalidVersionConstraint
+		}
+		n, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return p, ErrorInvalidVersionConstraint
+		}
+		switch i {
+		case 0:
+			p.major = n
+		case 1:
+			p.minor = n
+		case 2:
+			p.patch = n
+		}
+		p.numbers = i + 1
+	}
+	return p, nil
+}
+
+func utilVersionConstraintParseComparator(s string) (comparators []versionComparator, err error) {
+	op := ""
+	for _, o := range []string{">=", "<=", ">", "<", "=", "~", "^"} {
+		if strings.HasPrefix(s, o) {
+			op = o
+			break
+		}
+	}
+	p, err := utilVersionConstraintParsePartial(strings.TrimPrefix(s, op))
+	if err != nil {
+		return nil, err
+	}
+	if p.numbers == 0 {
+		if op == "" || op == "=" {
+			return []versionComparator{}, nil
+		}
+		return nil, ErrorInvalidVersionConstraint
+	}
+	within := func(upper es_version.Version) []versionComparator {
+		return []versionComparator{
+			{op: versionComparatorGe, version: p.version()},
+			{op: versionComparatorLt, version: upper},
+		}
+	}
+
+	switch op {
+	case "", "=":
+		if p.numbers == 3 {
+			return []versionComparator{{op: versionComparatorEq, version: p.version()}}, nil
+		}
+		return within(p.next()), nil
+	case "~":
+		if p.numbers == 1 {
+			return within(p.next()), nil
+		}
+		return within(es_version.Version{Major: p.major, Minor: p.minor + 1}), nil
+	case "^":
+		switch {
+		case p.major > 0 || p.numbers == 1:
+			return within(es_version.Version{Major: p.major + 1}), nil
+		case p.minor > 0 || p.numbers == 2:
+			return within(es_version.Version{Major: 0, Minor: p.minor + 1}), nil
+		default:
+			return within(p.next()), nil
+		}
+	case ">=":
+		return []versionComparator{{op: versionComparatorGe, version: p.version()}}, nil
+	case ">":
+		if p.numbers == 3 {
+			return []versionComparator{{op: versionComparatorGt, version: p.version()}}, nil
+		}
+		return []versionComparator{{op: versionComparatorGe, version: p.next()}}, nil
+	case "<":
+		return []versionComparator{{op: versionComparatorLt, version: p.version()}}, nil
+	case "<=":
+		if p.numbers == 3 {
+			return []versionComparator{{op: versionComparatorLe, version: p.version()}}, nil
+		}
+		return []versionComparator{{op: versionComparatorLt, version: p.next()}}, nil
+	default:
+		return nil, ErrorInvalidVersionConstraint
+	}
+}
+
+// utilVersionConstraintParse parses the version constraint. Supported forms are:
+// the exact version `1.4.2`, the partial version `1.4` or `1.x` (any 1.4.x), comparators `>=2.0 <3.0`
+// (space or comma separated, all must match), the tilde range `~1.4.2` (>=1.4.2 <1.5.0),
+// the caret range `^1.4` (>=1.4.0 <2.0.0), and alternatives separated by `||`.
+// The empty constraint matches any version.
+func utilVersionConstraintParse(s string) (constraint VersionConstraint, err error) {
+	constraint = VersionConstraint{sets: make([][]versionComparator, 0)}
+	if strings.TrimSpace(s) == "" {
+		return constraint, nil
+	}
+	for _, alt := range strings.Split(s, "||") {
+		tokens := strings.Fields(strings.ReplaceAll(alt, ",", " "))
+		if len(tokens) < 1 {
+			return constraint, ErrorInvalidVersionConstraint
+		}
+		set := make([]versionComparator, 0)
+		for i := 0; i < len(tokens); i++ {
+			token := tokens[i]
+			// operator separated from the version, like `>= 2.0`
+			if strings.Trim(token, "<>=~^") == "" && i+1 < len(tokens) {
+				i++
+				token += tokens[i]
+			}
+			comparators, err := utilVersionConstraintParseComparator(token)
+			if err != nil {
+				return constraint, err
+			}
+			set = append(set, comparators...)
+		}
+		constraint.sets = append(constraint.sets, set)
+	}
+	return constraint, nil
+}
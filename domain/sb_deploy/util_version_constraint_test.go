@@ -0,0 +1,108 @@
+package sb_deploy
+
+import (
+	"github.com/watermint/toolbox/essentials/strings/es_version"
+	"github.com/watermint/toolbox/infra/control/app_control"
+	"github.com/watermint/toolbox/quality/recipe/qtr_endtoend"
+	"path/filepath"
+	"testing"
+)
+
+func TestUtilVersionConstraintParse(t *testing.T) {
+	cases := []struct {
+		constraint string
+		matches    []string
+		unmatches  []string
+	}{
+		{"", []string{"0.0.1", "1.4.2", "3.0.0-beta"}, nil},
+		{"1.4.2", []string{"1.4.2"}, []string{"1.4.1", "1.4.3", "1.4.2-beta"}},
+		{"=v1.4.2", []string{"1.4.2"}, []string{"1.4.3"}},
+		{"1.4", []string{"1.4.0", "1.4.9"}, []string{"1.3.9", "1.5.0"}},
+		{"1.x", []string{"1.0.0", "1.9.9"}, []string{"0.9.9", "2.0.0"}},
+		{"*", []string{"0.0.1", "9.9.9"}, nil},
+		{"~1.4", []string{"1.4.0", "1.4.9"}, []string{"1.3.9", "1.5.0", "1.5.0-beta"}},
+		{"~1.4.2", []string{"1.4.2", "1.4.9"}, []string{"1.4.1", "1.5.0"}},
+		{"~1", []string{"1.0.0", "1.9.0"}, []string{"2.0.0"}},
+		{"^1.4", []string{"1.4.0", "1.9.9"}, []string{"1.3.9", "2.0.0"}},
+		{"^0.3.1", []string{"0.3.1", "0.3.9"}, []string{"0.3.0", "0.4.0"}},
+		{"^0.0.3", []string{"0.0.3"}, []string{"0.0.4"}},
+		{">=2.0 <3.0", []string{"2.0.0", "2.9.9"}, []string{"1.9.9", "3.0.0", "3.0.0-beta"}},
+		{">= 2.0, < 3.0", []string{"2.0.0", "2.9.9"}, []string{"1.9.9", "3.0.0"}},
+		{">1.4", []string{"1.5.0"}, []string{"1.4.9"}},
+		{"<=1.4", []string{"1.4.9", "1.0.0"}, []string{"1.5.0"}},
+		{"<1.4.2 || >=2.0.0", []string{"1.4.1", "2.1.0"}, []string{"1.4.2", "1.9.0"}},
+	}
+	for _, c := range cases {
+		constraint, err := utilVersionConstraintParse(c.constraint)
+		if err != nil {
+			t.Error(c.constraint, err)
+			continue
+		}
+		for _, v := range c.matches {
+			if !constraint.Match(es_version.MustParse(v)) {
+				t.Error(c.constraint, v)
+			}
+		}
+		for _, v := range c.unmatches {
+			if constraint.Match(es_version.MustParse(v)) {
+				t.Error(c.constraint, v)
+			}
+		}
+	}
+
+	for _, invalid := range []string{"1.4.2.1", "latest", ">=", "1.x.3", "~*", "1.4 ||"} {
+		if _, err := utilVersionConstraintParse(invalid); err != ErrorInvalidVersionConstraint {
+			t.Error(invalid, err)
+		}
+	}
+}
+
+func TestBinDstLocal_VersionConstraint(t *testing.T) {
+	qtr_endtoend.TestWithControl(t, func(ctl app_control.Control) {
+		sourcePath := t.TempDir()
+		testLocalDirSource(t, sourcePath, "linux-amd64", "1.3.0", "1.4.0", "1.4.2", "2.0.0")
+		cellarPath := filepath.Join(t.TempDir(), "cellar")
+		newWorker := func(constraint string) BinDeploy {
+			return NewBinSrcLocalDirDstLocal(BinSrcLocalDirDstLocalRecipe{
+				SourcePath: sourcePath,
+				BinDstLocalRecipe: BinDstLocalRecipe{
+					BinaryName:        "myapp",
+					Prefix:            "myapp",
+					Suffix:            "linux-amd64",
+					CellarPath:        cellarPath,
+					VersionConstraint: constraint,
+				},
+			}, ctl)
+		}
+
+		// latest version
+		if _, v, err := newWorker("").GetLocalLatest(); err != nil || v.String() != "2.0.0" {
+			t.Error(v, err)
+		}
+
+		// held on 1.4.x with the same cellar
+		held := newWorker("~1.4")
+		if required, err := held.IsUpdateRequired(); err != nil || !required {
+			t.Error(required, err)
+		}
+		if err := held.UpdateIfRequired(); err != nil {
+			t.Error(err)
+		}
+		if _, v, err := held.GetLocalLatest(); err != nil || v.String() != "1.4.2" {
+			t.Error(v, err)
+		}
+		if p := held.LocalLatestBinaryPath(); filepath.Base(filepath.Dir(p)) != "myapp-1.4.2" {
+			t.Error(p)
+		}
+		if versions, _, _, err := held.ListRemoteVersions(); err != nil || len(versions) != 2 {
+			t.Error(versions, err)
+		}
+		if _, err := held.Rollback(""); err != ErrorNoPreviousVersion {
+			t.Error(err)
+		}
+
+		if _, _, err := newWorker(">=x").ListLocalVersions(); err != ErrorInvalidVersionConstraint {
+			t.Error(err)
+		}
+	})
+}
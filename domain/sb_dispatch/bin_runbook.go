@@ -2,4 +2,7 @@ package sb_dispatch
 
 type BinRunbook struct {
 	Args []string `json:"args"`
+
+	// Binary is the name or the alias of the binary to run in the package. The binary name of the deploy recipe is used if empty.
+	Binary string `json:"binary,omitempty"`
 }
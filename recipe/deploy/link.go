@@ -2,7 +2,6 @@ package deploy
 
 import (
 	"github.com/watermint/switchbox/domain/sb_deploy"
-	"github.com/watermint/toolbox/domain/dropbox/api/dbx_auth"
 	"github.com/watermint/toolbox/domain/dropbox/api/dbx_conn"
 	"github.com/watermint/toolbox/essentials/terminal/es_window"
 	"github.com/watermint/toolbox/infra/control/app_control"
@@ -19,12 +18,8 @@ type Link struct {
 }
 
 func (z *Link) Preset() {
-	z.Peer.SetScopes(
-		dbx_auth.ScopeFilesContentRead,
-		dbx_auth.ScopeFilesMetadataRead,
-		dbx_auth.ScopeSharingRead,
-	)
-	z.Deploy.SetModel(&sb_deploy.BinSrcDropboxDstLocalRecipe{})
+	z.Peer = sb_deploy.NewBinSrcDropboxConn()
+	z.Deploy.SetModel(&sb_deploy.BinDeployRecipe{})
 }
 
 func (z *Link) Exec(c app_control.Control) error {
@@ -34,15 +29,11 @@ func (z *Link) Exec(c app_control.Control) error {
 		l.Info("Hide console")
 	}
 
-	var deploy *sb_deploy.BinSrcDropboxDstLocalRecipe
-	if v, err := z.Deploy.Unmarshal(); err != nil {
+	worker, err := sb_deploy.NewBinDeploy(c, z.Deploy.FilePath(), z.Peer.PeerName())
+	if err != nil {
 		return err
-	} else {
-		deploy = v.(*sb_deploy.BinSrcDropboxDstLocalRecipe)
 	}
 
-	worker := sb_deploy.NewBinSrcDropboxDstLocal(*deploy, c, z.Peer.Client())
-
 	shouldUpdate := z.Force
 	if !shouldUpdate {
 		updateRequired, err := worker.IsUpdateRequired()
@@ -50,7 +41,7 @@ func (z *Link) Exec(c app_control.Control) error {
 			return err
 		}
 		shouldUpdate = updateRequired
-		if _, err := os.Lstat(deploy.DeployPath); os.IsNotExist(err) {
+		if _, err := os.Lstat(worker.DeployPath()); os.IsNotExist(err) {
 			shouldUpdate = true
 		}
 	}
@@ -59,8 +50,17 @@ func (z *Link) Exec(c app_control.Control) error {
 		return err
 	}
 
+	// relink if links are not to the version to use, e.g. the linked version is revoked.
+	if !shouldUpdate && !worker.IsDeployed() {
+		l.Info("Links are not to the version to use")
+		shouldUpdate = true
+	}
+
 	if shouldUpdate {
 		l.Info("Update required")
+		if z.Force {
+			return worker.DeploySymlinkForce()
+		}
 		return worker.DeploySymlink()
 	} else {
 		l.Info("No update required")
@@ -0,0 +1,46 @@
+package deploy
+
+import (
+	"github.com/watermint/switchbox/domain/sb_deploy"
+	"github.com/watermint/toolbox/domain/dropbox/api/dbx_conn"
+	"github.com/watermint/toolbox/infra/control/app_control"
+	"github.com/watermint/toolbox/infra/data/da_json"
+	"github.com/watermint/toolbox/infra/report/rp_model"
+	"github.com/watermint/toolbox/quality/infra/qt_errors"
+)
+
+type List struct {
+	Peer     dbx_conn.ConnScopedIndividual
+	Deploy   da_json.JsonInput
+	Versions rp_model.RowReport
+}
+
+func (z *List) Preset() {
+	z.Peer = sb_deploy.NewBinSrcDropboxConn()
+	z.Deploy.SetModel(&sb_deploy.BinDeployRecipe{})
+	z.Versions.SetModel(&sb_deploy.BinDeployRemoteVersion{})
+}
+
+func (z *List) Exec(c app_control.Control) error {
+	worker, err := sb_deploy.NewBinDeploy(c, z.Deploy.FilePath(), z.Peer.PeerName())
+	if err != nil {
+		return err
+	}
+
+	versions, err := worker.ListRemoteVersionStatus()
+	if err != nil {
+		return err
+	}
+
+	if err := z.Versions.Open(); err != nil {
+		return err
+	}
+	for i := range versions {
+		z.Versions.Row(&versions[i])
+	}
+	return nil
+}
+
+func (z *List) Test(c app_control.Control) error {
+	return qt_errors.ErrorHumanInteractionRequired
+}
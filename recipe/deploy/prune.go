@@ -0,0 +1,51 @@
+package deploy
+
+import (
+	"github.com/watermint/switchbox/domain/sb_deploy"
+	"github.com/watermint/toolbox/domain/dropbox/api/dbx_conn"
+	"github.com/watermint/toolbox/essentials/terminal/es_window"
+	"github.com/watermint/toolbox/infra/control/app_control"
+	"github.com/watermint/toolbox/infra/data/da_json"
+	"github.com/watermint/toolbox/infra/report/rp_model"
+	"github.com/watermint/toolbox/quality/infra/qt_errors"
+)
+
+type Prune struct {
+	Peer     dbx_conn.ConnScopedIndividual
+	Deploy   da_json.JsonInput
+	DryRun   bool
+	Hide     bool
+	Versions rp_model.RowReport
+}
+
+func (z *Prune) Preset() {
+	z.Peer = sb_deploy.NewBinSrcDropboxConn()
+	z.Deploy.SetModel(&sb_deploy.BinDeployRecipe{})
+	z.Versions.SetModel(&sb_deploy.BinDeployPruneResult{})
+}
+
+func (z *Prune) Exec(c app_control.Control) error {
+	l := c.Log()
+	if z.Hide {
+		es_window.HideConsole()
+		l.Info("Hide console")
+	}
+
+	worker, err := sb_deploy.NewBinDeploy(c, z.Deploy.FilePath(), z.Peer.PeerName())
+	if err != nil {
+		return err
+	}
+
+	if err := z.Versions.Open(); err != nil {
+		return err
+	}
+	results, err := worker.Prune(z.DryRun)
+	for _, r := range results {
+		z.Versions.Row(&r)
+	}
+	return err
+}
+
+func (z *Prune) Test(c app_control.Control) error {
+	return qt_errors.ErrorHumanInteractionRequired
+}
@@ -0,0 +1,52 @@
+package deploy
+
+import (
+	"github.com/watermint/switchbox/domain/sb_deploy"
+	"github.com/watermint/toolbox/domain/dropbox/api/dbx_conn"
+	"github.com/watermint/toolbox/essentials/log/esl"
+	"github.com/watermint/toolbox/essentials/model/mo_string"
+	"github.com/watermint/toolbox/essentials/terminal/es_window"
+	"github.com/watermint/toolbox/infra/control/app_control"
+	"github.com/watermint/toolbox/infra/data/da_json"
+	"github.com/watermint/toolbox/quality/infra/qt_errors"
+)
+
+type Rollback struct {
+	Peer    dbx_conn.ConnScopedIndividual
+	Deploy  da_json.JsonInput
+	Version mo_string.OptionalString
+	Clear   bool
+	Hide    bool
+}
+
+func (z *Rollback) Preset() {
+	z.Peer = sb_deploy.NewBinSrcDropboxConn()
+	z.Deploy.SetModel(&sb_deploy.BinDeployRecipe{})
+}
+
+func (z *Rollback) Exec(c app_control.Control) error {
+	l := c.Log()
+	if z.Hide {
+		es_window.HideConsole()
+		l.Info("Hide console")
+	}
+
+	worker, err := sb_deploy.NewBinDeploy(c, z.Deploy.FilePath(), z.Peer.PeerName())
+	if err != nil {
+		return err
+	}
+
+	if z.Clear {
+		return worker.ClearRollback()
+	}
+	version, err := worker.Rollback(z.Version.Value())
+	if err != nil {
+		return err
+	}
+	l.Info("Rolled back", esl.String("version", version.String()))
+	return nil
+}
+
+func (z *Rollback) Test(c app_control.Control) error {
+	return qt_errors.ErrorHumanInteractionRequired
+}
@@ -0,0 +1,51 @@
+package deploy
+
+import (
+	"github.com/watermint/switchbox/domain/sb_deploy"
+	"github.com/watermint/toolbox/domain/dropbox/api/dbx_conn"
+	"github.com/watermint/toolbox/essentials/terminal/es_window"
+	"github.com/watermint/toolbox/infra/control/app_control"
+	"github.com/watermint/toolbox/infra/data/da_json"
+	"github.com/watermint/toolbox/infra/report/rp_model"
+	"github.com/watermint/toolbox/quality/infra/qt_errors"
+)
+
+type Uninstall struct {
+	Peer   dbx_conn.ConnScopedIndividual
+	Deploy da_json.JsonInput
+	DryRun bool
+	Hide   bool
+	Files  rp_model.RowReport
+}
+
+func (z *Uninstall) Preset() {
+	z.Peer = sb_deploy.NewBinSrcDropboxConn()
+	z.Deploy.SetModel(&sb_deploy.BinDeployRecipe{})
+	z.Files.SetModel(&sb_deploy.BinDeployUninstallResult{})
+}
+
+func (z *Uninstall) Exec(c app_control.Control) error {
+	l := c.Log()
+	if z.Hide {
+		es_window.HideConsole()
+		l.Info("Hide console")
+	}
+
+	worker, err := sb_deploy.NewBinDeploy(c, z.Deploy.FilePath(), z.Peer.PeerName())
+	if err != nil {
+		return err
+	}
+
+	if err := z.Files.Open(); err != nil {
+		return err
+	}
+	results, err := worker.Uninstall(z.DryRun)
+	for _, r := range results {
+		z.Files.Row(&r)
+	}
+	return err
+}
+
+func (z *Uninstall) Test(c app_control.Control) error {
+	return qt_errors.ErrorHumanInteractionRequired
+}
@@ -0,0 +1,10 @@
+package deploy
+
+import (
+	"github.com/watermint/toolbox/quality/recipe/qtr_endtoend"
+	"testing"
+)
+
+func TestUninstall_Exec(t *testing.T) {
+	qtr_endtoend.TestRecipe(t, &Uninstall{})
+}
@@ -1,9 +1,10 @@
 package deploy
 
 import (
+	"errors"
 	"github.com/watermint/switchbox/domain/sb_deploy"
-	"github.com/watermint/toolbox/domain/dropbox/api/dbx_auth"
 	"github.com/watermint/toolbox/domain/dropbox/api/dbx_conn"
+	"github.com/watermint/toolbox/essentials/log/esl"
 	"github.com/watermint/toolbox/essentials/terminal/es_window"
 	"github.com/watermint/toolbox/infra/control/app_control"
 	"github.com/watermint/toolbox/infra/data/da_json"
@@ -18,12 +19,8 @@ type Update struct {
 }
 
 func (z *Update) Preset() {
-	z.Peer.SetScopes(
-		dbx_auth.ScopeFilesContentRead,
-		dbx_auth.ScopeFilesMetadataRead,
-		dbx_auth.ScopeSharingRead,
-	)
-	z.Deploy.SetModel(&sb_deploy.BinSrcDropboxDstLocalRecipe{})
+	z.Peer = sb_deploy.NewBinSrcDropboxConn()
+	z.Deploy.SetModel(&sb_deploy.BinDeployRecipe{})
 }
 
 func (z *Update) Exec(c app_control.Control) error {
@@ -33,24 +30,21 @@ func (z *Update) Exec(c app_control.Control) error {
 		l.Info("Hide console")
 	}
 
-	var deploy *sb_deploy.BinSrcDropboxDstLocalRecipe
-	if v, err := z.Deploy.Unmarshal(); err != nil {
+	worker, err := sb_deploy.NewBinDeploy(c, z.Deploy.FilePath(), z.Peer.PeerName())
+	if err != nil {
 		return err
-	} else {
-		deploy = v.(*sb_deploy.BinSrcDropboxDstLocalRecipe)
 	}
 
-	worker := sb_deploy.NewBinSrcDropboxDstLocal(*deploy, c, z.Peer.Client())
 	if z.Force {
-		if err := worker.UpdateForce(); err != nil {
-			return err
-		}
+		err = worker.UpdateForce()
 	} else {
-		if err := worker.UpdateIfRequired(); err != nil {
-			return err
-		}
+		err = worker.UpdateIfRequired()
+	}
+	var entryErr *sb_deploy.ArchiveEntryError
+	if errors.As(err, &entryErr) {
+		l.Error("The archive is rejected", esl.String("entry", entryErr.Name), esl.String("reason", entryErr.Err.Error()))
 	}
-	return nil
+	return err
 }
 
 func (z *Update) Test(c app_control.Control) error {
@@ -4,7 +4,6 @@ import (
 	"bufio"
 	"github.com/watermint/switchbox/domain/sb_deploy"
 	"github.com/watermint/switchbox/domain/sb_dispatch"
-	"github.com/watermint/toolbox/domain/dropbox/api/dbx_auth"
 	"github.com/watermint/toolbox/domain/dropbox/api/dbx_conn"
 	"github.com/watermint/toolbox/essentials/log/esl"
 	"github.com/watermint/toolbox/essentials/terminal/es_window"
@@ -23,13 +22,9 @@ type Run struct {
 }
 
 func (z *Run) Preset() {
-	z.Peer.SetScopes(
-		dbx_auth.ScopeFilesContentRead,
-		dbx_auth.ScopeFilesMetadataRead,
-		dbx_auth.ScopeSharingRead,
-	)
+	z.Peer = sb_deploy.NewBinSrcDropboxConn()
 	z.Runbook.SetModel(&sb_dispatch.BinRunbook{})
-	z.Deploy.SetModel(&sb_deploy.BinSrcDropboxDstLocalRecipe{})
+	z.Deploy.SetModel(&sb_deploy.BinDeployRecipe{})
 }
 
 func (z *Run) Exec(c app_control.Control) error {
@@ -45,19 +40,23 @@ func (z *Run) Exec(c app_control.Control) error {
 	} else {
 		runbook = v.(*sb_dispatch.BinRunbook)
 	}
-	var deploy *sb_deploy.BinSrcDropboxDstLocalRecipe
-	if v, err := z.Deploy.Unmarshal(); err != nil {
+	deployWorker, err := sb_deploy.NewBinDeploy(c, z.Deploy.FilePath(), z.Peer.PeerName())
+	if err != nil {
 		return err
-	} else {
-		deploy = v.(*sb_deploy.BinSrcDropboxDstLocalRecipe)
 	}
 
-	deployWorker := sb_deploy.NewBinSrcDropboxDstLocal(*deploy, c, z.Peer.Client())
 	if err := deployWorker.UpdateIfRequired(); err != nil {
-		return err
+		if deployWorker.LocalLatestBinaryPath() == "" {
+			return err
+		}
+		l.Warn("Unable to update, run the local latest version", esl.Error(err))
 	}
 
-	binPath := deployWorker.LocalLatestBinaryPath()
+	binPath, err := deployWorker.LocalLatestBinaryPathOf(runbook.Binary)
+	if err != nil {
+		l.Warn("Unable to find the binary", esl.String("binary", runbook.Binary), esl.Error(err))
+		return err
+	}
 	if binPath == "" {
 		c.Log().Warn("No binary found")
 		return nil